@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// TokenError reports why a JWT was rejected, Reason being a stable code,
+// e.g. "expired".
+type TokenError struct {
+	Reason string
+}
+
+func (e *TokenError) Error() string {
+	return fmt.Sprintf("Invalid token: %s.", e.Reason)
+}
+
+// Reasons of TokenError.
+const (
+	ReasonMalformed            = "malformed"
+	ReasonUnsupportedAlgorithm = "unsupported_algorithm"
+	ReasonUnknownKey           = "unknown_key"
+	ReasonBadSignature         = "bad_signature"
+	ReasonExpired              = "expired"
+	ReasonNotYetValid          = "not_yet_valid"
+	ReasonWrongIssuer          = "wrong_issuer"
+	ReasonWrongAudience        = "wrong_audience"
+	ReasonMissingSubject       = "missing_subject"
+)
+
+// Claims are the claims of a verified JWT.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	// Scopes are read from the space separated "scope" claim, or the "scp"
+	// array.
+	Scopes []string
+	// Raw holds every claim, e.g. a tenant ID.
+	Raw map[string]interface{}
+}
+
+// Allows reports whether the claims grant scope.
+func (c *Claims) Allows(scope string) bool {
+	return allows(c.Scopes, scope)
+}
+
+// String returns the named claim when it is a string, or "".
+func (c *Claims) String(name string) string {
+	value, _ := c.Raw[name].(string)
+	return value
+}
+
+// Verifier verifies JWTs signed with HS256 by a shared secret, or with RS256
+// by the private key of a public key it knows, and checks their claims.
+// Tokens must expire, and name their subject, which identifies the client.
+type Verifier struct {
+	secret []byte
+	keys   map[string]*rsa.PublicKey
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew in the exp and nbf checks.
+	Leeway time.Duration
+
+	now func() time.Time
+}
+
+// NewVerifier returns a verifier of HS256 tokens signed with secret and of
+// RS256 tokens signed for keys, by key ID. Either may be empty, disabling
+// its algorithm.
+func NewVerifier(secret []byte, keys map[string]*rsa.PublicKey) *Verifier {
+	return &Verifier{secret: secret, keys: keys, now: time.Now}
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Verify verifies the signature and the claims of token.
+func (v *Verifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, &TokenError{ReasonMalformed}
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, &TokenError{ReasonMalformed}
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(signed)
+
+	// The algorithm must be one configured, so a public key is never used
+	// as an HMAC secret.
+	switch {
+	case header.Algorithm == "HS256" && len(v.secret) > 0:
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, &TokenError{ReasonBadSignature}
+		}
+	case header.Algorithm == "RS256" && len(v.keys) > 0:
+		key, ok := v.keys[header.KeyID]
+		if !ok && header.KeyID == "" {
+			key, ok = v.onlyKey()
+		}
+		if !ok {
+			return nil, &TokenError{ReasonUnknownKey}
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, &TokenError{ReasonBadSignature}
+		}
+	default:
+		return nil, &TokenError{ReasonUnsupportedAlgorithm}
+	}
+
+	var raw map[string]interface{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, err
+	}
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.check(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// onlyKey returns the RSA key when there is a single one, which verifies
+// tokens without key ID.
+func (v *Verifier) onlyKey() (*rsa.PublicKey, bool) {
+	if len(v.keys) != 1 {
+		return nil, false
+	}
+	for _, key := range v.keys {
+		return key, true
+	}
+	return nil, false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return &TokenError{ReasonMalformed}
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return &TokenError{ReasonMalformed}
+	}
+	return nil
+}
+
+func parseClaims(raw map[string]interface{}) (*Claims, error) {
+	claims := &Claims{Raw: raw}
+	var ok bool
+	if claims.Issuer, ok = optionalString(raw, "iss"); !ok {
+		return nil, &TokenError{ReasonMalformed}
+	}
+	if claims.Subject, ok = optionalString(raw, "sub"); !ok {
+		return nil, &TokenError{ReasonMalformed}
+	}
+	if claims.Audience, ok = stringOrList(raw["aud"]); !ok {
+		return nil, &TokenError{ReasonMalformed}
+	}
+	if claims.ExpiresAt, ok = numericDate(raw, "exp"); !ok || claims.ExpiresAt.IsZero() {
+		return nil, &TokenError{ReasonMalformed}
+	}
+	if claims.NotBefore, ok = numericDate(raw, "nbf"); !ok {
+		return nil, &TokenError{ReasonMalformed}
+	}
+	if scope, ok := raw["scope"].(string); ok {
+		claims.Scopes = strings.Fields(scope)
+	} else if claims.Scopes, ok = stringOrList(raw["scp"]); !ok {
+		return nil, &TokenError{ReasonMalformed}
+	}
+	return claims, nil
+}
+
+func (v *Verifier) check(claims *Claims) error {
+	if claims.Subject == "" {
+		return &TokenError{ReasonMissingSubject}
+	}
+	now := v.now()
+	if !now.Before(claims.ExpiresAt.Add(v.Leeway)) {
+		return &TokenError{ReasonExpired}
+	}
+	if !claims.NotBefore.IsZero() && now.Add(v.Leeway).Before(claims.NotBefore) {
+		return &TokenError{ReasonNotYetValid}
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return &TokenError{ReasonWrongIssuer}
+	}
+	if v.Audience != "" {
+		for _, audience := range claims.Audience {
+			if audience == v.Audience {
+				return nil
+			}
+		}
+		return &TokenError{ReasonWrongAudience}
+	}
+	return nil
+}
+
+func optionalString(raw map[string]interface{}, name string) (string, bool) {
+	value, present := raw[name]
+	if !present {
+		return "", true
+	}
+	s, ok := value.(string)
+	return s, ok
+}
+
+// stringOrList reads a claim which is a string or an array of strings.
+func stringOrList(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return nil, true
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			list[i] = s
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// numericDate reads a claim of seconds since the epoch, the zero time when
+// it is missing.
+func numericDate(raw map[string]interface{}, name string) (time.Time, bool) {
+	value, present := raw[name]
+	if !present {
+		return time.Time{}, true
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// jwk is an RSA JSON Web Key.
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// LoadJWKS loads the RSA signing keys of a JSON Web Key Set file, by key ID.
+// Keys of other types or uses are ignored.
+func LoadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// minRSAKeyBits is the size under which RSA keys are too weak to be trusted.
+const minRSAKeyBits = 2048
+
+// ParseJWKS parses the RSA signing keys of a JSON Web Key Set, by key ID.
+// Keys under 2048 bits are rejected.
+func ParseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range set.Keys {
+		if key.KeyType != "RSA" || (key.Use != "" && key.Use != "sig") || (key.Algorithm != "" && key.Algorithm != "RS256") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("Invalid RSA key '%s'.", key.KeyID)
+		}
+		modulus := new(big.Int).SetBytes(n)
+		if modulus.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("RSA key '%s' has %d bits, under %d.", key.KeyID, modulus.BitLen(), minRSAKeyBits)
+		}
+		if _, ok := keys[key.KeyID]; ok {
+			return nil, fmt.Errorf("Key id '%s' is used twice.", key.KeyID)
+		}
+		keys[key.KeyID] = &rsa.PublicKey{
+			N: modulus,
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("No RSA signing key found.")
+	}
+	return keys, nil
+}
+
+// WithClaims returns a copy of ctx carrying the claims of a verified token.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext returns the claims verified for the request of ctx, or
+// nil.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsKey).(*Claims)
+	return claims
+}
+
+// Principal returns the client authenticated for the request of ctx, as
+// "key:" followed by the ID of its API key or "sub:" followed by the subject
+// of its JWT, or "" for anonymous requests, verified tokens always naming
+// their subject.
+func Principal(ctx context.Context) string {
+	if key := KeyFromContext(ctx); key != nil {
+		return "key:" + key.ID
+	}
+	if claims := ClaimsFromContext(ctx); claims != nil && claims.Subject != "" {
+		return "sub:" + claims.Subject
+	}
+	return ""
+}
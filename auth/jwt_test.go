@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+// sign returns a JWT of the claims, signed with alg by key: a []byte secret
+// for HS256 or an *rsa.PrivateKey for RS256.
+func sign(t *testing.T, header map[string]string, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwks returns a JSON Web Key Set of the public key.
+func jwks(kid string, key *rsa.PublicKey) []byte {
+	return []byte(fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": %q, "use": "sig", "alg": "RS256", "n": %q, "e": %q}, {"kty": "EC", "kid": "ignored"}]}`,
+		kid,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())))
+}
+
+func TestVerifier(t *testing.T) {
+	secret := []byte("shared-secret")
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ParseJWKS(jwks("key-1", &privateKey.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewVerifier(secret, keys)
+	verifier.Issuer = "https://issuer.example"
+	verifier.Audience = "matrix"
+	verifier.Leeway = 30 * time.Second
+	verifier.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":    "https://issuer.example",
+			"sub":    "user-1",
+			"aud":    []string{"other", "matrix"},
+			"exp":    now.Add(time.Minute).Unix(),
+			"scope":  "matrix:read matrix:compute",
+			"tenant": "acme",
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+	hs256 := map[string]string{"alg": "HS256", "typ": "JWT"}
+	rs256 := map[string]string{"alg": "RS256", "kid": "key-1"}
+
+	t.Run("valid tokens", func(t *testing.T) {
+		tokens := map[string]string{
+			"HS256":           sign(t, hs256, claims(nil), secret),
+			"RS256":           sign(t, rs256, claims(nil), privateKey),
+			"RS256 no kid":    sign(t, map[string]string{"alg": "RS256"}, claims(nil), privateKey),
+			"audience string": sign(t, hs256, claims(map[string]interface{}{"aud": "matrix"}), secret),
+			"within leeway":   sign(t, hs256, claims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix(), "nbf": now.Add(10 * time.Second).Unix()}), secret),
+		}
+		for name, token := range tokens {
+			got, err := verifier.Verify(token)
+			if err != nil {
+				t.Errorf("%v: got %v want no error", name, err)
+				continue
+			}
+			if got.Subject != "user-1" || got.String("tenant") != "acme" || !got.Allows("matrix:compute") || got.Allows("admin") {
+				t.Errorf("%v: got %+v want the claims", name, got)
+			}
+		}
+
+		got, err := verifier.Verify(sign(t, hs256, claims(map[string]interface{}{"scope": nil, "scp": []string{"matrix:read"}}), secret))
+		if err != nil || !got.Allows("matrix:read") || got.Allows("matrix:compute") {
+			t.Errorf("got %+v, %v want the scp scopes", got, err)
+		}
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		publicKeyBytes := privateKey.PublicKey.N.Bytes()
+		tokens := []struct {
+			name   string
+			token  string
+			reason string
+		}{
+			{"not a JWT", "api-key", ReasonMalformed},
+			{"bad base64", "a.b.c", ReasonMalformed},
+			{"alg none", sign(t, map[string]string{"alg": "none"}, claims(nil), nil), ReasonUnsupportedAlgorithm},
+			{"HS512", sign(t, map[string]string{"alg": "HS512"}, claims(nil), secret), ReasonUnsupportedAlgorithm},
+			{"wrong secret", sign(t, hs256, claims(nil), []byte("other")), ReasonBadSignature},
+			{"wrong private key", sign(t, rs256, claims(nil), otherKey), ReasonBadSignature},
+			{"unknown kid", sign(t, map[string]string{"alg": "RS256", "kid": "key-2"}, claims(nil), privateKey), ReasonUnknownKey},
+			{"expired", sign(t, hs256, claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()}), secret), ReasonExpired},
+			{"not yet valid", sign(t, hs256, claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}), secret), ReasonNotYetValid},
+			{"missing exp", sign(t, hs256, claims(map[string]interface{}{"exp": nil}), secret), ReasonMalformed},
+			{"string exp", sign(t, hs256, claims(map[string]interface{}{"exp": "tomorrow"}), secret), ReasonMalformed},
+			{"wrong issuer", sign(t, hs256, claims(map[string]interface{}{"iss": "https://other.example"}), secret), ReasonWrongIssuer},
+			{"wrong audience", sign(t, hs256, claims(map[string]interface{}{"aud": "other"}), secret), ReasonWrongAudience},
+			{"missing audience", sign(t, hs256, claims(map[string]interface{}{"aud": nil}), secret), ReasonWrongAudience},
+			{"missing sub", sign(t, hs256, claims(map[string]interface{}{"sub": nil}), secret), ReasonMissingSubject},
+			{"empty sub", sign(t, hs256, claims(map[string]interface{}{"sub": ""}), secret), ReasonMissingSubject},
+		}
+		for _, test := range tokens {
+			claims, err := verifier.Verify(test.token)
+			tokenError, ok := err.(*TokenError)
+			if !ok || tokenError.Reason != test.reason || claims != nil {
+				t.Errorf("%v: got %v, %v want %v", test.name, claims, err, test.reason)
+			}
+		}
+
+		// A verifier of RS256 tokens only must not verify HS256 tokens
+		// signed with the public key.
+		rsaOnly := NewVerifier(nil, keys)
+		rsaOnly.now = verifier.now
+		_, err := rsaOnly.Verify(sign(t, hs256, claims(nil), publicKeyBytes))
+		if tokenError, ok := err.(*TokenError); !ok || tokenError.Reason != ReasonUnsupportedAlgorithm {
+			t.Errorf("got %v want %v", err, ReasonUnsupportedAlgorithm)
+		}
+	})
+}
+
+func TestParseJWKS(t *testing.T) {
+	// modulus returns the base64url of a modulus of bits bits.
+	modulus := func(bits int) string {
+		return base64.RawURLEncoding.EncodeToString(bytes.Repeat([]byte{0xff}, bits/8))
+	}
+	key := `{"kty": "RSA", "kid": "a", "n": "` + modulus(2048) + `", "e": "AQAB"}`
+	if keys, err := ParseJWKS([]byte(`{"keys": [` + key + `]}`)); err != nil || keys["a"].N.BitLen() != 2048 {
+		t.Errorf("got %v, %v want the 2048 bits key", keys, err)
+	}
+
+	invalid := map[string]string{
+		"not JSON":     `keys`,
+		"no RSA key":   `{"keys": [{"kty": "EC", "kid": "a"}]}`,
+		"encryption":   `{"keys": [{"kty": "RSA", "kid": "a", "use": "enc", "n": "` + modulus(2048) + `", "e": "AQAB"}]}`,
+		"invalid n":    `{"keys": [{"kty": "RSA", "kid": "a", "n": "!", "e": "AQAB"}]}`,
+		"small key":    `{"keys": [{"kty": "RSA", "kid": "a", "n": "` + modulus(1024) + `", "e": "AQAB"}]}`,
+		"duplicate id": `{"keys": [` + key + `, ` + key + `]}`,
+	}
+	for name, data := range invalid {
+		if _, err := ParseJWKS([]byte(data)); err == nil {
+			t.Errorf("%v: got no error want invalid key set", name)
+		}
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		ctx  context.Context
+		want string
+	}{
+		{ctx, ""},
+		{WithClaims(ctx, &Claims{}), ""},
+		{WithClaims(ctx, &Claims{Subject: "alice"}), "sub:alice"},
+		{WithKey(WithClaims(ctx, &Claims{Subject: "alice"}), &Key{ID: "ci"}), "key:ci"},
+	}
+	for _, test := range tests {
+		if got := Principal(test.ctx); got != test.want {
+			t.Errorf("got %q want %q", got, test.want)
+		}
+	}
+}
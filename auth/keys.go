@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	reload "takehome/reload"
+)
+
+// AllScopes is the scope granting every other one.
+const AllScopes = "*"
+
+// Key is an API key, identified by an ID which is safe to log, unlike the
+// key itself.
+type Key struct {
+	ID     string   `json:"id"`
+	Scopes []string `json:"scopes"`
+	// SHA256 is the hex encoded SHA-256 digest of the key, so the key file
+	// holds no secret.
+	SHA256 string `json:"sha256"`
+}
+
+// Allows reports whether the key grants scope.
+func (k *Key) Allows(scope string) bool {
+	return allows(k.Scopes, scope)
+}
+
+// allows reports whether scopes, or AllScopes among them, grant scope.
+func allows(scopes []string, scope string) bool {
+	for _, granted := range scopes {
+		if granted == scope || granted == AllScopes {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFile is the format of the key file.
+type keyFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// Keys authenticates API keys against the keys of a file, which Watch
+// reloads when it changes. It is safe for concurrent use.
+type Keys struct {
+	file *reload.File
+
+	mu     sync.RWMutex
+	byHash map[string]*Key
+}
+
+// LoadKeys loads the keys of the JSON file at path, e.g.
+//
+//	{"keys": [{"id": "ci", "sha256": "<hex digest>", "scopes": ["matrix:read"]}]}
+func LoadKeys(path string) (*Keys, error) {
+	k := &Keys{file: reload.NewFile(path)}
+	if _, err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload reads the key file again if it changed since it was last read, and
+// reports whether it did. On error the previous keys are kept.
+func (k *Keys) Reload() (bool, error) {
+	return k.file.Reload(func(data []byte) error {
+		byHash, err := parseKeys(data)
+		if err != nil {
+			return err
+		}
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		k.byHash = byHash
+		return nil
+	})
+}
+
+func parseKeys(data []byte) (map[string]*Key, error) {
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	byHash := map[string]*Key{}
+	ids := map[string]bool{}
+	for i := range file.Keys {
+		key := &file.Keys[i]
+		digest, err := hex.DecodeString(key.SHA256)
+		if key.ID == "" || err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("Key %d needs an id and the hex SHA-256 digest of the key.", i+1)
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("Key id '%s' is used twice.", key.ID)
+		}
+		ids[key.ID] = true
+		hash := hex.EncodeToString(digest)
+		if other, ok := byHash[hash]; ok {
+			return nil, fmt.Errorf("Key %d has the same digest as key id '%s'.", i+1, other.ID)
+		}
+		byHash[hash] = key
+	}
+	return byHash, nil
+}
+
+// Authenticate returns the key matching secret, if any.
+func (k *Keys) Authenticate(secret string) (*Key, bool) {
+	if secret == "" {
+		return nil, false
+	}
+	// Keys are looked up by digest, so the lookup time tells nothing about
+	// the secrets.
+	digest := sha256.Sum256([]byte(secret))
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.byHash[hex.EncodeToString(digest[:])]
+	return key, ok
+}
+
+// Watch reloads the key file every interval until ctx is done, reporting
+// reloads and errors to onReload.
+func (k *Keys) Watch(ctx context.Context, interval time.Duration, onReload func(err error)) {
+	reload.Watch(ctx, interval, k.Reload, onReload)
+}
+
+type contextKey int
+
+const (
+	keyKey contextKey = iota
+	claimsKey
+)
+
+// WithKey returns a copy of ctx carrying the authenticated key.
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, keyKey, key)
+}
+
+// KeyFromContext returns the key authenticated for the request of ctx, or
+// nil.
+func KeyFromContext(ctx context.Context) *Key {
+	key, _ := ctx.Value(keyKey).(*Key)
+	return key
+}
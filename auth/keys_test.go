@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func digest(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeKeys writes a key file with one key per id, whose secret is id
+// followed by "-secret", and sets its modification time.
+func writeKeys(t *testing.T, path string, modTime time.Time, scopes map[string]string) {
+	t.Helper()
+	var keys []string
+	for id, scope := range scopes {
+		keys = append(keys, fmt.Sprintf(`{"id": %q, "sha256": %q, "scopes": [%q]}`, id, digest(id+"-secret"), scope))
+	}
+	data := `{"keys": [` + strings.Join(keys, ",") + `]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	modTime := time.Now().Add(-time.Hour)
+	writeKeys(t, path, modTime, map[string]string{"reader": "matrix:read", "admin": AllScopes})
+
+	keys, err := LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("authenticate", func(t *testing.T) {
+		key, ok := keys.Authenticate("reader-secret")
+		if !ok || key.ID != "reader" {
+			t.Fatalf("got %v, %v want reader", key, ok)
+		}
+		if !key.Allows("matrix:read") || key.Allows("matrix:compute") {
+			t.Errorf("got scopes %v want matrix:read only", key.Scopes)
+		}
+		if admin, _ := keys.Authenticate("admin-secret"); !admin.Allows("matrix:compute") {
+			t.Errorf("got scopes %v want every scope", admin.Scopes)
+		}
+		for _, secret := range []string{"", "unknown-secret", digest("reader-secret")} {
+			if key, ok := keys.Authenticate(secret); ok {
+				t.Errorf("%q: got %v want no key", secret, key)
+			}
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		if reloaded, err := keys.Reload(); reloaded || err != nil {
+			t.Errorf("got %v, %v want unchanged file not reloaded", reloaded, err)
+		}
+
+		writeKeys(t, path, modTime.Add(time.Minute), map[string]string{"writer": "matrix:compute"})
+		if reloaded, err := keys.Reload(); !reloaded || err != nil {
+			t.Fatalf("got %v, %v want changed file reloaded", reloaded, err)
+		}
+		if _, ok := keys.Authenticate("reader-secret"); ok {
+			t.Errorf("got removed key authenticated")
+		}
+		if _, ok := keys.Authenticate("writer-secret"); !ok {
+			t.Errorf("got added key not authenticated")
+		}
+
+		ioutil.WriteFile(path, []byte(`{"keys": [{"id": "broken"}]}`), 0600)
+		if _, err := keys.Reload(); err == nil {
+			t.Errorf("got no error want invalid key")
+		}
+		if _, ok := keys.Authenticate("writer-secret"); !ok {
+			t.Errorf("got previous keys dropped want them kept")
+		}
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		writeKeys(t, path, modTime.Add(2*time.Minute), map[string]string{"watched": "matrix:read"})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		reloads := make(chan error, 1)
+		go keys.Watch(ctx, time.Millisecond, func(err error) {
+			select {
+			case reloads <- err:
+			default:
+			}
+		})
+
+		select {
+		case err := <-reloads:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("got no reload")
+		}
+		if _, ok := keys.Authenticate("watched-secret"); !ok {
+			t.Errorf("got watched key not authenticated")
+		}
+	})
+}
+
+func TestLoadKeys(t *testing.T) {
+	invalid := map[string]string{
+		"not JSON":       `keys`,
+		"missing id":     `{"keys": [{"sha256": "` + digest("secret") + `"}]}`,
+		"invalid digest": `{"keys": [{"id": "a", "sha256": "secret"}]}`,
+		"duplicate id":   `{"keys": [{"id": "a", "sha256": "` + digest("1") + `"}, {"id": "a", "sha256": "` + digest("2") + `"}]}`,
+		"duplicate key":  `{"keys": [{"id": "a", "sha256": "` + digest("1") + `"}, {"id": "b", "sha256": "` + strings.ToUpper(digest("1")) + `"}]}`,
+	}
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			ioutil.WriteFile(path, []byte(data), 0600)
+			if _, err := LoadKeys(path); err == nil {
+				t.Errorf("got no error want invalid key file")
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	ioutil.WriteFile(path, []byte(invalid["duplicate key"]), 0600)
+	if _, err := LoadKeys(path); err == nil || !strings.Contains(err.Error(), "Key 2 has the same digest as key id 'a'.") {
+		t.Errorf("got %v want the duplicate key named", err)
+	}
+
+	if _, err := LoadKeys(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("got no error want missing file")
+	}
+}
@@ -0,0 +1,221 @@
+package cache
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options bounds a cache, a zero field disabling its bound.
+type Options struct {
+	// MaxEntries and MaxBytes bound the entries held in memory. Values
+	// larger than MaxBytes are not cached.
+	MaxEntries int
+	MaxBytes   int64
+	// TTL is how long an entry may be served after it is set.
+	TTL time.Duration
+	// Dir, when set, receives the entries evicted from memory, up to
+	// MaxDiskBytes, so they are still served until they expire.
+	Dir          string
+	MaxDiskBytes int64
+}
+
+// Cache is an LRU cache of byte values by key, in memory and optionally
+// spilled to disk. Keys must be valid file names, e.g. hex digests. It is
+// safe for concurrent use.
+type Cache struct {
+	options Options
+	now     func() time.Time
+
+	mu        sync.Mutex
+	memory    *list.List
+	byKey     map[string]*list.Element
+	bytes     int64
+	disk      *list.List
+	onDisk    map[string]*list.Element
+	diskBytes int64
+}
+
+type entry struct {
+	key    string
+	value  []byte
+	size   int64
+	stored time.Time
+}
+
+// New returns an empty cache. With a Dir, the entries left on disk by a
+// previous process are served until they expire.
+func New(options Options) (*Cache, error) {
+	c := &Cache{
+		options: options,
+		now:     time.Now,
+		memory:  list.New(),
+		byKey:   map[string]*list.Element{},
+		disk:    list.New(),
+		onDisk:  map[string]*list.Element{},
+	}
+	if options.Dir != "" {
+		if err := os.MkdirAll(options.Dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := c.loadDisk(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// loadDisk indexes the entries of Dir, oldest first.
+func (c *Cache) loadDisk() error {
+	infos, err := ioutil.ReadDir(c.options.Dir)
+	if err != nil {
+		return err
+	}
+	var entries []*entry
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if filepath.Ext(info.Name()) == ".tmp" {
+			os.Remove(filepath.Join(c.options.Dir, info.Name()))
+			continue
+		}
+		entries = append(entries, &entry{key: info.Name(), size: info.Size(), stored: info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].stored.Before(entries[j].stored) })
+	for _, e := range entries {
+		c.onDisk[e.key] = c.disk.PushFront(e)
+		c.diskBytes += e.size
+	}
+	c.trimDisk()
+	return nil
+}
+
+func (c *Cache) expired(e *entry) bool {
+	return c.options.TTL > 0 && c.now().Sub(e.stored) >= c.options.TTL
+}
+
+// Get returns the value of key, if it is cached and not expired.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.byKey[key]; ok {
+		e := element.Value.(*entry)
+		if c.expired(e) {
+			c.removeMemory(element)
+			return nil, false
+		}
+		c.memory.MoveToFront(element)
+		return e.value, true
+	}
+
+	element, ok := c.onDisk[key]
+	if !ok {
+		return nil, false
+	}
+	e := element.Value.(*entry)
+	var value []byte
+	var err error
+	if !c.expired(e) {
+		value, err = ioutil.ReadFile(filepath.Join(c.options.Dir, key))
+	}
+	c.removeDisk(element)
+	if c.expired(e) || err != nil {
+		return nil, false
+	}
+	// The entry moves back to memory, keeping its age.
+	c.add(&entry{key: key, value: value, size: int64(len(value)), stored: e.stored})
+	return value, true
+}
+
+// Set caches value for key, evicting the least recently used entries beyond
+// the bounds.
+func (c *Cache) Set(key string, value []byte) {
+	size := int64(len(value))
+	if c.options.MaxBytes > 0 && size > c.options.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.byKey[key]; ok {
+		c.removeMemory(element)
+	}
+	if element, ok := c.onDisk[key]; ok {
+		c.removeDisk(element)
+	}
+	c.add(&entry{key: key, value: value, size: size, stored: c.now()})
+}
+
+// MaxBytes returns the size of the largest value the cache holds, 0 for no
+// limit.
+func (c *Cache) MaxBytes() int64 {
+	return c.options.MaxBytes
+}
+
+// Len returns the number of entries in memory and on disk.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.memory.Len() + c.disk.Len()
+}
+
+func (c *Cache) add(e *entry) {
+	c.byKey[e.key] = c.memory.PushFront(e)
+	c.bytes += e.size
+	for c.memory.Len() > 0 && ((c.options.MaxEntries > 0 && c.memory.Len() > c.options.MaxEntries) ||
+		(c.options.MaxBytes > 0 && c.bytes > c.options.MaxBytes)) {
+		oldest := c.memory.Back()
+		c.removeMemory(oldest)
+		c.spill(oldest.Value.(*entry))
+	}
+}
+
+func (c *Cache) removeMemory(element *list.Element) {
+	e := c.memory.Remove(element).(*entry)
+	delete(c.byKey, e.key)
+	c.bytes -= e.size
+}
+
+// spill writes an entry evicted from memory to disk, unless it expired.
+func (c *Cache) spill(e *entry) {
+	if c.options.Dir == "" || c.expired(e) {
+		return
+	}
+	if c.options.MaxDiskBytes > 0 && e.size > c.options.MaxDiskBytes {
+		return
+	}
+	path := filepath.Join(c.options.Dir, e.key)
+	// Write then rename, so readers never see a partial entry.
+	if err := ioutil.WriteFile(path+".tmp", e.value, 0600); err != nil {
+		return
+	}
+	if err := os.Chtimes(path+".tmp", e.stored, e.stored); err != nil {
+		os.Remove(path + ".tmp")
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return
+	}
+	c.onDisk[e.key] = c.disk.PushFront(&entry{key: e.key, size: e.size, stored: e.stored})
+	c.diskBytes += e.size
+	c.trimDisk()
+}
+
+// trimDisk removes the oldest entries on disk beyond MaxDiskBytes.
+func (c *Cache) trimDisk() {
+	for c.disk.Len() > 0 && c.options.MaxDiskBytes > 0 && c.diskBytes > c.options.MaxDiskBytes {
+		c.removeDisk(c.disk.Back())
+	}
+}
+
+func (c *Cache) removeDisk(element *list.Element) {
+	e := c.disk.Remove(element).(*entry)
+	delete(c.onDisk, e.key)
+	c.diskBytes -= e.size
+	os.Remove(filepath.Join(c.options.Dir, e.key))
+}
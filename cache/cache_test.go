@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	newCache := func(t *testing.T, options Options) *Cache {
+		c, err := New(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.now = func() time.Time { return now }
+		return c
+	}
+	get := func(c *Cache, key string) string {
+		value, ok := c.Get(key)
+		if !ok {
+			return "<miss>"
+		}
+		return string(value)
+	}
+
+	t.Run("LRU", func(t *testing.T) {
+		c := newCache(t, Options{MaxEntries: 2})
+		c.Set("a", []byte("1"))
+		c.Set("b", []byte("2"))
+		get(c, "a")
+		c.Set("c", []byte("3"))
+		for key, want := range map[string]string{"a": "1", "b": "<miss>", "c": "3"} {
+			if got := get(c, key); got != want {
+				t.Errorf("%v: got %v want %v", key, got, want)
+			}
+		}
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		c := newCache(t, Options{MaxBytes: 4})
+		c.Set("a", []byte("12"))
+		c.Set("b", []byte("34"))
+		c.Set("c", []byte("5"))
+		c.Set("large", []byte("12345"))
+		if got := get(c, "a"); got != "<miss>" {
+			t.Errorf("got %v want a evicted", got)
+		}
+		if got := get(c, "large"); got != "<miss>" {
+			t.Errorf("got %v want values above MaxBytes not cached", got)
+		}
+		if c.Len() != 2 {
+			t.Errorf("got %v entries want 2", c.Len())
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		c := newCache(t, Options{TTL: time.Minute})
+		c.Set("a", []byte("1"))
+		now = now.Add(59 * time.Second)
+		if got := get(c, "a"); got != "1" {
+			t.Errorf("got %v want 1", got)
+		}
+		now = now.Add(time.Second)
+		if got := get(c, "a"); got != "<miss>" {
+			t.Errorf("got %v want expired", got)
+		}
+	})
+
+	t.Run("disk", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "cache")
+		c := newCache(t, Options{MaxEntries: 1, TTL: time.Hour, Dir: dir, MaxDiskBytes: 4})
+		c.Set("a", []byte("11"))
+		c.Set("b", []byte("22"))
+		c.Set("c", []byte("33"))
+		if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+			t.Errorf("got %v files want a and b spilled", len(files))
+		}
+
+		c.Set("d", []byte("44"))
+		if got := get(c, "a"); got != "<miss>" {
+			t.Errorf("got %v want a trimmed from disk", got)
+		}
+		if got := get(c, "b"); got != "22" {
+			t.Errorf("got %v want b read back from disk", got)
+		}
+
+		// A new cache serves the entries left on disk until they expire.
+		reopened := newCache(t, Options{MaxEntries: 1, TTL: time.Hour, Dir: dir})
+		if got := get(reopened, "c"); got != "33" {
+			t.Errorf("got %v want c served after a restart", got)
+		}
+		now = now.Add(time.Hour)
+		if got := get(reopened, "d"); got != "<miss>" {
+			t.Errorf("got %v want d expired", got)
+		}
+	})
+}
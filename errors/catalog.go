@@ -0,0 +1,131 @@
+package errors
+
+import "net/http"
+
+// Code is a stable machine-readable error code. Codes never change once
+// published, clients can rely on them instead of matching messages.
+type Code string
+
+const (
+	CodeFileNotFound         Code = "FILE_NOT_FOUND"
+	CodeInvalidCSV           Code = "INVALID_CSV"
+	CodeUnsupportedEncoding  Code = "UNSUPPORTED_ENCODING"
+	CodeInvalidCompression   Code = "INVALID_COMPRESSION"
+	CodeCellNotNumeric       Code = "CELL_NOT_NUMERIC"
+	CodePayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	CodeLimitExceeded        Code = "LIMIT_EXCEEDED"
+	CodeMatrixNotProvided    Code = "MATRIX_NOT_PROVIDED"
+	CodeMatrixEmpty          Code = "MATRIX_EMPTY"
+	CodeMatrixNotSquare      Code = "MATRIX_NOT_SQUARE"
+	CodeMatrixNotVector      Code = "MATRIX_NOT_VECTOR"
+	CodeMatrixTooLarge       Code = "MATRIX_TOO_LARGE"
+	CodeShapeMismatch        Code = "SHAPE_MISMATCH"
+	CodeInvalidParameter     Code = "INVALID_PARAMETER"
+	CodeOverflow             Code = "OVERFLOW"
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeMatrixNotFound       Code = "MATRIX_NOT_FOUND"
+	CodeVersionNotFound      Code = "VERSION_NOT_FOUND"
+	CodeVersionConflict      Code = "VERSION_CONFLICT"
+	CodeInvalidPatch         Code = "INVALID_PATCH"
+	CodeCellOutOfRange       Code = "CELL_OUT_OF_RANGE"
+	CodeInvalidBatch         Code = "INVALID_BATCH"
+	CodeJobNotFound          Code = "JOB_NOT_FOUND"
+	CodeJobQueueFull         Code = "JOB_QUEUE_FULL"
+	CodeJobFinished          Code = "JOB_FINISHED"
+	CodeJobResultUnavailable Code = "JOB_RESULT_UNAVAILABLE"
+	CodeMethodNotAllowed     Code = "METHOD_NOT_ALLOWED"
+	CodeUnauthorized         Code = "UNAUTHORIZED"
+	CodeInvalidToken         Code = "INVALID_TOKEN"
+	CodeForbidden            Code = "FORBIDDEN"
+	CodeRateLimited          Code = "RATE_LIMITED"
+	CodeInternalError        Code = "INTERNAL_ERROR"
+	CodeUnknown              Code = "UNKNOWN_ERROR"
+)
+
+// CatalogEntry documents an error code.
+type CatalogEntry struct {
+	Code        Code   `json:"code"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// Catalog lists every error code the service may return.
+var Catalog = []CatalogEntry{
+	{CodeFileNotFound, http.StatusBadRequest, "File not found",
+		"The request has no multipart file named 'file'."},
+	{CodeInvalidCSV, http.StatusBadRequest, "Invalid CSV",
+		"The uploaded file is not valid CSV, e.g. rows have different lengths."},
+	{CodeUnsupportedEncoding, http.StatusUnsupportedMediaType, "Unsupported encoding",
+		"The Content-Encoding of the request body is not supported, only gzip is."},
+	{CodeInvalidCompression, http.StatusBadRequest, "Invalid compression",
+		"The request body or the uploaded file is not valid gzip data."},
+	{CodeCellNotNumeric, http.StatusBadRequest, "Cell not numeric",
+		"One or more cells are not integers, see the errors member for their location."},
+	{CodePayloadTooLarge, http.StatusRequestEntityTooLarge, "Payload too large",
+		"The request body exceeds the configured size limit, named by the limit member."},
+	{CodeLimitExceeded, http.StatusUnprocessableEntity, "Limit exceeded",
+		"The matrix or the batch exceeds a configured limit, named by the limit member."},
+	{CodeMatrixNotProvided, http.StatusBadRequest, "Matrix not provided",
+		"The operation was called without a matrix."},
+	{CodeMatrixEmpty, http.StatusUnprocessableEntity, "Matrix is empty",
+		"The operation requires at least one cell."},
+	{CodeMatrixNotSquare, http.StatusUnprocessableEntity, "Matrix is not square",
+		"The operation requires as many rows as columns."},
+	{CodeMatrixNotVector, http.StatusUnprocessableEntity, "Matrix is not a vector",
+		"The operation requires a single row or a single column."},
+	{CodeMatrixTooLarge, http.StatusUnprocessableEntity, "Matrix is too large",
+		"The matrix has more cells than the operation accepts."},
+	{CodeShapeMismatch, http.StatusUnprocessableEntity, "Shape mismatch",
+		"The matrix does not have the shape given by expect_shape."},
+	{CodeInvalidParameter, http.StatusBadRequest, "Invalid parameter",
+		"A query parameter has an invalid value."},
+	{CodeOverflow, http.StatusUnprocessableEntity, "Integer overflow",
+		"The result does not fit in a signed integer."},
+	{CodeNotFound, http.StatusNotFound, "Not found",
+		"No endpoint is served at the requested path."},
+	{CodeMatrixNotFound, http.StatusNotFound, "Matrix not found",
+		"No matrix is stored with the requested ID, or it belongs to another client."},
+	{CodeVersionNotFound, http.StatusNotFound, "Version not found",
+		"The stored matrix has no version with the requested number."},
+	{CodeVersionConflict, http.StatusConflict, "Version conflict",
+		"The stored matrix was updated since the version the update is based on, see the version member for the latest one."},
+	{CodeInvalidPatch, http.StatusBadRequest, "Invalid patch",
+		"The PATCH body is not a JSON object setting at least one cell to an integer, see the reason member: malformed or no_cells."},
+	{CodeCellOutOfRange, http.StatusUnprocessableEntity, "Cell out of range",
+		"A cell of the patch is outside the stored matrix, see the row and col members."},
+	{CodeInvalidBatch, http.StatusBadRequest, "Invalid batch",
+		"The batch, or one of its items, is not valid, see the reason member: malformed, no_items or invalid_matrix."},
+	{CodeJobNotFound, http.StatusNotFound, "Job not found",
+		"No job has the requested ID, or it belongs to another client."},
+	{CodeJobQueueFull, http.StatusServiceUnavailable, "Job queue full",
+		"Too many jobs are queued, see the Retry-After header."},
+	{CodeJobFinished, http.StatusConflict, "Job finished",
+		"The job cannot be canceled as it already finished, see the job_status member."},
+	{CodeJobResultUnavailable, http.StatusConflict, "Job result unavailable",
+		"The job has no result, as it is still queued or running, was canceled or was interrupted, see the job_status member."},
+	{CodeMethodNotAllowed, http.StatusMethodNotAllowed, "Method not allowed",
+		"The HTTP method is not supported by the endpoint, see the Allow header."},
+	{CodeUnauthorized, http.StatusUnauthorized, "Unauthorized",
+		"The request has no API key, or an unknown one, see the WWW-Authenticate header."},
+	{CodeInvalidToken, http.StatusUnauthorized, "Invalid token",
+		"The bearer token is not a valid JWT, see the reason member, e.g. expired or bad_signature."},
+	{CodeForbidden, http.StatusForbidden, "Forbidden",
+		"The API key or token lacks the scope required by the operation."},
+	{CodeRateLimited, http.StatusTooManyRequests, "Too many requests",
+		"The client exceeded its rate or concurrency limit, named by the limit member, see the Retry-After header."},
+	{CodeInternalError, http.StatusInternalServerError, "Internal server error",
+		"An unexpected error occurred on the server."},
+	{CodeUnknown, http.StatusInternalServerError, "Unknown error",
+		"An error was reported with a code missing from this catalog."},
+}
+
+// Lookup returns the catalog entry of code.
+func Lookup(code Code) (CatalogEntry, bool) {
+	for _, entry := range Catalog {
+		if entry.Code == code {
+			return entry, true
+		}
+	}
+	return CatalogEntry{}, false
+}
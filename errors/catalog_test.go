@@ -0,0 +1,160 @@
+package errors
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// declaredCodes returns the Code constants declared in catalog.go by name.
+func declaredCodes(t *testing.T) map[string]Code {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "catalog.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes := map[string]Code{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		if typ, ok := spec.Type.(*ast.Ident); !ok || typ.Name != "Code" {
+			return true
+		}
+		for i, name := range spec.Names {
+			value, _ := strconv.Unquote(spec.Values[i].(*ast.BasicLit).Value)
+			codes[name.Name] = Code(value)
+		}
+		return true
+	})
+	return codes
+}
+
+func TestCatalog(t *testing.T) {
+
+	t.Run("every declared code is cataloged", func(t *testing.T) {
+		for name, code := range declaredCodes(t) {
+			if _, ok := Lookup(code); !ok {
+				t.Errorf("%s (%s) is missing from the catalog", name, code)
+			}
+		}
+	})
+
+	t.Run("catalog entries are unique and complete", func(t *testing.T) {
+		seen := map[Code]bool{}
+		for _, entry := range Catalog {
+			if seen[entry.Code] {
+				t.Errorf("%s is cataloged twice", entry.Code)
+			}
+			seen[entry.Code] = true
+			if entry.Status < 400 || entry.Title == "" || entry.Description == "" {
+				t.Errorf("%s has an incomplete entry: %+v", entry.Code, entry)
+			}
+		}
+	})
+}
+
+// TestErrorPathsUseCatalogedCodes parses every source file of the module and
+// checks that each NewHTTPError call passes a cataloged Code constant, or
+// calls a function of the same package whose every return is one.
+func TestErrorPathsUseCatalogedCodes(t *testing.T) {
+	codes := declaredCodes(t)
+
+	err := filepath.Walk("..", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") && path != ".." {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+
+		fset := token.NewFileSet()
+		pkgs, err := parser.ParseDir(fset, filepath.Dir(path), func(fi os.FileInfo) bool {
+			return !strings.HasSuffix(fi.Name(), "_test.go")
+		}, 0)
+		if err != nil {
+			return err
+		}
+		funcs := map[string]*ast.FuncDecl{}
+		for _, pkg := range pkgs {
+			for _, f := range pkg.Files {
+				for _, decl := range f.Decls {
+					if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+						funcs[fn.Name.Name] = fn
+					}
+				}
+			}
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || calleeName(call.Fun) != "NewHTTPError" || len(call.Args) != 3 {
+				return true
+			}
+			if !isCatalogedCode(call.Args[1], codes, funcs, 0) {
+				t.Errorf("%s: NewHTTPError called without a cataloged code", fset.Position(call.Pos()))
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func calleeName(fun ast.Expr) string {
+	switch f := fun.(type) {
+	case *ast.Ident:
+		return f.Name
+	case *ast.SelectorExpr:
+		return f.Sel.Name
+	}
+	return ""
+}
+
+func isCatalogedCode(expr ast.Expr, codes map[string]Code, funcs map[string]*ast.FuncDecl, depth int) bool {
+	switch e := expr.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+		code, ok := codes[calleeName(e)]
+		if !ok {
+			return false
+		}
+		_, ok = Lookup(code)
+		return ok
+	case *ast.CallExpr:
+		fn, ok := funcs[calleeName(e.Fun)]
+		if !ok || depth > 3 {
+			return false
+		}
+		cataloged := true
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			if _, ok := n.(*ast.FuncLit); ok {
+				return false
+			}
+			if ret, ok := n.(*ast.ReturnStmt); ok {
+				cataloged = cataloged && len(ret.Results) > 0 && isCatalogedCode(ret.Results[0], codes, funcs, depth+1)
+			}
+			return true
+		})
+		return cataloged
+	}
+	return false
+}
@@ -3,8 +3,22 @@ package errors
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+
+	logging "takehome/logging"
 )
 
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// DefaultType is the problem type used when none is given, meaning the
+// problem has no semantics beyond its HTTP status code.
+const DefaultType = "about:blank"
+
+// TypeBaseURI prefixes the code of a problem to build its type, which
+// resolves to the code documentation served by the catalog endpoint.
+const TypeBaseURI = "/errors#"
+
 // ClientError is an error whose details to be shared with client.
 type ClientError interface {
 	Error() string
@@ -14,11 +28,43 @@ type ClientError interface {
 	ResponseHeaders() (int, map[string]string)
 }
 
-// HTTPError implements ClientError interface.
+// HTTPError implements ClientError interface as an RFC 7807 problem details
+// object.
 type HTTPError struct {
-	Cause  error  `json:"-"`
+	Cause    error  `json:"-"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance,omitempty"`
+	// Code is the stable machine-readable code of the problem.
+	Code Code `json:"code,omitempty"`
+	// Errors lists the individual problems reported together, e.g. every
+	// non numeric cell.
+	Errors []Item `json:"errors,omitempty"`
+	// Params holds the values substituted in the localized detail.
+	Params Params `json:"-"`
+	// Extensions holds extension members serialized next to the standard
+	// ones, e.g. "actual_shape". Members named as standard ones are skipped.
+	Extensions map[string]interface{} `json:"-"`
+}
+
+// standardMembers are the names of the members serialized from the fields
+// of HTTPError, which extensions may not override.
+var standardMembers = map[string]bool{
+	"type": true, "title": true, "status": true, "detail": true,
+	"instance": true, "code": true, "errors": true,
+}
+
+// Item is a member of the errors extension. Row and Col are 1-based and
+// omitted when the problem is not located in a cell.
+type Item struct {
+	Row    int    `json:"row,omitempty"`
+	Col    int    `json:"col,omitempty"`
+	Value  string `json:"value"`
 	Detail string `json:"detail"`
-	Status int    `json:"-"`
+	// Params holds the values substituted in the localized detail.
+	Params Params `json:"-"`
 }
 
 func (e *HTTPError) Error() string {
@@ -28,6 +74,72 @@ func (e *HTTPError) Error() string {
 	return e.Detail + " : " + e.Cause.Error()
 }
 
+// With sets the extension member key to value and returns e.
+func (e *HTTPError) With(key string, value interface{}) *HTTPError {
+	if e.Extensions == nil {
+		e.Extensions = map[string]interface{}{}
+	}
+	e.Extensions[key] = value
+	return e
+}
+
+// WithItems adds items, whose details are rendered from the ItemMessages of
+// the problem code, to the errors extension and returns e.
+func (e *HTTPError) WithItems(items ...Item) *HTTPError {
+	for _, item := range items {
+		item.Detail = ItemMessage(DefaultLocale, e.Code, item.Params)
+		e.Errors = append(e.Errors, item)
+	}
+	return e
+}
+
+// Localize renders the detail of the problem and of its items in locale.
+// Problems without a code keep their detail.
+func (e *HTTPError) Localize(locale Locale) {
+	if e.Code == "" {
+		return
+	}
+	e.Detail = Message(locale, e.Code, e.Params)
+	for i := range e.Errors {
+		e.Errors[i].Detail = ItemMessage(locale, e.Code, e.Errors[i].Params)
+	}
+}
+
+// MarshalJSON serializes the standard members, filling in the defaults for
+// type and title, followed by the extension members, skipping those which
+// would duplicate a standard member.
+func (e *HTTPError) MarshalJSON() ([]byte, error) {
+	type problem HTTPError // Drops the MarshalJSON method to avoid recursion.
+	p := problem(*e)
+	if p.Type == "" {
+		p.Type = DefaultType
+	}
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]interface{}, len(e.Extensions))
+	for name, value := range e.Extensions {
+		if !standardMembers[name] {
+			members[name] = value
+		}
+	}
+	if len(members) == 0 {
+		return body, nil
+	}
+
+	extensions, err := json.Marshal(members)
+	if err != nil {
+		return nil, err
+	}
+	// Merge both objects: `{"type":...}` + `{"errors":...}`.
+	body = append(body[:len(body)-1], ',')
+	return append(body, extensions[1:]...), nil
+}
+
 // ResponseBody returns JSON response body.
 func (e *HTTPError) ResponseBody() ([]byte, error) {
 	body, err := json.Marshal(e)
@@ -40,14 +152,63 @@ func (e *HTTPError) ResponseBody() ([]byte, error) {
 // ResponseHeaders returns http status code and headers.
 func (e *HTTPError) ResponseHeaders() (int, map[string]string) {
 	return e.Status, map[string]string{
-		"Content-Type": "application/json; charset=utf-8",
+		"Content-Type": ProblemContentType,
 	}
 }
 
-func NewHTTPError(err error, status int, detail string) error {
+// NewHTTPError returns the problem identified by code, its status and title
+// come from the Catalog and its detail is rendered in English from params.
+// Codes missing from the Catalog are reported as CodeUnknown.
+func NewHTTPError(err error, code Code, params Params) *HTTPError {
+	entry, ok := Lookup(code)
+	if !ok {
+		entry, _ = Lookup(CodeUnknown)
+	}
 	return &HTTPError{
 		Cause:  err,
-		Detail: detail,
-		Status: status,
+		Type:   TypeBaseURI + string(entry.Code),
+		Title:  entry.Title,
+		Detail: Message(DefaultLocale, entry.Code, params),
+		Status: entry.Status,
+		Code:   entry.Code,
+		Params: params,
+	}
+}
+
+// WriteResponse writes err to w as a problem details response in the language
+// negotiated from the Accept-Language header of r. Errors which are not a
+// ClientError are reported as 500 Internal Server Error without leaking their
+// cause.
+func WriteResponse(w http.ResponseWriter, r *http.Request, err error) {
+	clientError, ok := err.(ClientError) // Check if it is a ClientError.
+	if !ok {
+		// If the error is not ClientError, assume that it is ServerError.
+		clientError = NewHTTPError(err, CodeInternalError, nil)
+	}
+	if httpError, ok := clientError.(*HTTPError); ok && r != nil {
+		if httpError.Instance == "" {
+			httpError.Instance = r.URL.Path
+		}
+		if id := logging.RequestID(r.Context()); id != "" {
+			httpError.With("request_id", id)
+		}
+		logging.Annotate(r.Context(), "error_code", httpError.Code)
+		if httpError.Code != "" {
+			locale := NegotiateLocale(r.Header.Get("Accept-Language"))
+			httpError.Localize(locale)
+			w.Header().Set("Content-Language", string(locale))
+		}
+	}
+
+	body, err := clientError.ResponseBody() // Try to get response body of ClientError.
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	status, headers := clientError.ResponseHeaders() // Get http status code and headers.
+	for k, v := range headers {
+		w.Header().Set(k, v)
 	}
+	w.WriteHeader(status)
+	w.Write(body)
 }
@@ -1,9 +1,11 @@
 package errors
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 )
@@ -51,31 +53,121 @@ func TestError(t *testing.T) {
 func TestResponseBody(t *testing.T) {
 
 	t.Run("correct error with cause", func(t *testing.T) {
-		want := []byte(fmt.Sprintf(`{"detail":"%s"}`, detail))
+		want := []byte(fmt.Sprintf(`{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"%s"}`, detail))
 		got, _ := errorWithCause.ResponseBody()
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("got %v want %v", string(got), string(want))
 		}
 	})
+
+	t.Run("correct error with extensions", func(t *testing.T) {
+		problem := NewHTTPError(nil, CodeCellNotNumeric, Params{"count": 1})
+		problem.Type = "https://example.com/probs/cells"
+		problem.Instance = "/sum"
+		problem.WithItems(Item{Row: 2, Col: 1, Value: "c", Params: Params{"row": 2, "col": 1, "value": "c"}})
+		problem.With("shape", "3x3").With("operation", "sum")
+
+		want := []byte(`{"type":"https://example.com/probs/cells","title":"Cell not numeric","status":400,"detail":"1 cell(s) are not integers.","instance":"/sum","code":"CELL_NOT_NUMERIC",` +
+			`"errors":[{"row":2,"col":1,"value":"c","detail":"Row 2, column 1: 'c' is not an integer."}],"operation":"sum","shape":"3x3"}`)
+		got, _ := problem.ResponseBody()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", string(got), string(want))
+		}
+	})
+
+	t.Run("extensions named as standard members", func(t *testing.T) {
+		problem := NewHTTPError(nil, CodeMethodNotAllowed, nil).With("status", 200).With("code", "OK").With("id", "a")
+
+		got, err := problem.ResponseBody()
+		var members map[string]interface{}
+		if err != nil || json.Unmarshal(got, &members) != nil {
+			t.Fatalf("got %s, %v want a JSON object", got, err)
+		}
+		if members["status"] != float64(405) || members["code"] != string(CodeMethodNotAllowed) || members["id"] != "a" {
+			t.Errorf("got %s want the standard members kept", got)
+		}
+	})
 }
 
 func TestResponseHeaders(t *testing.T) {
 
 	t.Run("correct error with cause", func(t *testing.T) {
 		want := http.StatusInternalServerError
-		got, _ := errorWithCause.ResponseHeaders()
+		got, headers := errorWithCause.ResponseHeaders()
 		if got != want {
 			t.Errorf("got %v want %v", got, want)
 		}
+		if headers["Content-Type"] != ProblemContentType {
+			t.Errorf("got %v want %v", headers["Content-Type"], ProblemContentType)
+		}
+	})
+}
+
+func TestWriteResponse(t *testing.T) {
+
+	t.Run("client error", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/sum", nil)
+		w := httptest.NewRecorder()
+		WriteResponse(w, r, NewHTTPError(nil, CodeInvalidCSV, nil))
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got %v want %v", w.Code, http.StatusBadRequest)
+		}
+		if got := w.Header().Get("Content-Type"); got != ProblemContentType {
+			t.Errorf("got %v want %v", got, ProblemContentType)
+		}
+		want := `{"type":"/errors#INVALID_CSV","title":"Invalid CSV","status":400,"detail":"The uploaded file is not valid CSV.","instance":"/sum","code":"INVALID_CSV"}`
+		if w.Body.String() != want {
+			t.Errorf("got %v want %v", w.Body.String(), want)
+		}
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/sum", nil)
+		w := httptest.NewRecorder()
+		WriteResponse(w, r, errors.New(cause))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("got %v want %v", w.Code, http.StatusInternalServerError)
+		}
+		want := `{"type":"/errors#INTERNAL_ERROR","title":"Internal server error","status":500,"detail":"An unexpected error occurred.","instance":"/sum","code":"INTERNAL_ERROR"}`
+		if w.Body.String() != want {
+			t.Errorf("got %v want %v", w.Body.String(), want)
+		}
+	})
+
+	t.Run("localized error", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/sum", nil)
+		r.Header.Set("Accept-Language", "de-CH, fr;q=0.9")
+		w := httptest.NewRecorder()
+		WriteResponse(w, r, NewHTTPError(nil, CodeMethodNotAllowed, Params{"method": "GET", "allowed": "POST"}))
+
+		if got := w.Header().Get("Content-Language"); got != string(German) {
+			t.Errorf("got %v want %v", got, German)
+		}
+		want := `{"type":"/errors#METHOD_NOT_ALLOWED","title":"Method not allowed","status":405,"detail":"Die Methode GET ist nicht erlaubt, verwenden Sie POST.","instance":"/sum","code":"METHOD_NOT_ALLOWED"}`
+		if w.Body.String() != want {
+			t.Errorf("got %v want %v", w.Body.String(), want)
+		}
 	})
 }
 
 func TestNewHTTPError(t *testing.T) {
 	t.Run("correct error with cause", func(t *testing.T) {
-		want := errors.New(detail)
-		got := NewHTTPError(nil, http.StatusBadRequest, detail)
+		want := fmt.Errorf("The expect_shape parameter has an invalid value 'big'. : %s", cause)
+		got := NewHTTPError(errors.New(cause), CodeInvalidParameter, Params{"param": "expect_shape", "value": "big"})
 		if got.Error() != want.Error() {
 			t.Errorf("got %v want %v", got, want)
 		}
+		if got.Status != http.StatusBadRequest || got.Type != "/errors#INVALID_PARAMETER" {
+			t.Errorf("got %v %v want %v %v", got.Status, got.Type, http.StatusBadRequest, "/errors#INVALID_PARAMETER")
+		}
+	})
+
+	t.Run("uncataloged code", func(t *testing.T) {
+		got := NewHTTPError(nil, Code("NOT_A_CODE"), nil)
+		if got.Code != CodeUnknown || got.Status != http.StatusInternalServerError {
+			t.Errorf("got %v %v want %v %v", got.Code, got.Status, CodeUnknown, http.StatusInternalServerError)
+		}
 	})
 }
@@ -0,0 +1,269 @@
+package errors
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Locale is a supported language, as a primary language subtag.
+type Locale string
+
+const (
+	English Locale = "en"
+	French  Locale = "fr"
+	German  Locale = "de"
+)
+
+// DefaultLocale is used when the client accepts none of the Locales.
+const DefaultLocale = English
+
+// Locales lists every supported language.
+var Locales = []Locale{English, French, German}
+
+// Params holds the values substituted in a message, a template references
+// them by name, e.g. {row}.
+type Params map[string]interface{}
+
+// Messages holds the detail template of every code by locale.
+var Messages = map[Code]map[Locale]string{
+	CodeFileNotFound: {
+		English: "No file named 'file' was uploaded.",
+		French:  "Aucun fichier nommé 'file' n'a été envoyé.",
+		German:  "Es wurde keine Datei mit dem Namen 'file' hochgeladen.",
+	},
+	CodeInvalidCSV: {
+		English: "The uploaded file is not valid CSV.",
+		French:  "Le fichier envoyé n'est pas un CSV valide.",
+		German:  "Die hochgeladene Datei ist kein gültiges CSV.",
+	},
+	CodeUnsupportedEncoding: {
+		English: "The content encoding {encoding} is not supported, use gzip.",
+		French:  "L'encodage de contenu {encoding} n'est pas pris en charge, utilisez gzip.",
+		German:  "Die Inhaltskodierung {encoding} wird nicht unterstützt, verwenden Sie gzip.",
+	},
+	CodeInvalidCompression: {
+		English: "The upload is not valid gzip data.",
+		French:  "L'envoi n'est pas composé de données gzip valides.",
+		German:  "Der Upload besteht nicht aus gültigen gzip-Daten.",
+	},
+	CodeCellNotNumeric: {
+		English: "{count} cell(s) are not integers.",
+		French:  "{count} cellule(s) ne sont pas des entiers.",
+		German:  "{count} Zelle(n) sind keine Ganzzahlen.",
+	},
+	CodePayloadTooLarge: {
+		English: "The request body exceeds the {limit} limit of {max} bytes.",
+		French:  "Le corps de la requête dépasse la limite {limit} de {max} octets.",
+		German:  "Der Anfragetext überschreitet das Limit {limit} von {max} Bytes.",
+	},
+	CodeLimitExceeded: {
+		English: "The matrix exceeds the {limit} limit of {max}.",
+		French:  "La matrice dépasse la limite {limit} de {max}.",
+		German:  "Die Matrix überschreitet das Limit {limit} von {max}.",
+	},
+	CodeMatrixNotProvided: {
+		English: "No matrix was provided to the operation.",
+		French:  "Aucune matrice n'a été fournie à l'opération.",
+		German:  "Der Operation wurde keine Matrix übergeben.",
+	},
+	CodeMatrixEmpty: {
+		English: "The matrix is empty, the operation requires at least one cell.",
+		French:  "La matrice est vide, l'opération requiert au moins une cellule.",
+		German:  "Die Matrix ist leer, die Operation erfordert mindestens eine Zelle.",
+	},
+	CodeMatrixNotSquare: {
+		English: "The matrix is {actual}, the operation requires a square matrix.",
+		French:  "La matrice est de taille {actual}, l'opération requiert une matrice carrée.",
+		German:  "Die Matrix hat die Größe {actual}, die Operation erfordert eine quadratische Matrix.",
+	},
+	CodeMatrixNotVector: {
+		English: "The matrix is {actual}, the operation requires a single row or column.",
+		French:  "La matrice est de taille {actual}, l'opération requiert une seule ligne ou colonne.",
+		German:  "Die Matrix hat die Größe {actual}, die Operation erfordert eine einzelne Zeile oder Spalte.",
+	},
+	CodeMatrixTooLarge: {
+		English: "The matrix is {actual} ({cells} cells), which is more than the operation accepts.",
+		French:  "La matrice est de taille {actual} ({cells} cellules), ce qui dépasse ce que l'opération accepte.",
+		German:  "Die Matrix hat die Größe {actual} ({cells} Zellen), mehr als die Operation akzeptiert.",
+	},
+	CodeShapeMismatch: {
+		English: "The matrix is {actual}, expected {expected}.",
+		French:  "La matrice est de taille {actual}, {expected} attendu.",
+		German:  "Die Matrix hat die Größe {actual}, erwartet wurde {expected}.",
+	},
+	CodeInvalidParameter: {
+		English: "The {param} parameter has an invalid value '{value}'.",
+		French:  "Le paramètre {param} a une valeur invalide '{value}'.",
+		German:  "Der Parameter {param} hat einen ungültigen Wert '{value}'.",
+	},
+	CodeOverflow: {
+		English: "The result does not fit in an integer.",
+		French:  "Le résultat ne tient pas dans un entier.",
+		German:  "Das Ergebnis passt nicht in eine Ganzzahl.",
+	},
+	CodeNotFound: {
+		English: "No endpoint is served at {path}.",
+		French:  "Aucun point d'accès n'est servi à {path}.",
+		German:  "Unter {path} wird kein Endpunkt bereitgestellt.",
+	},
+	CodeMatrixNotFound: {
+		English: "No matrix {id} is stored.",
+		French:  "Aucune matrice {id} n'est stockée.",
+		German:  "Es ist keine Matrix {id} gespeichert.",
+	},
+	CodeVersionNotFound: {
+		English: "Matrix {id} has no version {version}.",
+		French:  "La matrice {id} n'a pas de version {version}.",
+		German:  "Die Matrix {id} hat keine Version {version}.",
+	},
+	CodeVersionConflict: {
+		English: "Matrix {id} was updated to version {version} in the meantime, retry from that version.",
+		French:  "La matrice {id} a été mise à jour en version {version} entre-temps, réessayez depuis cette version.",
+		German:  "Die Matrix {id} wurde inzwischen auf Version {version} aktualisiert, versuchen Sie es ab dieser Version erneut.",
+	},
+	CodeJobNotFound: {
+		English: "No job {id} exists.",
+		French:  "Aucune tâche {id} n'existe.",
+		German:  "Es gibt keinen Auftrag {id}.",
+	},
+	CodeJobQueueFull: {
+		English: "Too many jobs are queued, retry in {retry_after} seconds.",
+		French:  "Trop de tâches sont en attente, réessayez dans {retry_after} secondes.",
+		German:  "Zu viele Aufträge stehen an, versuchen Sie es in {retry_after} Sekunden erneut.",
+	},
+	CodeJobFinished: {
+		English: "Job {id} already finished as {status}.",
+		French:  "La tâche {id} est déjà terminée avec le statut {status}.",
+		German:  "Der Auftrag {id} ist bereits mit dem Status {status} beendet.",
+	},
+	CodeJobResultUnavailable: {
+		English: "Job {id} is {status} and has no result.",
+		French:  "La tâche {id} a le statut {status} et n'a pas de résultat.",
+		German:  "Der Auftrag {id} hat den Status {status} und kein Ergebnis.",
+	},
+	CodeInvalidPatch: {
+		English: "The patch is not valid: {reason}.",
+		French:  "Le correctif n'est pas valide : {reason}.",
+		German:  "Der Patch ist ungültig: {reason}.",
+	},
+	CodeCellOutOfRange: {
+		English: "Row {row}, column {col} is outside the {shape} matrix.",
+		French:  "La ligne {row}, colonne {col} est en dehors de la matrice {shape}.",
+		German:  "Zeile {row}, Spalte {col} liegt außerhalb der {shape}-Matrix.",
+	},
+	CodeInvalidBatch: {
+		English: "The batch is not valid: {reason}.",
+		French:  "Le lot n'est pas valide : {reason}.",
+		German:  "Der Stapel ist ungültig: {reason}.",
+	},
+	CodeMethodNotAllowed: {
+		English: "Method {method} is not allowed, use {allowed}.",
+		French:  "La méthode {method} n'est pas autorisée, utilisez {allowed}.",
+		German:  "Die Methode {method} ist nicht erlaubt, verwenden Sie {allowed}.",
+	},
+	CodeUnauthorized: {
+		English: "A valid API key is required, send it as a Bearer token or in the X-API-Key header.",
+		French:  "Une clé d'API valide est requise, envoyez-la comme jeton Bearer ou dans l'en-tête X-API-Key.",
+		German:  "Ein gültiger API-Schlüssel ist erforderlich, senden Sie ihn als Bearer-Token oder im Header X-API-Key.",
+	},
+	CodeInvalidToken: {
+		English: "The bearer token is not valid: {reason}.",
+		French:  "Le jeton Bearer n'est pas valide : {reason}.",
+		German:  "Das Bearer-Token ist ungültig: {reason}.",
+	},
+	CodeForbidden: {
+		English: "The credentials of {principal} lack the scope {scope} required by this operation.",
+		French:  "Les identifiants de {principal} n'ont pas la portée {scope} requise par cette opération.",
+		German:  "Den Anmeldedaten von {principal} fehlt der für diese Operation erforderliche Bereich {scope}.",
+	},
+	CodeRateLimited: {
+		English: "Too many requests: the {limit} limit of this client is exceeded, retry in {retry_after} seconds.",
+		French:  "Trop de requêtes : la limite {limit} de ce client est dépassée, réessayez dans {retry_after} secondes.",
+		German:  "Zu viele Anfragen: das Limit {limit} dieses Clients ist überschritten, erneut versuchen in {retry_after} Sekunden.",
+	},
+	CodeInternalError: {
+		English: "An unexpected error occurred.",
+		French:  "Une erreur inattendue s'est produite.",
+		German:  "Ein unerwarteter Fehler ist aufgetreten.",
+	},
+	CodeUnknown: {
+		English: "An unknown error occurred.",
+		French:  "Une erreur inconnue s'est produite.",
+		German:  "Ein unbekannter Fehler ist aufgetreten.",
+	},
+}
+
+// ItemMessages holds the template of the members of the errors extension by
+// code and locale.
+var ItemMessages = map[Code]map[Locale]string{
+	CodeCellNotNumeric: {
+		English: "Row {row}, column {col}: '{value}' is not an integer.",
+		French:  "Ligne {row}, colonne {col} : '{value}' n'est pas un entier.",
+		German:  "Zeile {row}, Spalte {col}: '{value}' ist keine Ganzzahl.",
+	},
+}
+
+// Message renders the detail of code in locale, falling back to English
+// when the message is not translated.
+func Message(locale Locale, code Code, params Params) string {
+	return render(Messages[code], locale, params, string(code))
+}
+
+// ItemMessage renders the detail of a member of the errors extension.
+func ItemMessage(locale Locale, code Code, params Params) string {
+	return render(ItemMessages[code], locale, params, string(code))
+}
+
+func render(templates map[Locale]string, locale Locale, params Params, fallback string) string {
+	template, ok := templates[locale]
+	if !ok {
+		template, ok = templates[DefaultLocale]
+	}
+	if !ok {
+		return fallback
+	}
+	oldnew := make([]string, 0, 2*len(params))
+	for name, value := range params {
+		oldnew = append(oldnew, "{"+name+"}", fmt.Sprint(value))
+	}
+	return strings.NewReplacer(oldnew...).Replace(template)
+}
+
+// NegotiateLocale returns the supported locale preferred by an
+// Accept-Language header, e.g. "fr-CH, fr;q=0.9, en;q=0.8".
+func NegotiateLocale(acceptLanguage string) Locale {
+	type candidate struct {
+		locale Locale
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, field := range fields[1:] {
+			field = strings.TrimSpace(field)
+			if strings.HasPrefix(field, "q=") {
+				if value, err := strconv.ParseFloat(field[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		primary := Locale(strings.SplitN(tag, "-", 2)[0])
+		for _, locale := range Locales {
+			if locale == primary && q > 0 {
+				candidates = append(candidates, candidate{locale, q})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return DefaultLocale
+	}
+	// Keep the header order between equal weights.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].locale
+}
@@ -0,0 +1,108 @@
+package errors
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+)
+
+var placeholder = regexp.MustCompile(`\{\w+\}`)
+
+// placeholders returns the sorted placeholders of a template.
+func placeholders(template string) []string {
+	found := placeholder.FindAllString(template, -1)
+	sort.Strings(found)
+	return found
+}
+
+func testTranslations(t *testing.T, code Code, templates map[Locale]string) {
+	t.Helper()
+	english := placeholders(templates[English])
+	for _, locale := range Locales {
+		template, ok := templates[locale]
+		if !ok || template == "" {
+			t.Errorf("%s has no %s translation", code, locale)
+			continue
+		}
+		got := placeholders(template)
+		if len(got) != len(english) {
+			t.Errorf("%s %s translation has placeholders %v want %v", code, locale, got, english)
+			continue
+		}
+		for i := range got {
+			if got[i] != english[i] {
+				t.Errorf("%s %s translation has placeholders %v want %v", code, locale, got, english)
+				break
+			}
+		}
+	}
+}
+
+func TestTranslations(t *testing.T) {
+
+	t.Run("every code is translated", func(t *testing.T) {
+		for _, entry := range Catalog {
+			testTranslations(t, entry.Code, Messages[entry.Code])
+		}
+	})
+
+	t.Run("every item message is translated", func(t *testing.T) {
+		for code, templates := range ItemMessages {
+			if _, ok := Lookup(code); !ok {
+				t.Errorf("%s is missing from the catalog", code)
+			}
+			testTranslations(t, code, templates)
+		}
+	})
+}
+
+func TestMessage(t *testing.T) {
+
+	t.Run("parameters are substituted", func(t *testing.T) {
+		want := "Ligne 2, colonne 3 : 'x' n'est pas un entier."
+		got := ItemMessage(French, CodeCellNotNumeric, Params{"row": 2, "col": 3, "value": "x"})
+		if got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("unsupported locale falls back to English", func(t *testing.T) {
+		want := "The result does not fit in an integer."
+		got := Message(Locale("es"), CodeOverflow, nil)
+		if got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("unknown code", func(t *testing.T) {
+		want := "NOT_A_CODE"
+		got := Message(English, Code(want), nil)
+		if got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	tests := []struct {
+		header string
+		want   Locale
+	}{
+		{"", English},
+		{"fr", French},
+		{"de-DE", German},
+		{"es, it;q=0.8", English},
+		{"es, de;q=0.5, fr;q=0.8", French},
+		{"fr;q=0, de", German},
+		{"en-US, fr;q=0.9", English},
+		{"*", English},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			got := NegotiateLocale(test.header)
+			if got != test.want {
+				t.Errorf("got %v want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,6 @@
 module takehome
 
-go 1.13
+go 1.21
 
 require (
 	github.com/joho/godotenv v1.3.0
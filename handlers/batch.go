@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	err "takehome/errors"
+	logging "takehome/logging"
+	metrics "takehome/metrics"
+	middlewares "takehome/middlewares"
+)
+
+// batchOperations are the operations a batch may run, by name.
+var batchOperations = map[string]RootHandler{
+	"echo":     Echo,
+	"invert":   Invert,
+	"flatten":  Flatten,
+	"sum":      Sum,
+	"multiply": Multiply,
+}
+
+var batchItems = metrics.Default.NewCounterVec("matrix_batch_items_total",
+	"Items of batches served, by result: succeeded or failed.", "result")
+
+// Batch runs the operations of every item of a batch, read by the
+// BatchMiddleware, on a bounded number of goroutines.
+type Batch struct {
+	parallelism int
+}
+
+func NewBatch(parallelism int) *Batch {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &Batch{parallelism}
+}
+
+// BatchResult lists the results of the items of a batch, in order.
+type BatchResult struct {
+	Items []BatchItemResult `json:"items"`
+}
+
+// BatchItemResult is the result of every operation of an item, or the
+// problem reading its matrix.
+type BatchItemResult struct {
+	Index   int               `json:"index"`
+	ID      string            `json:"id,omitempty"`
+	Error   json.RawMessage   `json:"error,omitempty"`
+	Results []OperationResult `json:"results,omitempty"`
+}
+
+// OperationResult is the JSON result of an operation on the matrix of an
+// item, or its problem, as the operation answers alone.
+type OperationResult struct {
+	Op     string          `json:"op"`
+	Status int             `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// Serve answers with the results of the items, one failing item failing
+// alone.
+func (b *Batch) Serve(w http.ResponseWriter, r *http.Request) error {
+	items, ok := r.Context().Value(middlewares.BatchKey).([]*middlewares.BatchItem)
+	if !ok {
+		return err.NewHTTPError(nil, err.CodeMatrixNotProvided, nil)
+	}
+	// Operations get JSON results and problems localized for the client,
+	// their annotations being dropped from the log line of the batch.
+	operation := r.Clone(logging.WithAnnotations(r.Context()))
+	operation.Header.Set("Accept", "application/json")
+	operation.URL.RawQuery = ""
+	operation.Body = http.NoBody
+
+	results := make([]BatchItemResult, len(items))
+	next := make(chan int)
+	var workers sync.WaitGroup
+	for i := 0; i < b.parallelism && i < len(items); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for index := range next {
+				results[index] = runItem(operation, index, items[index])
+			}
+		}()
+	}
+	for index := range items {
+		if r.Context().Err() != nil {
+			break
+		}
+		next <- index
+	}
+	close(next)
+	workers.Wait()
+	if error := r.Context().Err(); error != nil {
+		return error
+	}
+	return writeJSON(w, http.StatusOK, BatchResult{results})
+}
+
+// runItem runs the operations of item on its matrix. A panic of an
+// operation fails the item with a 500 problem, as workers run past the
+// recovery middleware of the server.
+func runItem(r *http.Request, index int, item *middlewares.BatchItem) (result BatchItemResult) {
+	result = BatchItemResult{Index: index, ID: item.ID}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			problem := middlewares.Recovered(r, recovered, logging.FromContext(r.Context()))
+			result.Results = nil
+			_, result.Error = problemJSON(r, problem)
+			batchItems.Inc("failed")
+		}
+	}()
+	if item.Error != nil {
+		_, result.Error = problemJSON(r, item.Error)
+		batchItems.Inc("failed")
+		return result
+	}
+	r = r.WithContext(context.WithValue(r.Context(), middlewares.RequestFileMatrixKey, item.Matrix))
+	succeeded := true
+	for _, op := range item.Ops {
+		result.Results = append(result.Results, runOperation(r, op))
+		succeeded = succeeded && result.Results[len(result.Results)-1].Error == nil
+	}
+	if succeeded {
+		batchItems.Inc("succeeded")
+	} else {
+		batchItems.Inc("failed")
+	}
+	return result
+}
+
+// runOperation runs the named operation on the matrix of r.
+func runOperation(r *http.Request, op string) OperationResult {
+	result := OperationResult{Op: op}
+	handler, ok := batchOperations[op]
+	if !ok {
+		result.Status, result.Error = problemJSON(r, err.NewHTTPError(nil, err.CodeInvalidParameter, err.Params{"param": middlewares.OpsParam, "value": op}))
+		return result
+	}
+	recorder := &bufferWriter{header: http.Header{}}
+	if error := handler(recorder, r); error != nil {
+		if problemStatus(error) >= http.StatusInternalServerError {
+			logging.FromContext(r.Context()).Error("batch operation error", logging.Fields{
+				"request_id": logging.RequestID(r.Context()),
+				"op":         op,
+				"error":      error,
+			})
+		}
+		result.Status, result.Error = problemJSON(r, error)
+		return result
+	}
+	result.Status, result.Result = recorder.status, recorder.body.Bytes()
+	return result
+}
+
+// problemJSON returns the status and the problem document answering error.
+func problemJSON(r *http.Request, error error) (int, json.RawMessage) {
+	recorder := &bufferWriter{header: http.Header{}}
+	err.WriteResponse(recorder, r, error)
+	return recorder.status, recorder.body.Bytes()
+}
+
+// problemStatus returns the status answering error, 500 for server errors.
+func problemStatus(error error) int {
+	if problem, ok := error.(err.ClientError); ok {
+		status, _ := problem.ResponseHeaders()
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// bufferWriter keeps a response in memory.
+type bufferWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (bw *bufferWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *bufferWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *bufferWriter) Write(p []byte) (int, error) {
+	bw.WriteHeader(http.StatusOK)
+	return bw.body.Write(p)
+}
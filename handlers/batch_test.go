@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	e "takehome/errors"
+	logging "takehome/logging"
+	m "takehome/matrix"
+	middlewares "takehome/middlewares"
+)
+
+func TestBatch(t *testing.T) {
+	items := []*middlewares.BatchItem{
+		{ID: "a", Matrix: &m.Matrix{Data: [][]string{{"1", "2"}, {"3", "4"}}}, Ops: []string{"sum", "invert", "unknown"}},
+		{ID: "b", Error: e.NewHTTPError(nil, e.CodeInvalidBatch, e.Params{"reason": middlewares.BatchInvalidMatrix}).
+			With("reason", middlewares.BatchInvalidMatrix)},
+		{Matrix: &m.Matrix{Data: [][]string{{"1", "2"}}}, Ops: []string{"invert", "multiply"}},
+	}
+	r := httptest.NewRequest("POST", "/batch?ops=sum", strings.NewReader("ignored"))
+	r = r.WithContext(context.WithValue(r.Context(), middlewares.BatchKey, items))
+	w := httptest.NewRecorder()
+	RootHandler(NewBatch(2).Serve).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %v %v want 200", w.Code, w.Body.String())
+	}
+
+	var result BatchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || len(result.Items) != len(items) {
+		t.Fatalf("got %v, %v want %v items", w.Body.String(), err, len(items))
+	}
+	for i, item := range result.Items {
+		if item.Index != i || item.ID != items[i].ID {
+			t.Errorf("got item %v %q want %v %q", item.Index, item.ID, i, items[i].ID)
+		}
+	}
+	a := result.Items[0].Results
+	if len(a) != 3 || a[0].Op != "sum" || a[0].Status != http.StatusOK || !strings.Contains(string(a[0].Result), "10") {
+		t.Errorf("got %+v want the sum", a)
+	}
+	if a[1].Status != http.StatusOK || a[1].Result == nil || a[1].Error != nil {
+		t.Errorf("got %+v want the inverted matrix", a[1])
+	}
+	if a[2].Status != http.StatusBadRequest || !strings.Contains(string(a[2].Error), `"code":"INVALID_PARAMETER"`) {
+		t.Errorf("got %+v want an unknown operation", a[2])
+	}
+	if b := result.Items[1]; b.Results != nil || !strings.Contains(string(b.Error), `"reason":"invalid_matrix"`) {
+		t.Errorf("got %+v want the item error", b)
+	}
+	// Each operation of an item fails alone.
+	for _, op := range result.Items[2].Results {
+		if op.Status != http.StatusUnprocessableEntity || !strings.Contains(string(op.Error), `"code":"MATRIX_NOT_SQUARE"`) {
+			t.Errorf("got %+v want a matrix not square", op)
+		}
+	}
+
+	t.Run("panic", func(t *testing.T) {
+		batchOperations["panic"] = func(w http.ResponseWriter, r *http.Request) error { panic("boom") }
+		defer delete(batchOperations, "panic")
+		var logs bytes.Buffer
+		items := []*middlewares.BatchItem{
+			{Matrix: &m.Matrix{Data: [][]string{{"1"}}}, Ops: []string{"sum", "panic"}},
+			{Matrix: &m.Matrix{Data: [][]string{{"2"}}}, Ops: []string{"sum"}},
+		}
+		ctx := logging.WithLogger(context.WithValue(context.Background(), middlewares.BatchKey, items),
+			logging.New(&logs, logging.Error, logging.JSON))
+		w := httptest.NewRecorder()
+		RootHandler(NewBatch(2).Serve).ServeHTTP(w, httptest.NewRequest("POST", "/batch", nil).WithContext(ctx))
+
+		var result BatchResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		if w.Code != http.StatusOK || len(result.Items) != 2 {
+			t.Fatalf("got %v %v want the other items served", w.Code, w.Body.String())
+		}
+		if item := result.Items[0]; item.Results != nil || !strings.Contains(string(item.Error), `"code":"INTERNAL_ERROR"`) ||
+			!strings.Contains(string(item.Error), `"incident_id"`) {
+			t.Errorf("got %+v want an internal error", item)
+		}
+		if item := result.Items[1]; item.Error != nil || len(item.Results) != 1 {
+			t.Errorf("got %+v want the sum", item)
+		}
+		if !strings.Contains(logs.String(), `"panic":"boom"`) {
+			t.Errorf("got log %q want the panic", logs.String())
+		}
+	})
+
+	t.Run("no batch", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		RootHandler(NewBatch(1).Serve).ServeHTTP(w, httptest.NewRequest("POST", "/batch", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got %v want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+}
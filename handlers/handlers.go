@@ -1,99 +1,344 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	err "takehome/errors"
+	logging "takehome/logging"
 	m "takehome/matrix"
 	middlewares "takehome/middlewares"
+	router "takehome/router"
+	tracing "takehome/tracing"
 )
 
-const (
-	BadRequestErrorFormat  = "Bad request : "
-	MatrixNotProvidedError = "matrix not provided."
-	NonDigitFoundError     = "non-digit character found."
+// ExpectShapeParam is the query parameter clients use to require a shape,
+// e.g. ?expect_shape=3x3.
+const ExpectShapeParam = "expect_shape"
+
+// EnvelopeParam is the query parameter clients use to get JSON results in
+// an envelope carrying the response metadata, e.g. ?envelope=true.
+const EnvelopeParam = "envelope"
+
+// Shape constraints declared by each operation.
+var (
+	EchoConstraints     = []m.Constraint{m.Square}
+	InvertConstraints   = []m.Constraint{m.NonEmpty, m.Square}
+	FlattenConstraints  = []m.Constraint{m.Square}
+	SumConstraints      = []m.Constraint{m.NonEmpty, m.Square}
+	MultiplyConstraints = []m.Constraint{m.NonEmpty, m.Square}
 )
 
 type RootHandler func(http.ResponseWriter, *http.Request) error
 
+// ServeHTTP serves the handler, answering its error with a problem.
 func (fn RootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracing.Start(r.Context(), operation(r))
+	defer span.End()
+	timings := newTimings(r)
+	r = r.WithContext(withTimings(ctx, timings))
+	metadata := &metadataWriter{ResponseWriter: w, r: r, timings: timings}
+	w = metadata
+
 	error := fn(w, r) // Call handler function
+	span.SetError(error)
+	metadata.writeMetadata()
 	if error == nil {
 		return
 	}
-	// This is where our error handling logic starts.
+	// Server errors are logged with their cause, which clients do not get.
+	if problemStatus(error) >= http.StatusInternalServerError {
+		logging.FromContext(r.Context()).Error("handler error", logging.Fields{
+			"request_id": logging.RequestID(r.Context()),
+			"path":       r.URL.Path,
+			"error":      error,
+		})
+	}
+	// Every error, client or server, is reported as problem details.
+	err.WriteResponse(w, r, error)
+}
+
+// operation names the operation serving r, the pattern of its route or its
+// path outside a router.
+func operation(r *http.Request) string {
+	if pattern := router.Pattern(r); pattern != "" {
+		return pattern
+	}
+	return r.URL.Path
+}
 
-	clientError, ok := error.(err.ClientError) // Check if it is a ClientError.
+// matrixFromRequest returns the matrix stored in the request context once it
+// satisfies the operation constraints and the shape requested by the client.
+func matrixFromRequest(r *http.Request, constraints ...m.Constraint) (*m.Matrix, error) {
+	matrix, ok := r.Context().Value(middlewares.RequestFileMatrixKey).(*m.Matrix)
 	if !ok {
-		// If the error is not ClientError, assume that it is ServerError.
-		w.WriteHeader(http.StatusInternalServerError) // return 500 Internal Server Error.
-		return
+		return nil, err.NewHTTPError(nil, err.CodeMatrixNotProvided, nil)
 	}
 
-	body, error := clientError.ResponseBody() // Try to get response body of ClientError.
+	if expected := r.URL.Query().Get(ExpectShapeParam); expected != "" {
+		shape, error := m.ParseShape(expected)
+		if error != nil {
+			return nil, err.NewHTTPError(error, err.CodeInvalidParameter, err.Params{"param": ExpectShapeParam, "value": expected})
+		}
+		// Copy before appending so the declared constraints are never modified.
+		constraints = append(constraints[:len(constraints):len(constraints)], m.ExactShape(shape))
+	}
+
+	_, span := tracing.Start(r.Context(), "validate")
+	defer span.End()
+	span.SetAttribute("shape", matrix.Shape().String())
+	start := time.Now()
+	error := matrix.Validate(constraints...)
+	timingsFromRequest(r).recordValidate(time.Since(start))
 	if error != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		span.SetError(error)
+		shapeError := error.(*m.ShapeError)
+		params := err.Params{
+			"actual":   shapeError.Actual,
+			"expected": shapeError.Expected,
+			"cells":    shapeError.Actual.Cells(),
+		}
+		return nil, err.NewHTTPError(error, shapeErrorCode(shapeError.Constraint), params).
+			With("constraint", shapeError.Constraint).
+			With("actual_shape", shapeError.Actual.String()).
+			With("expected_shape", shapeError.Expected)
+	}
+	return matrix, nil
+}
+
+// shapeErrorCode returns the error code reported when a matrix violates the
+// named shape constraint.
+func shapeErrorCode(constraint string) err.Code {
+	switch constraint {
+	case m.NonEmpty.Name:
+		return err.CodeMatrixEmpty
+	case m.Square.Name:
+		return err.CodeMatrixNotSquare
+	case m.Vector.Name:
+		return err.CodeMatrixNotVector
+	case m.MaxCells(0).Name:
+		return err.CodeMatrixTooLarge
+	default:
+		return err.CodeShapeMismatch
 	}
-	status, headers := clientError.ResponseHeaders() // Get http status code and headers.
-	for k, v := range headers {
-		w.Header().Set(k, v)
+}
+
+// arithmeticError converts an error of Sum or Multiply to a client error,
+// other than the error of a context which stopped them.
+func arithmeticError(cause error) error {
+	if cause == m.ErrOverflow {
+		return err.NewHTTPError(cause, err.CodeOverflow, nil)
+	}
+	nonNumber, ok := cause.(*m.NonNumberError)
+	if !ok {
+		return cause
 	}
+	item := err.Item{
+		Row:    nonNumber.Row,
+		Col:    nonNumber.Col,
+		Value:  nonNumber.Value,
+		Params: err.Params{"row": nonNumber.Row, "col": nonNumber.Col, "value": nonNumber.Value},
+	}
+	return err.NewHTTPError(cause, err.CodeCellNotNumeric, err.Params{"count": 1}).WithItems(item)
+}
+
+// allowMethods returns a 405 problem, listing the allowed methods in the
+// Allow header, unless the request uses one of them.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) error {
+	for _, method := range methods {
+		if r.Method == method {
+			return nil
+		}
+	}
+	allowed := strings.Join(methods, ", ")
+	w.Header().Set("Allow", allowed)
+	return err.NewHTTPError(nil, err.CodeMethodNotAllowed, err.Params{"method": r.Method, "allowed": allowed})
+}
+
+// writeJSON writes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
-	w.Write(body)
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Envelope carries a JSON result with the metadata of its response.
+type Envelope struct {
+	Result interface{} `json:"result"`
+	Meta   Metadata    `json:"meta"`
+}
+
+// Metadata describes the input matrix and where the time of the request
+// went, as the X-Matrix-* and Server-Timing headers do.
+type Metadata struct {
+	Rows      int                `json:"rows"`
+	Cols      int                `json:"cols"`
+	Cells     int                `json:"cells"`
+	TimingsMs map[string]float64 `json:"timings_ms"`
+}
+
+// acceptsJSON reports whether the client asks for JSON results with the
+// Accept header, results being text otherwise.
+func acceptsJSON(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, error := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if error != nil || mediaType != "application/json" {
+			continue
+		}
+		if q, error := strconv.ParseFloat(params["q"], 64); error == nil && q == 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// respond writes the result of an operation on matrix, as text, or as the
+// JSON value returned by value when the client accepts JSON.
+func respond(w http.ResponseWriter, r *http.Request, matrix *m.Matrix, text string, value jsonResult) error {
+	w.Header().Add("Vary", "Accept")
+	if !acceptsJSON(r) {
+		fmt.Fprint(w, text)
+		return nil
+	}
+
+	envelope := false
+	if param := r.URL.Query().Get(EnvelopeParam); param != "" {
+		var error error
+		if envelope, error = strconv.ParseBool(param); error != nil {
+			return err.NewHTTPError(error, err.CodeInvalidParameter, err.Params{"param": EnvelopeParam, "value": param})
+		}
+	}
+	result, error := value()
+	if error != nil {
+		return arithmeticError(error)
+	}
+	if !envelope {
+		return writeJSON(w, http.StatusOK, result)
+	}
+
+	// The timings of the envelope are those of this request, so it must not
+	// be replayed from a cache.
+	w.Header().Set("Cache-Control", "no-store")
+	timings := timingsFromRequest(r)
+	timings.finish()
+	shape := matrix.Shape()
+	meta := Metadata{Rows: shape.Rows, Cols: shape.Cols, Cells: shape.Cells()}
+	if timings != nil {
+		_, meta.TimingsMs = timings.phases()
+	}
+	return writeJSON(w, http.StatusOK, Envelope{Result: result, Meta: meta})
+}
+
+// integers returns the cells of data as integers.
+func integers(data [][]string) ([][]int, error) {
+	rows := make([][]int, len(data))
+	for i, row := range data {
+		rows[i] = make([]int, len(row))
+		for j, cell := range row {
+			value, error := strconv.Atoi(cell)
+			if error != nil {
+				return nil, &m.NonNumberError{Row: i + 1, Col: j + 1, Value: cell}
+			}
+			rows[i][j] = value
+		}
+	}
+	return rows, nil
+}
+
+// ErrorCatalog lists every error code with its status and description.
+func ErrorCatalog(w http.ResponseWriter, r *http.Request) error {
+	if error := allowMethods(w, r, http.MethodGet, http.MethodHead); error != nil {
+		return error
+	}
+	return writeJSON(w, http.StatusOK, err.Catalog)
+}
+
+// jsonResult returns the JSON value of a result.
+type jsonResult func() (interface{}, error)
+
+// cellsResult returns the rows of data as integers.
+func cellsResult(data [][]string) jsonResult {
+	return func() (interface{}, error) {
+		return integers(data)
+	}
+}
+
+// transposedResult returns the rows of the transpose of matrix as integers.
+func transposedResult(matrix *m.Matrix) jsonResult {
+	return func() (interface{}, error) {
+		return integers(matrix.Transpose().Data)
+	}
+}
+
+// flatResult returns the cells of data as integers, row after row.
+func flatResult(data [][]string) jsonResult {
+	return func() (interface{}, error) {
+		rows, err := integers(data)
+		flat := []int{}
+		for _, row := range rows {
+			flat = append(flat, row...)
+		}
+		return flat, err
+	}
+}
+
+// intResult returns v.
+func intResult(v int) jsonResult {
+	return func() (interface{}, error) {
+		return v, nil
+	}
 }
 
 func Echo(w http.ResponseWriter, r *http.Request) error {
-	matrix, ok := r.Context().Value(middlewares.RequestFileMatrixKey).(*m.Matrix)
-	if !ok {
-		return err.NewHTTPError(nil, http.StatusBadRequest, fmt.Sprintf("%s%s", BadRequestErrorFormat, MatrixNotProvidedError))
+	matrix, error := matrixFromRequest(r, EchoConstraints...)
+	if error != nil {
+		return error
 	}
-	fmt.Fprint(w, matrix.Echo())
-	return nil
+	return respond(w, r, matrix, matrix.Echo(), cellsResult(matrix.Data))
 }
 
 func Invert(w http.ResponseWriter, r *http.Request) error {
-	matrix, ok := r.Context().Value(middlewares.RequestFileMatrixKey).(*m.Matrix)
-	if !ok {
-		// http.Error(w, "Matrix not provided.", http.StatusBadRequest)
-		return err.NewHTTPError(nil, http.StatusBadRequest, fmt.Sprintf("%s%s", BadRequestErrorFormat, MatrixNotProvidedError))
+	matrix, error := matrixFromRequest(r, InvertConstraints...)
+	if error != nil {
+		return error
 	}
-	fmt.Fprint(w, matrix.Invert())
-	return nil
+	return respond(w, r, matrix, matrix.Invert(), transposedResult(matrix))
 }
 
 func Flatten(w http.ResponseWriter, r *http.Request) error {
-	matrix, ok := r.Context().Value(middlewares.RequestFileMatrixKey).(*m.Matrix)
-	if !ok {
-		return err.NewHTTPError(nil, http.StatusBadRequest, fmt.Sprintf("%s%s", BadRequestErrorFormat, MatrixNotProvidedError))
+	matrix, error := matrixFromRequest(r, FlattenConstraints...)
+	if error != nil {
+		return error
 	}
-	fmt.Fprint(w, matrix.Flatten())
-	return nil
+	return respond(w, r, matrix, matrix.Flatten(), flatResult(matrix.Data))
 }
 
 func Sum(w http.ResponseWriter, r *http.Request) error {
-	matrix, ok := r.Context().Value(middlewares.RequestFileMatrixKey).(*m.Matrix)
-	if !ok {
-		return err.NewHTTPError(nil, http.StatusBadRequest, fmt.Sprintf("%s%s", BadRequestErrorFormat, MatrixNotProvidedError))
+	matrix, error := matrixFromRequest(r, SumConstraints...)
+	if error != nil {
+		return error
 	}
-	sum, error := matrix.Sum()
+	sum, error := matrix.SumContext(r.Context())
 	if error != nil {
-		return err.NewHTTPError(error, http.StatusBadRequest, fmt.Sprintf("%s%s", BadRequestErrorFormat, NonDigitFoundError))
+		return arithmeticError(error)
 	}
-	fmt.Fprint(w, sum)
-	return nil
+	return respond(w, r, matrix, fmt.Sprint(sum), intResult(sum))
 }
 
 func Multiply(w http.ResponseWriter, r *http.Request) error {
-	matrix, ok := r.Context().Value(middlewares.RequestFileMatrixKey).(*m.Matrix)
-	if !ok {
-		return err.NewHTTPError(nil, http.StatusBadRequest, fmt.Sprintf("%s%s", BadRequestErrorFormat, MatrixNotProvidedError))
+	matrix, error := matrixFromRequest(r, MultiplyConstraints...)
+	if error != nil {
+		return error
 	}
-	product, error := matrix.Multiply()
+	product, error := matrix.MultiplyContext(r.Context())
 	if error != nil {
-		return err.NewHTTPError(error, http.StatusBadRequest, fmt.Sprintf("%s%s", BadRequestErrorFormat, NonDigitFoundError))
+		return arithmeticError(error)
 	}
-	fmt.Fprint(w, product)
-	return nil
+	return respond(w, r, matrix, fmt.Sprint(product), intResult(product))
 }
@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
+	e "takehome/errors"
 	m "takehome/matrix"
 	middlewares "takehome/middlewares"
 )
@@ -27,6 +30,21 @@ var wrongMatrix = &m.Matrix{
 	},
 }
 
+const wrongMatrixErrors = `"errors":[{"row":2,"col":1,"value":"c","detail":"Row 2, column 1: 'c' is not an integer."}]`
+
+// problem returns the problem details body expected for the given code,
+// message parameters, instance and already serialized extension members.
+func problem(code e.Code, params e.Params, instance string, extensions ...string) string {
+	entry, _ := e.Lookup(code)
+	detail := e.Message(e.English, code, params)
+	body := fmt.Sprintf(`{"type":"/errors#%s","title":"%s","status":%d,"detail":"%s","instance":"%s","code":"%s"`,
+		code, entry.Title, entry.Status, detail, instance, code)
+	for _, extension := range extensions {
+		body += "," + extension
+	}
+	return body + "}"
+}
+
 func TestEcho(t *testing.T) {
 
 	req, err := http.NewRequest("POST", "/echo", nil)
@@ -45,7 +63,7 @@ func TestEcho(t *testing.T) {
 				status, http.StatusBadRequest)
 		}
 
-		expected := fmt.Sprintf(`{"detail":"%s%s"}`, BadRequestErrorFormat, MatrixNotProvidedError)
+		expected := problem(e.CodeMatrixNotProvided, nil, "/echo")
 		if rr.Body.String() != expected {
 			t.Errorf("handler returned unexpected body: got %v want %v",
 				rr.Body.String(), expected)
@@ -89,7 +107,7 @@ func TestInvert(t *testing.T) {
 				status, http.StatusBadRequest)
 		}
 
-		expected := fmt.Sprintf(`{"detail":"%s%s"}`, BadRequestErrorFormat, MatrixNotProvidedError)
+		expected := problem(e.CodeMatrixNotProvided, nil, "/invert")
 		if rr.Body.String() != expected {
 			t.Errorf("handler returned unexpected body: got %v want %v",
 				rr.Body.String(), expected)
@@ -133,7 +151,7 @@ func TestFlatten(t *testing.T) {
 				status, http.StatusBadRequest)
 		}
 
-		expected := fmt.Sprintf(`{"detail":"%s%s"}`, BadRequestErrorFormat, MatrixNotProvidedError)
+		expected := problem(e.CodeMatrixNotProvided, nil, "/flatten")
 		if rr.Body.String() != expected {
 			t.Errorf("handler returned unexpected body: got %v want %v",
 				rr.Body.String(), expected)
@@ -178,7 +196,7 @@ func TestSum(t *testing.T) {
 				status, http.StatusBadRequest)
 		}
 
-		expected := fmt.Sprintf(`{"detail":"%s%s"}`, BadRequestErrorFormat, MatrixNotProvidedError)
+		expected := problem(e.CodeMatrixNotProvided, nil, "/sum")
 		if rr.Body.String() != expected {
 			t.Errorf("handler returned unexpected body: got %v want %v",
 				rr.Body.String(), expected)
@@ -197,7 +215,7 @@ func TestSum(t *testing.T) {
 				status, http.StatusBadRequest)
 		}
 
-		expected := fmt.Sprintf(`{"detail":"%s%s"}`, BadRequestErrorFormat, NonDigitFoundError)
+		expected := problem(e.CodeCellNotNumeric, e.Params{"count": 1}, "/sum", wrongMatrixErrors)
 		if rr.Body.String() != expected {
 			t.Errorf("handler returned unexpected body: got %v want %v",
 				rr.Body.String(), expected)
@@ -241,7 +259,7 @@ func TestMultiply(t *testing.T) {
 				status, http.StatusBadRequest)
 		}
 
-		expected := fmt.Sprintf(`{"detail":"%s%s"}`, BadRequestErrorFormat, MatrixNotProvidedError)
+		expected := problem(e.CodeMatrixNotProvided, nil, "/multiply")
 		if rr.Body.String() != expected {
 			t.Errorf("handler returned unexpected body: got %v want %v",
 				rr.Body.String(), expected)
@@ -260,7 +278,7 @@ func TestMultiply(t *testing.T) {
 				status, http.StatusBadRequest)
 		}
 
-		expected := fmt.Sprintf(`{"detail":"%s%s"}`, BadRequestErrorFormat, NonDigitFoundError)
+		expected := problem(e.CodeCellNotNumeric, e.Params{"count": 1}, "/multiply", wrongMatrixErrors)
 		if rr.Body.String() != expected {
 			t.Errorf("handler returned unexpected body: got %v want %v",
 				rr.Body.String(), expected)
@@ -286,3 +304,145 @@ func TestMultiply(t *testing.T) {
 		}
 	})
 }
+
+func TestShapeConstraints(t *testing.T) {
+	rectangularMatrix := &m.Matrix{
+		Data: [][]string{
+			{"1", "2", "3"},
+			{"4", "5", "6"},
+		},
+	}
+
+	testShape := func(t *testing.T, handler RootHandler, target string, matrix *m.Matrix, wantStatus int, wantBody string) {
+		t.Helper()
+		req := httptest.NewRequest("POST", target, nil)
+		ctxWithMatrix := context.WithValue(req.Context(), middlewares.RequestFileMatrixKey, matrix)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(ctxWithMatrix))
+
+		if status := rr.Code; status != wantStatus {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, wantStatus)
+		}
+		if rr.Body.String() != wantBody {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				rr.Body.String(), wantBody)
+		}
+	}
+
+	t.Run("empty matrix", func(t *testing.T) {
+		want := problem(e.CodeMatrixEmpty, e.Params{}, "/invert",
+			`"actual_shape":"0x0","constraint":"non-empty","expected_shape":"at least 1x1"`)
+		testShape(t, Invert, "/invert", &m.Matrix{}, http.StatusUnprocessableEntity, want)
+	})
+
+	t.Run("non square matrix", func(t *testing.T) {
+		want := problem(e.CodeMatrixNotSquare, e.Params{"actual": "2x3"}, "/sum",
+			`"actual_shape":"2x3","constraint":"square","expected_shape":"NxN"`)
+		testShape(t, Sum, "/sum", rectangularMatrix, http.StatusUnprocessableEntity, want)
+	})
+
+	t.Run("expected shape matches", func(t *testing.T) {
+		testShape(t, Flatten, "/flatten?expect_shape=3x3", matrix, http.StatusOK, "1,2,3,4,5,6,7,8,9")
+	})
+
+	t.Run("expected shape mismatch", func(t *testing.T) {
+		want := problem(e.CodeShapeMismatch, e.Params{"actual": "3x3", "expected": "4x4"}, "/echo",
+			`"actual_shape":"3x3","constraint":"shape","expected_shape":"4x4"`)
+		testShape(t, Echo, "/echo?expect_shape=4x4", matrix, http.StatusUnprocessableEntity, want)
+	})
+
+	t.Run("invalid expected shape", func(t *testing.T) {
+		want := problem(e.CodeInvalidParameter, e.Params{"param": "expect_shape", "value": "big"}, "/multiply")
+		testShape(t, Multiply, "/multiply?expect_shape=big", matrix, http.StatusBadRequest, want)
+	})
+}
+
+func TestOverflow(t *testing.T) {
+	overflowMatrix := &m.Matrix{
+		Data: [][]string{
+			{"9223372036854775807", "1"},
+			{"2", "3"},
+		},
+	}
+
+	t.Run("sum", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/sum", nil)
+		ctxWithMatrix := context.WithValue(req.Context(), middlewares.RequestFileMatrixKey, overflowMatrix)
+
+		rr := httptest.NewRecorder()
+		RootHandler(Sum).ServeHTTP(rr, req.WithContext(ctxWithMatrix))
+
+		expected := problem(e.CodeOverflow, nil, "/sum")
+		if rr.Body.String() != expected {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("multiply", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/multiply", nil)
+		ctxWithMatrix := context.WithValue(req.Context(), middlewares.RequestFileMatrixKey, overflowMatrix)
+
+		rr := httptest.NewRecorder()
+		RootHandler(Multiply).ServeHTTP(rr, req.WithContext(ctxWithMatrix))
+
+		if status := rr.Code; status != http.StatusUnprocessableEntity {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusUnprocessableEntity)
+		}
+	})
+}
+
+func TestErrorCatalog(t *testing.T) {
+	handler := http.Handler(RootHandler(ErrorCatalog))
+
+	t.Run("lists every code", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/errors", nil))
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+
+		var catalog []e.CatalogEntry
+		if err := json.Unmarshal(rr.Body.Bytes(), &catalog); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(catalog, e.Catalog) {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				catalog, e.Catalog)
+		}
+	})
+
+	t.Run("non GET method", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/errors", nil))
+
+		if status := rr.Code; status != http.StatusMethodNotAllowed {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	req := httptest.NewRequest("POST", "/sum", nil)
+	req.Header.Set("Accept-Language", "fr-FR, fr;q=0.9, en;q=0.8")
+	ctxWithMatrix := context.WithValue(req.Context(), middlewares.RequestFileMatrixKey, wrongMatrix)
+
+	rr := httptest.NewRecorder()
+	RootHandler(Sum).ServeHTTP(rr, req.WithContext(ctxWithMatrix))
+
+	expected := `{"type":"/errors#CELL_NOT_NUMERIC","title":"Cell not numeric","status":400,"detail":"1 cellule(s) ne sont pas des entiers.","instance":"/sum","code":"CELL_NOT_NUMERIC",` +
+		`"errors":[{"row":2,"col":1,"value":"c","detail":"Ligne 2, colonne 1 : 'c' n'est pas un entier."}]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+	if got := rr.Header().Get("Content-Language"); got != "fr" {
+		t.Errorf("handler returned unexpected Content-Language: got %v want %v", got, "fr")
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// HealthStatus is the body of the health endpoints.
+type HealthStatus struct {
+	Status string `json:"status"`
+}
+
+// Healthz reports that the process is alive and able to serve requests.
+func Healthz(w http.ResponseWriter, r *http.Request) error {
+	if error := allowMethods(w, r, http.MethodGet, http.MethodHead); error != nil {
+		return error
+	}
+	return writeJSON(w, http.StatusOK, HealthStatus{"ok"})
+}
+
+// Readiness reports whether the service accepts new traffic. It starts not
+// ready and flips back to not ready when shutdown begins, so load balancers
+// stop routing requests to an instance which is draining.
+type Readiness struct {
+	ready int32
+}
+
+// SetReady marks the service ready or not ready.
+func (rd *Readiness) SetReady(ready bool) {
+	var value int32
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&rd.ready, value)
+}
+
+// Ready reports whether the service is ready.
+func (rd *Readiness) Ready() bool {
+	return atomic.LoadInt32(&rd.ready) == 1
+}
+
+// Readyz answers 200 when the service is ready and 503 otherwise.
+func (rd *Readiness) Readyz(w http.ResponseWriter, r *http.Request) error {
+	if error := allowMethods(w, r, http.MethodGet, http.MethodHead); error != nil {
+		return error
+	}
+	if !rd.Ready() {
+		return writeJSON(w, http.StatusServiceUnavailable, HealthStatus{"unavailable"})
+	}
+	return writeJSON(w, http.StatusOK, HealthStatus{"ok"})
+}
+
+// VersionInfo describes the running build.
+type VersionInfo struct {
+	Path      string `json:"path"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified"`
+}
+
+// Version reports the build information embedded in the binary.
+func Version(w http.ResponseWriter, r *http.Request) error {
+	if error := allowMethods(w, r, http.MethodGet, http.MethodHead); error != nil {
+		return error
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return writeJSON(w, http.StatusOK, VersionInfo{Version: "unknown"})
+	}
+
+	version := VersionInfo{
+		Path:      info.Main.Path,
+		Version:   info.Main.Version,
+		GoVersion: info.GoVersion,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version.Revision = setting.Value
+		case "vcs.time":
+			version.Time = setting.Value
+		case "vcs.modified":
+			version.Modified = setting.Value == "true"
+		}
+	}
+	return writeJSON(w, http.StatusOK, version)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	handler := http.Handler(RootHandler(Healthz))
+
+	t.Run("GET method", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+		expected := "{\"status\":\"ok\"}\n"
+		if rr.Body.String() != expected {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("POST method", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/healthz", nil))
+
+		if status := rr.Code; status != http.StatusMethodNotAllowed {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, http.StatusMethodNotAllowed)
+		}
+		if allow := rr.Header().Get("Allow"); allow != "GET, HEAD" {
+			t.Errorf("handler returned unexpected Allow header: got %v want %v",
+				allow, "GET, HEAD")
+		}
+	})
+}
+
+func TestReadyz(t *testing.T) {
+	readiness := &Readiness{}
+	handler := http.Handler(RootHandler(readiness.Readyz))
+
+	testReadyz := func(t *testing.T, want int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+		if status := rr.Code; status != want {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, want)
+		}
+	}
+
+	t.Run("not ready on start", func(t *testing.T) {
+		testReadyz(t, http.StatusServiceUnavailable)
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		readiness.SetReady(true)
+		testReadyz(t, http.StatusOK)
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		readiness.SetReady(false)
+		testReadyz(t, http.StatusServiceUnavailable)
+	})
+}
+
+func TestVersion(t *testing.T) {
+	rr := httptest.NewRecorder()
+	RootHandler(Version).ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var version VersionInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &version); err != nil {
+		t.Fatal(err)
+	}
+	if version.GoVersion == "" {
+		t.Errorf("handler returned no Go version: %v", rr.Body.String())
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+
+	auth "takehome/auth"
+	err "takehome/errors"
+	jobs "takehome/jobs"
+	middlewares "takehome/middlewares"
+	router "takehome/router"
+)
+
+// Jobs serves the status and results of the jobs run by the
+// AsyncMiddleware, to the clients which submitted them.
+type Jobs struct {
+	runner *jobs.Runner
+}
+
+func NewJobs(runner *jobs.Runner) *Jobs {
+	return &Jobs{runner}
+}
+
+// job returns the job named by the route, unless another client submitted
+// it.
+func (js *Jobs) job(r *http.Request) (*jobs.Job, error) {
+	id := router.Param(r, "id")
+	job, error := js.runner.Get(id)
+	if error == jobs.ErrNotFound || (error == nil && !ownsJob(r, job)) {
+		return nil, jobNotFound(id)
+	}
+	return job, error
+}
+
+// ownsJob reports whether the client of r submitted the job, jobs submitted
+// anonymously being shared by anonymous clients only, as stored matrices.
+func ownsJob(r *http.Request, job *jobs.Job) bool {
+	return job.Owner == auth.Principal(r.Context())
+}
+
+// Get answers with the status and progress of the job.
+func (js *Jobs) Get(w http.ResponseWriter, r *http.Request) error {
+	job, error := js.job(r)
+	if error != nil {
+		return error
+	}
+	return writeJSON(w, http.StatusOK, job)
+}
+
+// Result answers with the response of the operation of a finished job, as
+// it would have been answered without ?async=true.
+func (js *Jobs) Result(w http.ResponseWriter, r *http.Request) error {
+	job, error := js.job(r)
+	if error != nil {
+		return error
+	}
+	if job.Result == nil {
+		status := string(job.Status)
+		return err.NewHTTPError(nil, err.CodeJobResultUnavailable, err.Params{"id": job.ID, "status": status}).
+			With("job_status", status)
+	}
+	header := w.Header()
+	for name, values := range job.Result.Header {
+		// Server-Timing describes this response, not the job.
+		if name == ServerTimingHeader {
+			continue
+		}
+		header[name] = append([]string(nil), values...)
+	}
+	w.WriteHeader(job.Result.Status)
+	w.Write(job.Result.Body)
+	return nil
+}
+
+// Events streams the progress and the outcome of the job as Server-Sent
+// Events.
+func (js *Jobs) Events(w http.ResponseWriter, r *http.Request) error {
+	job, error := js.job(r)
+	if error != nil {
+		return error
+	}
+	if error := middlewares.StreamJob(w, r, js.runner, job.ID); error != jobs.ErrNotFound {
+		return error
+	}
+	return jobNotFound(job.ID)
+}
+
+// Cancel cancels a queued or running job, its context being canceled, and
+// answers with its status.
+func (js *Jobs) Cancel(w http.ResponseWriter, r *http.Request) error {
+	job, error := js.job(r)
+	if error != nil {
+		return error
+	}
+	job, error = js.runner.Cancel(job.ID)
+	if error == jobs.ErrFinished {
+		status := string(job.Status)
+		return err.NewHTTPError(nil, err.CodeJobFinished, err.Params{"id": job.ID, "status": status}).
+			With("job_status", status)
+	}
+	if error == jobs.ErrNotFound {
+		return jobNotFound(router.Param(r, "id"))
+	}
+	if error != nil {
+		return error
+	}
+	return writeJSON(w, http.StatusOK, job)
+}
+
+func jobNotFound(id string) error {
+	return err.NewHTTPError(nil, err.CodeJobNotFound, err.Params{"id": id}).With("id", id)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	auth "takehome/auth"
+	jobs "takehome/jobs"
+	router "takehome/router"
+)
+
+func TestJobs(t *testing.T) {
+	runner, err := jobs.NewRunner(jobs.NewMemoryStore(), 1, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Shutdown(context.Background())
+
+	succeeded := &jobs.Job{Operation: "/sum", Owner: "key:ci"}
+	runner.Submit(context.Background(), succeeded, func(ctx context.Context) *jobs.Result {
+		header := http.Header{}
+		header.Set("Content-Type", "text/plain")
+		header.Set(ServerTimingHeader, "parse;dur=1")
+		return &jobs.Result{Status: http.StatusOK, Header: header, Body: []byte("10")}
+	})
+	anonymous := &jobs.Job{Operation: "/sum"}
+	runner.Submit(context.Background(), anonymous, func(ctx context.Context) *jobs.Result {
+		return &jobs.Result{Status: http.StatusOK}
+	})
+	release := make(chan struct{})
+	defer close(release)
+	running := &jobs.Job{Operation: "/invert", Owner: "key:ci"}
+	runner.Submit(context.Background(), running, func(ctx context.Context) *jobs.Result {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return &jobs.Result{Status: http.StatusOK}
+	})
+	for i := 0; i < 200; i++ {
+		if job, _ := runner.Get(succeeded.ID); job.Status.Finished() {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	handler := NewJobs(runner)
+	rt := router.New()
+	rt.Handle("GET", "/jobs/{id}", RootHandler(handler.Get))
+	rt.Handle("DELETE", "/jobs/{id}", RootHandler(handler.Cancel))
+	rt.Handle("GET", "/jobs/{id}/result", RootHandler(handler.Result))
+	rt.Handle("GET", "/jobs/{id}/events", RootHandler(handler.Events))
+	serve := func(method, target, keyID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r = r.WithContext(auth.WithKey(r.Context(), &auth.Key{ID: keyID}))
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("get", func(t *testing.T) {
+		w := serve("GET", "/jobs/"+succeeded.ID, "ci")
+		var job jobs.Job
+		json.Unmarshal(w.Body.Bytes(), &job)
+		if w.Code != http.StatusOK || job.ID != succeeded.ID || job.Status != jobs.StatusSucceeded || job.Progress != 100 {
+			t.Errorf("got %v %v want the succeeded job", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("result", func(t *testing.T) {
+		w := serve("GET", "/jobs/"+succeeded.ID+"/result", "ci")
+		if w.Code != http.StatusOK || w.Body.String() != "10" || w.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("got %v %v want the result", w.Code, w.Body.String())
+		}
+		if got := w.Header().Get(ServerTimingHeader); strings.Contains(got, "parse") {
+			t.Errorf("got %v want no timings of the job", got)
+		}
+		w = serve("GET", "/jobs/"+running.ID+"/result", "ci")
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"JOB_RESULT_UNAVAILABLE"`) {
+			t.Errorf("got %v %v want %v", w.Code, w.Body.String(), http.StatusConflict)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		w := serve("GET", "/jobs/"+succeeded.ID+"/events", "ci")
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "event: result\n") || !strings.Contains(w.Body.String(), `"body":"10"`) {
+			t.Errorf("got %v %q want the result event", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		w := serve("DELETE", "/jobs/"+running.ID, "ci")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"canceled"`) {
+			t.Errorf("got %v %v want the canceled job", w.Code, w.Body.String())
+		}
+		w = serve("DELETE", "/jobs/"+succeeded.ID, "ci")
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"job_status":"succeeded"`) {
+			t.Errorf("got %v %v want %v", w.Code, w.Body.String(), http.StatusConflict)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		for _, test := range []struct{ method, target, keyID string }{
+			{"GET", "/jobs/" + succeeded.ID, "dashboard"},
+			{"GET", "/jobs/" + succeeded.ID + "/result", "dashboard"},
+			{"GET", "/jobs/" + succeeded.ID + "/events", "dashboard"},
+			{"DELETE", "/jobs/" + succeeded.ID, "dashboard"},
+			{"GET", "/jobs/" + anonymous.ID, "dashboard"},
+			{"GET", "/jobs/unknown", "ci"},
+		} {
+			w := serve(test.method, test.target, test.keyID)
+			if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"code":"JOB_NOT_FOUND"`) {
+				t.Errorf("%v %v as %v: got %v %v want %v", test.method, test.target, test.keyID, w.Code, w.Body.String(), http.StatusNotFound)
+			}
+		}
+	})
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth "takehome/auth"
+	err "takehome/errors"
+	m "takehome/matrix"
+	middlewares "takehome/middlewares"
+	router "takehome/router"
+	store "takehome/store"
+)
+
+// StoredMatrix is the JSON representation of a version of a stored matrix,
+// its cells being omitted once it is saved.
+type StoredMatrix struct {
+	ID        string    `json:"id"`
+	Version   int       `json:"version"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	Shape     m.Shape   `json:"shape"`
+	Data      [][]int   `json:"data,omitempty"`
+}
+
+func storedMatrix(stored *store.Matrix) StoredMatrix {
+	return StoredMatrix{
+		ID:        stored.ID,
+		Version:   stored.Version,
+		Owner:     stored.Owner,
+		CreatedAt: stored.CreatedAt,
+		UpdatedAt: stored.UpdatedAt,
+		UpdatedBy: stored.UpdatedBy,
+		Shape:     stored.Shape,
+	}
+}
+
+// MatrixVersion describes a version of a stored matrix in its history.
+type MatrixVersion struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	Shape     m.Shape   `json:"shape"`
+}
+
+// History lists the versions of a stored matrix, oldest first.
+type History struct {
+	ID       string          `json:"id"`
+	Versions []MatrixVersion `json:"versions"`
+}
+
+// Diff lists the cells changed from a version of a stored matrix to another.
+type Diff struct {
+	ID      string         `json:"id"`
+	From    int            `json:"from"`
+	To      int            `json:"to"`
+	Changes []m.CellChange `json:"changes"`
+}
+
+// Patch is the body of PATCH /matrices/{id}, setting cells of the latest
+// version. When Version is given, the patch fails unless it is the latest.
+type Patch struct {
+	Version int          `json:"version,omitempty"`
+	Set     []CellUpdate `json:"set"`
+}
+
+// CellUpdate sets a cell, Row and Col are 1-based.
+type CellUpdate struct {
+	Row   int  `json:"row"`
+	Col   int  `json:"col"`
+	Value *int `json:"value"`
+}
+
+// Reasons of INVALID_PATCH problems.
+const (
+	PatchMalformed = "malformed"
+	PatchNoCells   = "no_cells"
+)
+
+// Matrices serves the matrices of a store, uploaded once to be operated on
+// by ID at /matrices/{id}/sum and so on, and updated into new versions.
+// Routes naming a matrix expect the StoredMatrixMiddleware to have loaded
+// its latest version.
+type Matrices struct {
+	store        store.Store
+	maxBodyBytes int64
+	now          func() time.Time
+}
+
+// NewMatrices serves the matrices of store, bounding PATCH bodies to
+// maxBodyBytes when positive.
+func NewMatrices(store store.Store, maxBodyBytes int64) *Matrices {
+	return &Matrices{store, maxBodyBytes, time.Now}
+}
+
+// latest returns the latest version of the matrix named by the route.
+func latest(r *http.Request) (*store.Matrix, error) {
+	stored, ok := r.Context().Value(middlewares.StoredMatrixKey).(*store.Matrix)
+	if !ok {
+		return nil, middlewares.MatrixNotFoundError(router.Param(r, "id"))
+	}
+	return stored, nil
+}
+
+// Create stores the uploaded matrix as version 1, owned by the client, and
+// answers 201 with its metadata and its URL in the Location header.
+func (ms *Matrices) Create(w http.ResponseWriter, r *http.Request) error {
+	matrix, error := matrixFromRequest(r, m.NonEmpty)
+	if error != nil {
+		return error
+	}
+	id, error := store.NewID()
+	if error != nil {
+		return error
+	}
+	now := ms.now().UTC()
+	principal := auth.Principal(r.Context())
+	stored := &store.Matrix{
+		ID:        id,
+		Version:   1,
+		Owner:     principal,
+		CreatedAt: now,
+		UpdatedAt: now,
+		UpdatedBy: principal,
+		Shape:     matrix.Shape(),
+		Data:      matrix.Data,
+	}
+	if error := ms.store.Save(stored); error != nil {
+		return error
+	}
+	w.Header().Set("Location", "/matrices/"+id)
+	return writeJSON(w, http.StatusCreated, storedMatrix(stored))
+}
+
+// Get answers with the latest version of the stored matrix, its metadata
+// and its cells.
+func (ms *Matrices) Get(w http.ResponseWriter, r *http.Request) error {
+	stored, error := latest(r)
+	if error != nil {
+		return error
+	}
+	return writeStored(w, stored)
+}
+
+// Replace saves the uploaded matrix as a new version of the stored matrix.
+func (ms *Matrices) Replace(w http.ResponseWriter, r *http.Request) error {
+	stored, error := latest(r)
+	if error != nil {
+		return error
+	}
+	matrix, error := matrixFromRequest(r, m.NonEmpty)
+	if error != nil {
+		return error
+	}
+	return ms.saveVersion(w, r, stored, matrix.Data)
+}
+
+// Update saves a new version of the stored matrix with the cells set by the
+// JSON Patch body.
+func (ms *Matrices) Update(w http.ResponseWriter, r *http.Request) error {
+	stored, error := latest(r)
+	if error != nil {
+		return error
+	}
+	if ms.maxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, ms.maxBodyBytes)
+	}
+	var patch Patch
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if error := decoder.Decode(&patch); error != nil {
+		if problem, ok := middlewares.PayloadTooLargeError(error); ok {
+			return problem
+		}
+		return invalidPatch(error, PatchMalformed)
+	}
+	if len(patch.Set) == 0 {
+		return invalidPatch(nil, PatchNoCells)
+	}
+	if patch.Version != 0 && patch.Version != stored.Version {
+		return versionConflict(stored.ID, stored.Version)
+	}
+
+	data := make([][]string, len(stored.Data))
+	for i, row := range stored.Data {
+		data[i] = append([]string(nil), row...)
+	}
+	for _, update := range patch.Set {
+		if update.Value == nil {
+			return invalidPatch(nil, PatchMalformed)
+		}
+		if update.Row < 1 || update.Row > len(data) || update.Col < 1 || update.Col > len(data[update.Row-1]) {
+			shape := stored.Shape.String()
+			return err.NewHTTPError(nil, err.CodeCellOutOfRange, err.Params{"row": update.Row, "col": update.Col, "shape": shape}).
+				With("row", update.Row).
+				With("col", update.Col)
+		}
+		data[update.Row-1][update.Col-1] = strconv.Itoa(*update.Value)
+	}
+	return ms.saveVersion(w, r, stored, data)
+}
+
+// saveVersion saves data as the version following stored and answers with
+// its metadata, the URL of the version in the Content-Location header.
+func (ms *Matrices) saveVersion(w http.ResponseWriter, r *http.Request, stored *store.Matrix, data [][]string) error {
+	next := &store.Matrix{
+		ID:        stored.ID,
+		Version:   stored.Version + 1,
+		Owner:     stored.Owner,
+		CreatedAt: stored.CreatedAt,
+		UpdatedAt: ms.now().UTC(),
+		UpdatedBy: auth.Principal(r.Context()),
+		Shape:     m.Matrix{Data: data}.Shape(),
+		Data:      data,
+	}
+	error := ms.store.Save(next)
+	if error == store.ErrConflict {
+		// Another update saved this version first.
+		current, error := ms.store.Get(stored.ID)
+		if error != nil {
+			return error
+		}
+		return versionConflict(stored.ID, current.Version)
+	}
+	if error != nil {
+		return error
+	}
+	w.Header().Set("Content-Location", "/matrices/"+next.ID+"/versions/"+strconv.Itoa(next.Version))
+	return writeJSON(w, http.StatusOK, storedMatrix(next))
+}
+
+// Versions lists the versions of the stored matrix, oldest first.
+func (ms *Matrices) Versions(w http.ResponseWriter, r *http.Request) error {
+	stored, error := latest(r)
+	if error != nil {
+		return error
+	}
+	versions, error := ms.store.Versions(stored.ID)
+	if error == store.ErrNotFound {
+		return middlewares.MatrixNotFoundError(stored.ID)
+	}
+	if error != nil {
+		return error
+	}
+	history := History{ID: stored.ID, Versions: make([]MatrixVersion, len(versions))}
+	for i, version := range versions {
+		history.Versions[i] = MatrixVersion{
+			Version:   version.Version,
+			UpdatedAt: version.UpdatedAt,
+			UpdatedBy: version.UpdatedBy,
+			Shape:     version.Shape,
+		}
+	}
+	return writeJSON(w, http.StatusOK, history)
+}
+
+// GetVersion answers with a version of the stored matrix, its metadata and
+// its cells.
+func (ms *Matrices) GetVersion(w http.ResponseWriter, r *http.Request) error {
+	stored, error := latest(r)
+	if error != nil {
+		return error
+	}
+	version, error := ms.version(stored, "version", router.Param(r, "version"))
+	if error != nil {
+		return error
+	}
+	return writeStored(w, version)
+}
+
+// Diff lists the cells changed between the versions given by the from and
+// to parameters, by default from the version preceding the latest one to
+// the latest one.
+func (ms *Matrices) Diff(w http.ResponseWriter, r *http.Request) error {
+	stored, error := latest(r)
+	if error != nil {
+		return error
+	}
+	to := stored
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, error = ms.version(stored, "to", value); error != nil {
+			return error
+		}
+	}
+	from := to
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, error = ms.version(stored, "from", value); error != nil {
+			return error
+		}
+	} else if to.Version > 1 {
+		if from, error = ms.version(stored, "from", strconv.Itoa(to.Version-1)); error != nil {
+			return error
+		}
+	}
+	changes := m.Matrix{Data: from.Data}.Diff(m.Matrix{Data: to.Data})
+	return writeJSON(w, http.StatusOK, Diff{ID: stored.ID, From: from.Version, To: to.Version, Changes: changes})
+}
+
+// version returns the version of stored given by the value of param.
+func (ms *Matrices) version(stored *store.Matrix, param, value string) (*store.Matrix, error) {
+	number, error := strconv.Atoi(value)
+	if error != nil {
+		return nil, err.NewHTTPError(error, err.CodeInvalidParameter, err.Params{"param": param, "value": value})
+	}
+	if number == stored.Version {
+		return stored, nil
+	}
+	version, error := ms.store.GetVersion(stored.ID, number)
+	if error == store.ErrNotFound {
+		return nil, err.NewHTTPError(nil, err.CodeVersionNotFound, err.Params{"id": stored.ID, "version": number}).
+			With("version", number)
+	}
+	return version, error
+}
+
+// Delete removes every version of the stored matrix and answers 204.
+func (ms *Matrices) Delete(w http.ResponseWriter, r *http.Request) error {
+	stored, error := latest(r)
+	if error != nil {
+		return error
+	}
+	error = ms.store.Delete(stored.ID)
+	if error == store.ErrNotFound {
+		return middlewares.MatrixNotFoundError(stored.ID)
+	}
+	if error != nil {
+		return error
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// writeStored answers with a version of a stored matrix and its cells.
+func writeStored(w http.ResponseWriter, stored *store.Matrix) error {
+	data, error := integers(stored.Data)
+	if error != nil {
+		return arithmeticError(error)
+	}
+	result := storedMatrix(stored)
+	result.Data = data
+	return writeJSON(w, http.StatusOK, result)
+}
+
+// invalidPatch reports a PATCH body which is not a valid patch.
+func invalidPatch(cause error, reason string) error {
+	return err.NewHTTPError(cause, err.CodeInvalidPatch, err.Params{"reason": reason}).With("reason", reason)
+}
+
+// versionConflict reports an update based on another version than the
+// latest one.
+func versionConflict(id string, version int) error {
+	return err.NewHTTPError(nil, err.CodeVersionConflict, err.Params{"id": id, "version": version}).
+		With("version", version)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	auth "takehome/auth"
+	m "takehome/matrix"
+	middlewares "takehome/middlewares"
+	router "takehome/router"
+	store "takehome/store"
+)
+
+func TestMatrices(t *testing.T) {
+	matrices := NewMatrices(store.NewMemoryStore(), 1024)
+	now := time.Unix(1700000000, 0)
+	matrices.now = func() time.Time { return now }
+
+	// Routes naming a matrix load it first, and PUT then reads the upload.
+	rt := router.New()
+	stored := func(next http.Handler) http.Handler {
+		return middlewares.NewStoredMatrixMiddleware(next, matrices.store)
+	}
+	upload := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			replacement := &m.Matrix{Data: [][]string{{"1", "2"}, {"3", "4"}}}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middlewares.RequestFileMatrixKey, replacement)))
+		})
+	}
+	rt.Handle("GET", "/matrices/{id}", RootHandler(matrices.Get), stored)
+	rt.Handle("PUT", "/matrices/{id}", RootHandler(matrices.Replace), stored, upload)
+	rt.Handle("PATCH", "/matrices/{id}", RootHandler(matrices.Update), stored)
+	rt.Handle("GET", "/matrices/{id}/versions", RootHandler(matrices.Versions), stored)
+	rt.Handle("GET", "/matrices/{id}/versions/{version}", RootHandler(matrices.GetVersion), stored)
+	rt.Handle("GET", "/matrices/{id}/diff", RootHandler(matrices.Diff), stored)
+	rt.Handle("DELETE", "/matrices/{id}", RootHandler(matrices.Delete), stored)
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r = r.WithContext(auth.WithKey(r.Context(), &auth.Key{ID: "ci"}))
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	r := httptest.NewRequest("POST", "/matrices", nil)
+	ctx := context.WithValue(r.Context(), middlewares.RequestFileMatrixKey, matrix)
+	ctx = auth.WithKey(ctx, &auth.Key{ID: "ci"})
+	w := httptest.NewRecorder()
+	RootHandler(matrices.Create).ServeHTTP(w, r.WithContext(ctx))
+
+	location := w.Header().Get("Location")
+	id := strings.TrimPrefix(location, "/matrices/")
+	if w.Code != http.StatusCreated || !store.ValidID(id) {
+		t.Fatalf("got %v %v want %v", w.Code, w.Body.String(), http.StatusCreated)
+	}
+	metadata := `{"id":"` + id + `","version":1,"owner":"key:ci","created_at":"2023-11-14T22:13:20Z","updated_at":"2023-11-14T22:13:20Z","updated_by":"key:ci","shape":{"rows":3,"cols":3}`
+	if w.Body.String() != metadata+"}\n" {
+		t.Errorf("got %v want %v", w.Body.String(), metadata+"}")
+	}
+
+	t.Run("get", func(t *testing.T) {
+		w := serve("GET", location, "")
+		want := metadata + `,"data":[[1,2,3],[4,5,6],[7,8,9]]}` + "\n"
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("got %v %v want %v", w.Code, w.Body.String(), want)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		RootHandler(matrices.Create).ServeHTTP(w, httptest.NewRequest("POST", "/matrices", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got %v want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		now = now.Add(time.Hour)
+		w := serve("PATCH", location, `{"version": 1, "set": [{"row": 2, "col": 2, "value": -5}, {"row": 3, "col": 1, "value": 0}]}`)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Location") != location+"/versions/2" {
+			t.Fatalf("got %v %v want version 2", w.Code, w.Body.String())
+		}
+		now = now.Add(time.Hour)
+		if w := serve("PUT", location, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"version":3`) {
+			t.Fatalf("got %v %v want version 3", w.Code, w.Body.String())
+		}
+
+		w = serve("GET", location+"/versions", "")
+		want := `{"id":"` + id + `","versions":[` +
+			`{"version":1,"updated_at":"2023-11-14T22:13:20Z","updated_by":"key:ci","shape":{"rows":3,"cols":3}},` +
+			`{"version":2,"updated_at":"2023-11-14T23:13:20Z","updated_by":"key:ci","shape":{"rows":3,"cols":3}},` +
+			`{"version":3,"updated_at":"2023-11-15T00:13:20Z","updated_by":"key:ci","shape":{"rows":2,"cols":2}}]}` + "\n"
+		if w.Body.String() != want {
+			t.Errorf("got %v want %v", w.Body.String(), want)
+		}
+
+		w = serve("GET", location+"/versions/2", "")
+		if !strings.Contains(w.Body.String(), `"data":[[1,2,3],[4,-5,6],[0,8,9]]`) {
+			t.Errorf("got %v want the patched cells", w.Body.String())
+		}
+
+		tests := []struct {
+			query string
+			want  string
+		}{
+			{"?from=1&to=2", `{"id":"` + id + `","from":1,"to":2,"changes":[` +
+				`{"row":2,"col":2,"op":"changed","from":"5","to":"-5"},{"row":3,"col":1,"op":"changed","from":"7","to":"0"}]}`},
+			{"?to=2", `{"id":"` + id + `","from":1,"to":2,"changes":[` +
+				`{"row":2,"col":2,"op":"changed","from":"5","to":"-5"},{"row":3,"col":1,"op":"changed","from":"7","to":"0"}]}`},
+			{"?from=3&to=3", `{"id":"` + id + `","from":3,"to":3,"changes":[]}`},
+			{"", `{"id":"` + id + `","from":2,"to":3,"changes":[` +
+				`{"row":1,"col":3,"op":"removed","from":"3"},{"row":2,"col":1,"op":"changed","from":"4","to":"3"},` +
+				`{"row":2,"col":2,"op":"changed","from":"-5","to":"4"},{"row":2,"col":3,"op":"removed","from":"6"},` +
+				`{"row":3,"col":1,"op":"removed","from":"0"},{"row":3,"col":2,"op":"removed","from":"8"},{"row":3,"col":3,"op":"removed","from":"9"}]}`},
+		}
+		for _, test := range tests {
+			w := serve("GET", location+"/diff"+test.query, "")
+			if w.Code != http.StatusOK || w.Body.String() != test.want+"\n" {
+				t.Errorf("%v: got %v %v want %v", test.query, w.Code, w.Body.String(), test.want)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			method string
+			target string
+			body   string
+			status int
+			code   string
+		}{
+			{"PATCH", location, `{"set": [{"row": 1, "col": 1, "value": "7"}]}`, http.StatusBadRequest, `"reason":"malformed"`},
+			{"PATCH", location, `{"set": [{"row": 1, "col": 1}]}`, http.StatusBadRequest, `"reason":"malformed"`},
+			{"PATCH", location, `{"cells": []}`, http.StatusBadRequest, `"reason":"malformed"`},
+			{"PATCH", location, `{"set": []}`, http.StatusBadRequest, `"reason":"no_cells"`},
+			{"PATCH", location, `{"set": [{"row": 3, "col": 1, "value": 7}]}`, http.StatusUnprocessableEntity, `"code":"CELL_OUT_OF_RANGE"`},
+			{"PATCH", location, `{"version": 2, "set": [{"row": 1, "col": 1, "value": 7}]}`, http.StatusConflict, `"version":3`},
+			{"PATCH", location, `{"set": [` + strings.Repeat(`{"row": 1, "col": 1, "value": 7},`, 50) + `]}`, http.StatusRequestEntityTooLarge, `"code":"PAYLOAD_TOO_LARGE"`},
+			{"GET", location + "/versions/4", "", http.StatusNotFound, `"code":"VERSION_NOT_FOUND"`},
+			{"GET", location + "/diff?from=first", "", http.StatusBadRequest, `"code":"INVALID_PARAMETER"`},
+		}
+		for _, test := range tests {
+			w := serve(test.method, test.target, test.body)
+			if w.Code != test.status || !strings.Contains(w.Body.String(), test.code) {
+				t.Errorf("%v %v: got %v %v want %v %v", test.method, test.body, w.Code, w.Body.String(), test.status, test.code)
+			}
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		// Another update saves version 4 once this one loaded version 3.
+		latest, _ := matrices.store.Get(id)
+		rt := router.New()
+		rt.Handle("PATCH", "/matrices/{id}", RootHandler(matrices.Update), stored, func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				other := *latest
+				other.Version = 4
+				matrices.store.Save(&other)
+				next.ServeHTTP(w, r)
+			})
+		})
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PATCH", location, strings.NewReader(`{"set": [{"row": 1, "col": 1, "value": 7}]}`))
+		rt.ServeHTTP(w, r.WithContext(auth.WithKey(r.Context(), &auth.Key{ID: "ci"})))
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"version":4`) {
+			t.Errorf("got %v %v want %v", w.Code, w.Body.String(), http.StatusConflict)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if w := serve("DELETE", location, ""); w.Code != http.StatusNoContent {
+			t.Errorf("got %v want %v", w.Code, http.StatusNoContent)
+		}
+		if w := serve("GET", location+"/versions", ""); w.Code != http.StatusNotFound {
+			t.Errorf("got %v want %v", w.Code, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"net/http"
+
+	metrics "takehome/metrics"
+)
+
+// Metrics writes the service metrics in the Prometheus text exposition
+// format.
+func Metrics(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	_, error := metrics.Default.WriteTo(w)
+	return error
+}
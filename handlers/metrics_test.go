@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metrics "takehome/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	rr := httptest.NewRecorder()
+	RootHandler(Metrics).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("Content-Type"); got != metrics.ContentType {
+		t.Errorf("got %v want %v", got, metrics.ContentType)
+	}
+	for _, want := range []string{
+		"# TYPE matrix_http_requests_total counter\n",
+		"# TYPE matrix_http_request_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("metrics %q do not contain %q", rr.Body.String(), want)
+		}
+	}
+}
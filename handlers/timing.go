@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	m "takehome/matrix"
+	middlewares "takehome/middlewares"
+)
+
+// Response metadata headers, describing where the time of a request went and
+// the input matrix.
+const (
+	ServerTimingHeader = "Server-Timing"
+	MatrixRowsHeader   = "X-Matrix-Rows"
+	MatrixColsHeader   = "X-Matrix-Cols"
+	MatrixCellsHeader  = "X-Matrix-Cells"
+)
+
+type contextKey int
+
+const timingsKey contextKey = 0
+
+// timings measures the phases of a request: parsing the upload, validating
+// the matrix and computing the result, from validation to response.
+type timings struct {
+	mu           sync.Mutex
+	parse        time.Duration
+	validate     time.Duration
+	compute      time.Duration
+	parsed       bool
+	validated    bool
+	computed     bool
+	computeStart time.Time
+}
+
+func newTimings(r *http.Request) *timings {
+	t := &timings{computeStart: time.Now()}
+	if parse, ok := r.Context().Value(middlewares.RequestParseDurationKey).(time.Duration); ok {
+		t.parse = parse
+		t.parsed = true
+	}
+	return t
+}
+
+func withTimings(ctx context.Context, t *timings) context.Context {
+	return context.WithValue(ctx, timingsKey, t)
+}
+
+func timingsFromRequest(r *http.Request) *timings {
+	t, _ := r.Context().Value(timingsKey).(*timings)
+	return t
+}
+
+// recordValidate records the validation time, computing starts after it.
+func (t *timings) recordValidate(validate time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.validate = validate
+	t.validated = true
+	t.computeStart = time.Now()
+}
+
+// finish stops the compute timer, only the first call counts.
+func (t *timings) finish() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.computed {
+		t.compute = time.Since(t.computeStart)
+		t.computed = true
+	}
+}
+
+// phases returns the measured phases in milliseconds, in request order.
+func (t *timings) phases() ([]string, map[string]float64) {
+	t.finish()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var names []string
+	millis := map[string]float64{}
+	add := func(name string, d time.Duration) {
+		names = append(names, name)
+		millis[name] = float64(d.Microseconds()) / 1000
+	}
+	if t.parsed {
+		add("parse", t.parse)
+	}
+	if t.validated {
+		add("validate", t.validate)
+	}
+	add("compute", t.compute)
+	return names, millis
+}
+
+// serverTiming formats the phases as a Server-Timing header.
+func (t *timings) serverTiming() string {
+	names, millis := t.phases()
+	metrics := make([]string, len(names))
+	for i, name := range names {
+		metrics[i] = name + ";dur=" + strconv.FormatFloat(millis[name], 'f', 3, 64)
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// metadataWriter sets the metadata headers once the handler starts its
+// response, or once it returns without one.
+type metadataWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	timings *timings
+	written bool
+}
+
+func (mw *metadataWriter) writeMetadata() {
+	if mw.written {
+		return
+	}
+	mw.written = true
+	header := mw.Header()
+	header.Set(ServerTimingHeader, mw.timings.serverTiming())
+	if matrix, ok := mw.r.Context().Value(middlewares.RequestFileMatrixKey).(*m.Matrix); ok {
+		shape := matrix.Shape()
+		header.Set(MatrixRowsHeader, strconv.Itoa(shape.Rows))
+		header.Set(MatrixColsHeader, strconv.Itoa(shape.Cols))
+		header.Set(MatrixCellsHeader, strconv.Itoa(shape.Cells()))
+	}
+}
+
+func (mw *metadataWriter) WriteHeader(status int) {
+	mw.writeMetadata()
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *metadataWriter) Write(p []byte) (int, error) {
+	mw.writeMetadata()
+	return mw.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers flush through the writer.
+func (mw *metadataWriter) Flush() {
+	mw.writeMetadata()
+	if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (mw *metadataWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
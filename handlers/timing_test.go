@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	e "takehome/errors"
+	middlewares "takehome/middlewares"
+)
+
+func TestResponseMetadata(t *testing.T) {
+	// newRequest returns a request carrying matrix as parsed by the upload
+	// middleware in 2ms.
+	newRequest := func(target, accept string) *http.Request {
+		req := httptest.NewRequest("POST", target, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		ctx := context.WithValue(req.Context(), middlewares.RequestFileMatrixKey, matrix)
+		ctx = context.WithValue(ctx, middlewares.RequestParseDurationKey, 2*time.Millisecond)
+		return req.WithContext(ctx)
+	}
+
+	t.Run("headers", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		RootHandler(Sum).ServeHTTP(rr, newRequest("/sum", ""))
+
+		serverTiming := regexp.MustCompile(`^parse;dur=2\.000, validate;dur=\d+\.\d{3}, compute;dur=\d+\.\d{3}$`)
+		if got := rr.Header().Get(ServerTimingHeader); !serverTiming.MatchString(got) {
+			t.Errorf("got %v want %v", got, serverTiming)
+		}
+		for header, want := range map[string]string{MatrixRowsHeader: "3", MatrixColsHeader: "3", MatrixCellsHeader: "9"} {
+			if got := rr.Header().Get(header); got != want {
+				t.Errorf("got %v %v want %v", header, got, want)
+			}
+		}
+		if rr.Body.String() != "45" {
+			t.Errorf("got %v want %v", rr.Body.String(), "45")
+		}
+	})
+
+	t.Run("headers on problems", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		RootHandler(Sum).ServeHTTP(rr, newRequest("/sum?expect_shape=2x2", ""))
+
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+		if rr.Header().Get(ServerTimingHeader) == "" || rr.Header().Get(MatrixCellsHeader) != "9" {
+			t.Errorf("got %v want the metadata headers", rr.Header())
+		}
+	})
+
+	t.Run("headers without matrix", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		RootHandler(Healthz).ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+		if got := rr.Header().Get(ServerTimingHeader); !regexp.MustCompile(`^compute;dur=\d+\.\d{3}$`).MatchString(got) {
+			t.Errorf("got %v want the compute duration only", got)
+		}
+		if got := rr.Header().Get(MatrixRowsHeader); got != "" {
+			t.Errorf("got %v want none", got)
+		}
+	})
+
+	t.Run("JSON results", func(t *testing.T) {
+		tests := []struct {
+			handler RootHandler
+			target  string
+			want    string
+		}{
+			{Echo, "/echo", "[[1,2,3],[4,5,6],[7,8,9]]\n"},
+			{Invert, "/invert", "[[1,4,7],[2,5,8],[3,6,9]]\n"},
+			{Flatten, "/flatten", "[1,2,3,4,5,6,7,8,9]\n"},
+			{Sum, "/sum", "45\n"},
+			{Multiply, "/multiply", "362880\n"},
+		}
+		for _, test := range tests {
+			rr := httptest.NewRecorder()
+			test.handler.ServeHTTP(rr, newRequest(test.target, "text/csv;q=0.5, application/json"))
+
+			if rr.Body.String() != test.want {
+				t.Errorf("%v: got %v want %v", test.target, rr.Body.String(), test.want)
+			}
+			if got := rr.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+				t.Errorf("%v: got %v want JSON", test.target, got)
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		RootHandler(Echo).ServeHTTP(rr, newRequest("/echo", "application/json;q=0"))
+		if rr.Body.String() != "1,2,3\n4,5,6\n7,8,9\n" {
+			t.Errorf("got %v want the text result", rr.Body.String())
+		}
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		RootHandler(Sum).ServeHTTP(rr, newRequest("/sum?envelope=true", "application/json"))
+
+		var envelope Envelope
+		if err := json.Unmarshal(rr.Body.Bytes(), &envelope); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Result != 45.0 || envelope.Meta.Rows != 3 || envelope.Meta.Cols != 3 || envelope.Meta.Cells != 9 {
+			t.Errorf("got %+v want the result and input shape", envelope)
+		}
+		if envelope.Meta.TimingsMs["parse"] != 2 || len(envelope.Meta.TimingsMs) != 3 {
+			t.Errorf("got %v want parse, validate and compute", envelope.Meta.TimingsMs)
+		}
+	})
+
+	t.Run("invalid envelope", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		RootHandler(Sum).ServeHTTP(rr, newRequest("/sum?envelope=maybe", "application/json"))
+
+		expected := problem(e.CodeInvalidParameter, e.Params{"param": EnvelopeParam, "value": "maybe"}, "/sum")
+		if rr.Code != http.StatusBadRequest || rr.Body.String() != expected {
+			t.Errorf("got %v %v want %v", rr.Code, rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("JSON result of a non numeric cell", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/echo", nil)
+		req.Header.Set("Accept", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), middlewares.RequestFileMatrixKey, wrongMatrix))
+		rr := httptest.NewRecorder()
+		RootHandler(Echo).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -0,0 +1,447 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	logging "takehome/logging"
+	m "takehome/matrix"
+	metrics "takehome/metrics"
+	store "takehome/store"
+)
+
+// Status is the state of a job.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Finished reports whether a job in this state will not change anymore.
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+// ErrorInterrupted is the error of jobs which were queued or running when
+// the process stopped.
+const ErrorInterrupted = "interrupted"
+
+var (
+	// ErrQueueFull is returned when no job may be queued until a worker is
+	// free.
+	ErrQueueFull = errors.New("Job queue full.")
+	// ErrFinished is returned when canceling a finished job.
+	ErrFinished = errors.New("Job finished.")
+	// ErrClientLimit is returned when the client of a job has as many jobs
+	// queued or running as it may.
+	ErrClientLimit = errors.New("Too many jobs of the client.")
+)
+
+var (
+	jobsFinished = metrics.Default.NewCounterVec("matrix_jobs_total",
+		"Jobs finished, by status: succeeded, failed or canceled.", "status")
+	jobsQueued = metrics.Default.NewGaugeVec("matrix_jobs_queued",
+		"Jobs waiting for a worker.")
+)
+
+// Job is an operation run in the background. Its JSON representation is
+// the status reported to clients, without the result.
+type Job struct {
+	ID        string `json:"id"`
+	Operation string `json:"operation"`
+	Owner     string `json:"owner,omitempty"`
+	// Client identifies the client submitting the job for MaxPerClient, e.g.
+	// its owner or its IP address. It is not kept once the job finished.
+	Client string `json:"-"`
+	// CallbackURL is notified once the job finished.
+	CallbackURL string `json:"callback_url,omitempty"`
+	Status      Status `json:"status"`
+	// Progress is the completed percentage of the operation, which went
+	// through RowsDone of the Rows of its matrix.
+	Progress   float64    `json:"progress"`
+	Rows       int        `json:"rows,omitempty"`
+	RowsDone   int        `json:"rows_done,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Result     *Result    `json:"-"`
+}
+
+func (j *Job) clone() *Job {
+	copied := *j
+	return &copied
+}
+
+// Result is the response of the operation of a finished job.
+type Result struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// Func runs the operation of a job, which should stop once ctx is done. The
+// progress it reports with matrix.ReportProgress is recorded in the job.
+type Func func(ctx context.Context) *Result
+
+// Runner runs jobs on a bounded number of workers, queuing up to a bounded
+// number of jobs, and keeps their state and results in a store.
+type Runner struct {
+	// OnFinish, when set before jobs are submitted, is called with every
+	// job once it finished, with its result. It must not block.
+	OnFinish func(job *Job)
+	// MaxPerClient, when positive and set before jobs are submitted, bounds
+	// the jobs queued or running of each client, so a client cannot fill the
+	// queue for the others.
+	MaxPerClient int
+	// Logger logs the errors of the store which jobs outlive, e.g. a full
+	// disk failing the save of their status. It discards them by default.
+	Logger *logging.Logger
+
+	store     Store
+	queueSize int
+	retention time.Duration
+	now       func() time.Time
+	workers   sync.WaitGroup
+
+	// mu serializes the changes of the jobs queued or running and their
+	// saves, so the store never goes back to a previous state. queued
+	// signals workers that a job is queued or the runner is shut down.
+	mu        sync.Mutex
+	queued    *sync.Cond
+	pending   []*task
+	live      map[string]*task
+	perClient map[string]int
+	closed    bool
+	// stopPruning is closed once the runner is shut down.
+	stopPruning chan struct{}
+}
+
+// task is a job queued or running.
+type task struct {
+	job      *Job
+	fn       Func
+	ctx      context.Context
+	cancel   context.CancelFunc
+	watchers []chan *Job
+}
+
+// pruneInterval is how often jobs finished for longer than the retention
+// are deleted.
+const pruneInterval = time.Minute
+
+// NewRunner starts workers running the jobs queued, up to queueSize, and
+// keeps finished jobs for retention, or forever when it is zero. Jobs the
+// store has queued or running, as the previous process stopped, fail as
+// interrupted.
+func NewRunner(jobs Store, workers, queueSize int, retention time.Duration) (*Runner, error) {
+	rn := &Runner{
+		store:     jobs,
+		queueSize: queueSize,
+		retention: retention,
+		Logger:    logging.Discard,
+		now:       time.Now,
+		live:      map[string]*task{},
+		perClient: map[string]int{},
+
+		stopPruning: make(chan struct{}),
+	}
+	rn.queued = sync.NewCond(&rn.mu)
+	stored, err := jobs.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range stored {
+		if job.Status.Finished() {
+			continue
+		}
+		now := rn.now().UTC()
+		job.Status, job.Error, job.FinishedAt = StatusFailed, ErrorInterrupted, &now
+		if err := jobs.Save(job); err != nil {
+			return nil, err
+		}
+		jobsFinished.Inc(string(StatusFailed))
+	}
+	for i := 0; i < workers; i++ {
+		rn.workers.Add(1)
+		go rn.work()
+	}
+	if retention > 0 {
+		go rn.pruneEvery(pruneInterval)
+	}
+	return rn, nil
+}
+
+// Submit queues job to be run by fn, with the values of ctx but not its
+// cancelation. It sets the ID, status and creation time of job, or returns
+// ErrQueueFull, or ErrClientLimit when its client has MaxPerClient jobs.
+func (rn *Runner) Submit(ctx context.Context, job *Job, fn Func) error {
+	id, err := store.NewID()
+	if err != nil {
+		return err
+	}
+	job.ID, job.Status, job.Progress, job.CreatedAt = id, StatusQueued, 0, rn.now().UTC()
+	taskCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	t := &task{job: job.clone(), fn: fn, cancel: cancel}
+	t.ctx = m.WithProgress(taskCtx, func(p m.Progress) { rn.progress(t, p) })
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if rn.closed || len(rn.pending) >= rn.queueSize {
+		cancel()
+		return ErrQueueFull
+	}
+	if rn.MaxPerClient > 0 && rn.perClient[job.Client] >= rn.MaxPerClient {
+		cancel()
+		return ErrClientLimit
+	}
+	if err := rn.store.Save(t.job); err != nil {
+		cancel()
+		return err
+	}
+	rn.perClient[job.Client]++
+	rn.live[id] = t
+	rn.pending = append(rn.pending, t)
+	jobsQueued.Add(1)
+	rn.queued.Signal()
+	return nil
+}
+
+// Get returns the job of id with its result, or ErrNotFound.
+func (rn *Runner) Get(id string) (*Job, error) {
+	rn.mu.Lock()
+	if t, ok := rn.live[id]; ok {
+		job := t.job.clone()
+		rn.mu.Unlock()
+		return job, nil
+	}
+	rn.mu.Unlock()
+	return rn.store.Get(id)
+}
+
+// Watch returns the job of id, and a channel receiving it again after each
+// change of its status or progress, intermediate changes being skipped when
+// they are not received in time. The channel is closed once the job
+// finished, and is nil when it already had. stop stops watching.
+func (rn *Runner) Watch(id string) (job *Job, changes <-chan *Job, stop func(), err error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	t, ok := rn.live[id]
+	if !ok {
+		job, err = rn.store.Get(id)
+		return job, nil, func() {}, err
+	}
+	watcher := make(chan *Job, 1)
+	t.watchers = append(t.watchers, watcher)
+	stop = func() {
+		rn.mu.Lock()
+		defer rn.mu.Unlock()
+		for i, w := range t.watchers {
+			if w == watcher {
+				t.watchers = append(t.watchers[:i], t.watchers[i+1:]...)
+				break
+			}
+		}
+	}
+	return t.job.clone(), watcher, stop, nil
+}
+
+// changed sends the job of t to its watchers, replacing the change they
+// did not receive yet. The caller holds mu.
+func (t *task) changed() {
+	for _, watcher := range t.watchers {
+		select {
+		case <-watcher:
+		default:
+		}
+		watcher <- t.job.clone()
+	}
+}
+
+// Cancel cancels the job of id, queued or running, and returns it. It
+// returns ErrFinished with finished jobs, or ErrNotFound.
+func (rn *Runner) Cancel(id string) (*Job, error) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	t, ok := rn.live[id]
+	if !ok {
+		job, err := rn.store.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return job, ErrFinished
+	}
+	if t.job.Status == StatusQueued {
+		for i, pending := range rn.pending {
+			if pending == t {
+				rn.pending = append(rn.pending[:i], rn.pending[i+1:]...)
+				break
+			}
+		}
+		jobsQueued.Add(-1)
+	}
+	t.cancel()
+	rn.finish(t, StatusCanceled, nil)
+	return t.job.clone(), nil
+}
+
+// Shutdown stops queuing jobs and waits for the running ones, canceling
+// them once ctx is done. Jobs still queued stay so in the store, and fail
+// as interrupted when the next runner starts.
+func (rn *Runner) Shutdown(ctx context.Context) error {
+	rn.mu.Lock()
+	if !rn.closed {
+		close(rn.stopPruning)
+	}
+	rn.closed = true
+	rn.queued.Broadcast()
+	rn.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		rn.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		rn.mu.Lock()
+		for _, t := range rn.live {
+			t.cancel()
+		}
+		rn.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (rn *Runner) work() {
+	defer rn.workers.Done()
+	for {
+		rn.mu.Lock()
+		for len(rn.pending) == 0 && !rn.closed {
+			rn.queued.Wait()
+		}
+		if rn.closed {
+			// Once shut down, queued jobs are left queued.
+			rn.mu.Unlock()
+			return
+		}
+		t := rn.pending[0]
+		rn.pending = rn.pending[1:]
+		jobsQueued.Add(-1)
+		now := rn.now().UTC()
+		t.job.Status, t.job.StartedAt = StatusRunning, &now
+		rn.save(t.job)
+		t.changed()
+		rn.mu.Unlock()
+
+		rn.run(t)
+	}
+}
+
+func (rn *Runner) run(t *task) {
+	result := t.fn(t.ctx)
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if t.job.Status != StatusRunning {
+		// Canceled while running, the result is dropped.
+		return
+	}
+	status := StatusSucceeded
+	if result == nil || result.Status >= http.StatusBadRequest {
+		status = StatusFailed
+	}
+	rn.finish(t, status, result)
+}
+
+// finish records the end of the job of t. The caller holds mu.
+func (rn *Runner) finish(t *task, status Status, result *Result) {
+	now := rn.now().UTC()
+	t.job.Status, t.job.FinishedAt, t.job.Result = status, &now, result
+	if status == StatusSucceeded {
+		t.job.Progress, t.job.RowsDone = 100, t.job.Rows
+	}
+	rn.save(t.job)
+	delete(rn.live, t.job.ID)
+	if rn.perClient[t.job.Client]--; rn.perClient[t.job.Client] <= 0 {
+		delete(rn.perClient, t.job.Client)
+	}
+	t.cancel()
+	t.changed()
+	for _, watcher := range t.watchers {
+		close(watcher)
+	}
+	t.watchers = nil
+	jobsFinished.Inc(string(status))
+	if rn.OnFinish != nil {
+		rn.OnFinish(t.job.clone())
+	}
+}
+
+// save saves job, logging the error as the job goes on without it. The
+// caller holds mu.
+func (rn *Runner) save(job *Job) {
+	if err := rn.store.Save(job); err != nil {
+		rn.Logger.Error("job store error", logging.Fields{
+			"job_id": job.ID,
+			"status": string(job.Status),
+			"error":  err,
+		})
+	}
+}
+
+// progress records the progress of a running job, which only goes forward.
+func (rn *Runner) progress(t *task, p m.Progress) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	percent := p.Percent()
+	if t.job.Status != StatusRunning || percent <= t.job.Progress || percent > 100 {
+		return
+	}
+	t.job.Progress, t.job.Rows, t.job.RowsDone = percent, p.Rows, p.RowsDone
+	t.changed()
+}
+
+// pruneEvery prunes the store every interval until the runner is shut
+// down.
+func (rn *Runner) pruneEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rn.prune()
+		case <-rn.stopPruning:
+			return
+		}
+	}
+}
+
+// prune deletes the jobs finished for longer than the retention. It does
+// not hold mu, as listing the store may take long, and only finished jobs,
+// which no longer change, are deleted.
+func (rn *Runner) prune() {
+	now := rn.now()
+	jobs, err := rn.store.List()
+	if err != nil {
+		rn.Logger.Error("job store error", logging.Fields{"error": err})
+		return
+	}
+	for _, job := range jobs {
+		if job.FinishedAt == nil || now.Sub(*job.FinishedAt) < rn.retention {
+			continue
+		}
+		if err := rn.store.Delete(job.ID); err != nil && err != ErrNotFound {
+			rn.Logger.Error("job store error", logging.Fields{"job_id": job.ID, "error": err})
+		}
+	}
+}
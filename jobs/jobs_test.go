@@ -0,0 +1,294 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	logging "takehome/logging"
+	m "takehome/matrix"
+)
+
+// waitFor polls the job of id until it is in status.
+func waitFor(t *testing.T, rn *Runner, id string, status Status) *Job {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		job, err := rn.Get(id)
+		if err == nil && job.Status == status {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	job, err := rn.Get(id)
+	t.Fatalf("got %+v, %v want %v", job, err, status)
+	return nil
+}
+
+// failingStore fails to save jobs once they started.
+type failingStore struct {
+	*MemoryStore
+}
+
+func (fs failingStore) Save(job *Job) error {
+	if job.Status != StatusQueued {
+		return errors.New("Disk full.")
+	}
+	return fs.MemoryStore.Save(job)
+}
+
+func TestRunner(t *testing.T) {
+	type key struct{}
+
+	t.Run("results", func(t *testing.T) {
+		rn, err := NewRunner(NewMemoryStore(), 2, 4, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rn.Shutdown(context.Background())
+
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+		succeeded := &Job{Operation: "/sum"}
+		err = rn.Submit(ctx, succeeded, func(ctx context.Context) *Result {
+			m.ReportProgress(ctx, m.Progress{Rows: 2, RowsDone: 1})
+			return &Result{Status: http.StatusOK, Body: []byte(ctx.Value(key{}).(string))}
+		})
+		// The job outlives the request submitting it.
+		cancel()
+		if err != nil || succeeded.ID == "" || succeeded.Status != StatusQueued {
+			t.Fatalf("got %+v, %v want a queued job", succeeded, err)
+		}
+		failed := &Job{Operation: "/sum"}
+		rn.Submit(ctx, failed, func(ctx context.Context) *Result {
+			return &Result{Status: http.StatusUnprocessableEntity}
+		})
+
+		job := waitFor(t, rn, succeeded.ID, StatusSucceeded)
+		if job.Progress != 100 || string(job.Result.Body) != "value" || job.StartedAt == nil || job.FinishedAt == nil {
+			t.Errorf("got %+v want the result", job)
+		}
+		job = waitFor(t, rn, failed.ID, StatusFailed)
+		if job.Result.Status != http.StatusUnprocessableEntity {
+			t.Errorf("got %+v want the failed result", job.Result)
+		}
+		if _, err := rn.Cancel(failed.ID); err != ErrFinished {
+			t.Errorf("got %v want %v", err, ErrFinished)
+		}
+		if _, err := rn.Get("unknown"); err != ErrNotFound {
+			t.Errorf("got %v want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		rn, _ := NewRunner(NewMemoryStore(), 1, 1, 0)
+		defer rn.Shutdown(context.Background())
+		finished := make(chan *Job, 3)
+		rn.OnFinish = func(job *Job) { finished <- job }
+
+		started := make(chan struct{})
+		running := &Job{}
+		rn.Submit(context.Background(), running, func(ctx context.Context) *Result {
+			close(started)
+			m.ReportProgress(ctx, m.Progress{Rows: 4, RowsDone: 1})
+			<-ctx.Done()
+			return &Result{Status: http.StatusOK}
+		})
+		<-started
+		queued := &Job{}
+		if err := rn.Submit(context.Background(), queued, func(ctx context.Context) *Result {
+			t.Error("got a canceled job run")
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rn.Submit(context.Background(), &Job{}, nil); err != ErrQueueFull {
+			t.Errorf("got %v want %v", err, ErrQueueFull)
+		}
+		if job, _ := rn.Get(running.ID); job.Status != StatusRunning || job.Progress != 25 {
+			t.Errorf("got %v %v want a running job at 25%%", job.Status, job.Progress)
+		}
+
+		for _, id := range []string{queued.ID, running.ID} {
+			job, err := rn.Cancel(id)
+			if err != nil || job.Status != StatusCanceled {
+				t.Errorf("got %+v, %v want a canceled job", job, err)
+			}
+		}
+		// The canceled job frees its worker, and its result is dropped.
+		next := &Job{}
+		if err := rn.Submit(context.Background(), next, func(ctx context.Context) *Result {
+			return &Result{Status: http.StatusOK}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, rn, next.ID, StatusSucceeded)
+		if job, _ := rn.Get(running.ID); job.Status != StatusCanceled || job.Result != nil {
+			t.Errorf("got %+v want a canceled job without result", job)
+		}
+		for _, want := range []*Job{queued, running, next} {
+			if job := <-finished; job.ID != want.ID || !job.Status.Finished() {
+				t.Errorf("got %+v finished want %v", job, want.ID)
+			}
+		}
+	})
+
+	t.Run("per client", func(t *testing.T) {
+		rn, _ := NewRunner(NewMemoryStore(), 1, 4, 0)
+		defer rn.Shutdown(context.Background())
+		rn.MaxPerClient = 1
+		release := make(chan struct{})
+		block := func(ctx context.Context) *Result {
+			<-release
+			return &Result{Status: http.StatusOK}
+		}
+
+		first := &Job{Client: "key:a"}
+		if err := rn.Submit(context.Background(), first, block); err != nil {
+			t.Fatal(err)
+		}
+		if err := rn.Submit(context.Background(), &Job{Client: "key:a"}, block); err != ErrClientLimit {
+			t.Errorf("got %v want %v", err, ErrClientLimit)
+		}
+		other := &Job{Client: "key:b"}
+		if err := rn.Submit(context.Background(), other, block); err != nil {
+			t.Errorf("got %v want other clients queued", err)
+		}
+		close(release)
+		waitFor(t, rn, first.ID, StatusSucceeded)
+		waitFor(t, rn, other.ID, StatusSucceeded)
+		if err := rn.Submit(context.Background(), &Job{Client: "key:a"}, block); err != nil {
+			t.Errorf("got %v want a job once the first finished", err)
+		}
+	})
+
+	t.Run("store errors", func(t *testing.T) {
+		rn, _ := NewRunner(failingStore{NewMemoryStore()}, 1, 1, 0)
+		defer rn.Shutdown(context.Background())
+		var logs bytes.Buffer
+		rn.Logger = logging.New(&logs, logging.Error, logging.JSON)
+
+		release := make(chan struct{})
+		job := &Job{}
+		rn.Submit(context.Background(), job, func(ctx context.Context) *Result {
+			<-release
+			return &Result{Status: http.StatusOK}
+		})
+		// The job goes on although its status could not be saved, and
+		// finishes before the runner shuts down.
+		waitFor(t, rn, job.ID, StatusRunning)
+		close(release)
+		rn.Shutdown(context.Background())
+		for _, status := range []Status{StatusRunning, StatusSucceeded} {
+			want := `"msg":"job store error","error":"Disk full.","job_id":"` + job.ID + `","status":"` + string(status) + `"`
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("got log %q want %q", logs.String(), want)
+			}
+		}
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		rn, _ := NewRunner(NewMemoryStore(), 1, 1, 0)
+		defer rn.Shutdown(context.Background())
+
+		step := make(chan struct{})
+		watched := &Job{}
+		rn.Submit(context.Background(), watched, func(ctx context.Context) *Result {
+			for i := 1; i <= 2; i++ {
+				<-step
+				m.ReportProgress(ctx, m.Progress{Rows: 2, RowsDone: i})
+			}
+			return &Result{Status: http.StatusOK}
+		})
+		job, changes, stop, err := rn.Watch(watched.ID)
+		defer stop()
+		if err != nil || job.ID != watched.ID {
+			t.Fatalf("got %+v, %v want the job", job, err)
+		}
+		if job.Status == StatusQueued {
+			job = <-changes
+		}
+		for _, want := range []float64{50, 100} {
+			step <- struct{}{}
+			if job = <-changes; job.Status != StatusRunning || job.Progress != want || job.RowsDone != int(want/50) || job.Rows != 2 {
+				t.Errorf("got %+v want %v%% done", job, want)
+			}
+		}
+		if job = <-changes; job.Status != StatusSucceeded {
+			t.Errorf("got %+v want the succeeded job", job)
+		}
+		if _, ok := <-changes; ok {
+			t.Errorf("got changes open want closed")
+		}
+
+		// Finished jobs have no changes.
+		if job, changes, _, err := rn.Watch(watched.ID); err != nil || job.Status != StatusSucceeded || changes != nil {
+			t.Errorf("got %+v, %v, %v want the finished job", job, changes, err)
+		}
+		if _, _, _, err := rn.Watch("unknown"); err != ErrNotFound {
+			t.Errorf("got %v want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		jobs := NewMemoryStore()
+		now := time.Unix(1700000000, 0)
+		jobs.Save(&Job{ID: "0123456789abcdef0123456789abcdef", Status: StatusRunning})
+		finished := now.Add(-2 * time.Hour)
+		jobs.Save(&Job{ID: "fedcba9876543210fedcba9876543210", Status: StatusSucceeded, FinishedAt: &finished})
+
+		rn, err := NewRunner(jobs, 1, 1, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rn.Shutdown(context.Background())
+		job, _ := rn.Get("0123456789abcdef0123456789abcdef")
+		if job.Status != StatusFailed || job.Error != ErrorInterrupted {
+			t.Errorf("got %+v want an interrupted job", job)
+		}
+
+		// Jobs finished for longer than the retention are pruned.
+		rn.now = func() time.Time { return now }
+		rn.prune()
+		if _, err := rn.Get("fedcba9876543210fedcba9876543210"); err != ErrNotFound {
+			t.Errorf("got %v want the old job pruned", err)
+		}
+		if _, err := rn.Get("0123456789abcdef0123456789abcdef"); err != nil {
+			t.Errorf("got %v want the recent job kept", err)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		jobs := NewMemoryStore()
+		rn, _ := NewRunner(jobs, 1, 2, 0)
+		release := make(chan struct{})
+		running, queued := &Job{}, &Job{}
+		rn.Submit(context.Background(), running, func(ctx context.Context) *Result {
+			<-release
+			return &Result{Status: http.StatusOK}
+		})
+		waitFor(t, rn, running.ID, StatusRunning)
+		rn.Submit(context.Background(), queued, func(ctx context.Context) *Result {
+			t.Error("got a job run after shutdown")
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := rn.Shutdown(ctx); err != context.DeadlineExceeded {
+			t.Errorf("got %v want %v", err, context.DeadlineExceeded)
+		}
+		close(release)
+		if err := rn.Shutdown(context.Background()); err != nil {
+			t.Errorf("got %v want the running job finished", err)
+		}
+		if job, _ := jobs.Get(queued.ID); job.Status != StatusQueued {
+			t.Errorf("got %v want the job left queued", job.Status)
+		}
+		if err := rn.Submit(context.Background(), &Job{}, nil); err != ErrQueueFull {
+			t.Errorf("got %v want %v", err, ErrQueueFull)
+		}
+	})
+}
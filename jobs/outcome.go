@@ -0,0 +1,46 @@
+package jobs
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+)
+
+// Outcome is the JSON representation of a finished job sent to clients,
+// with the response of its operation.
+type Outcome struct {
+	Job    *Job      `json:"job"`
+	Result *Response `json:"result,omitempty"`
+}
+
+// Response is the JSON representation of a Result, e.g. a problem document
+// when the job failed. The body is embedded as is when it is JSON, or as a
+// string.
+type Response struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// NewOutcome returns the outcome of the finished job.
+func NewOutcome(job *Job) *Outcome {
+	outcome := &Outcome{Job: job}
+	if job.Result == nil {
+		return outcome
+	}
+	contentType := job.Result.Header.Get("Content-Type")
+	outcome.Result = &Response{Status: job.Result.Status, ContentType: contentType}
+	switch {
+	case len(job.Result.Body) == 0:
+	case isJSON(contentType) && json.Valid(job.Result.Body):
+		outcome.Result.Body = job.Result.Body
+	default:
+		outcome.Result.Body, _ = json.Marshal(string(job.Result.Body))
+	}
+	return outcome
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
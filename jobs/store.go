@@ -0,0 +1,192 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	store "takehome/store"
+)
+
+// ErrNotFound is returned for IDs of no job.
+var ErrNotFound = errors.New("Job not found.")
+
+// Store keeps jobs and their results by ID. Implementations are safe for
+// concurrent use.
+type Store interface {
+	// Save stores job and its result, replacing any job with the same ID.
+	Save(job *Job) error
+	// Get returns the job of id with its result, or ErrNotFound.
+	Get(id string) (*Job, error)
+	// List returns every job, without their results.
+	List() ([]*Job, error)
+	// Delete removes the job of id, or returns ErrNotFound.
+	Delete(id string) error
+}
+
+// MemoryStore keeps jobs in memory, until the process exits.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[string]*Job{}}
+}
+
+func (ms *MemoryStore) Save(job *Job) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.jobs[job.ID] = job.clone()
+	return nil
+}
+
+func (ms *MemoryStore) Get(id string) (*Job, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	job, ok := ms.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return job.clone(), nil
+}
+
+func (ms *MemoryStore) List() ([]*Job, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	jobs := make([]*Job, 0, len(ms.jobs))
+	for _, job := range ms.jobs {
+		listed := job.clone()
+		listed.Result = nil
+		jobs = append(jobs, listed)
+	}
+	return jobs, nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.jobs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(ms.jobs, id)
+	return nil
+}
+
+// FileStore keeps each job as a JSON file of a directory, so jobs and their
+// results survive restarts.
+type FileStore struct {
+	dir string
+}
+
+// record is the stored form of a job, whose result is not part of its JSON
+// representation.
+type record struct {
+	Job    *Job    `json:"job"`
+	Result *Result `json:"result,omitempty"`
+}
+
+// NewFileStore returns a store of the jobs of dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// Remove the files of saves interrupted by a crash.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, info.Name()))
+		}
+	}
+	return &FileStore{dir}, nil
+}
+
+func (fs *FileStore) path(id string) string {
+	return filepath.Join(fs.dir, id+".json")
+}
+
+func (fs *FileStore) Save(job *Job) error {
+	data, err := json.Marshal(record{job, job.Result})
+	if err != nil {
+		return err
+	}
+	path := fs.path(job.ID)
+	// Write then rename, so readers never see a partial job.
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return nil
+}
+
+func (fs *FileStore) Get(id string) (*Job, error) {
+	if !store.ValidID(id) {
+		return nil, ErrNotFound
+	}
+	return fs.read(fs.path(id))
+}
+
+func (fs *FileStore) read(path string) (*Job, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored record
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Job == nil {
+		return nil, errors.New("Job file has no job.")
+	}
+	stored.Job.Result = stored.Result
+	return stored.Job, nil
+}
+
+func (fs *FileStore) List() ([]*Job, error) {
+	infos, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, info := range infos {
+		if filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+		job, err := fs.read(filepath.Join(fs.dir, info.Name()))
+		if err == ErrNotFound {
+			// Deleted since the directory was read.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		job.Result = nil
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (fs *FileStore) Delete(id string) error {
+	if !store.ValidID(id) {
+		return ErrNotFound
+	}
+	err := os.Remove(fs.path(id))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStores(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "0123456789abcdef0123456789abcdef.json.tmp"), []byte("{"), 0600)
+	fileStore, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := ioutil.ReadDir(dir); len(infos) != 0 {
+		t.Errorf("got %v files want the temporary file removed", len(infos))
+	}
+
+	stores := map[string]Store{"memory": NewMemoryStore(), "file": fileStore}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			finished := time.Unix(1700000060, 0).UTC()
+			job := &Job{
+				ID:         "0123456789abcdef0123456789abcdef",
+				Operation:  "/sum",
+				Owner:      "key:ci",
+				Status:     StatusSucceeded,
+				Progress:   100,
+				CreatedAt:  time.Unix(1700000000, 0).UTC(),
+				FinishedAt: &finished,
+				Result:     &Result{Status: http.StatusOK, Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("45")},
+			}
+			if err := s.Save(job); err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.Get(job.ID)
+			if err != nil || !reflect.DeepEqual(got, job) {
+				t.Errorf("got %+v, %v want %+v", got, err, job)
+			}
+			jobs, err := s.List()
+			if err != nil || len(jobs) != 1 || jobs[0].Result != nil || jobs[0].Status != StatusSucceeded {
+				t.Errorf("got %v, %v want the job without its result", jobs, err)
+			}
+
+			if err := s.Delete(job.ID); err != nil {
+				t.Fatal(err)
+			}
+			for _, missing := range []string{job.ID, "../jobs"} {
+				if _, err := s.Get(missing); err != ErrNotFound {
+					t.Errorf("%v: got %v want %v", missing, err, ErrNotFound)
+				}
+				if err := s.Delete(missing); err != ErrNotFound {
+					t.Errorf("%v: got %v want %v", missing, err, ErrNotFound)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name, e.g. "info".
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("Unknown log level '%s'.", name)
+}
+
+// Format is the encoding of log lines.
+type Format string
+
+const (
+	// JSON writes one JSON object per line.
+	JSON Format = "json"
+	// Text writes "time level msg key=value ..." lines.
+	Text Format = "text"
+)
+
+// ParseFormat parses a format name, e.g. "json".
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case JSON, Text:
+		return format, nil
+	}
+	return JSON, fmt.Errorf("Unknown log format '%s'.", name)
+}
+
+// Fields are the structured values of a log line.
+type Fields map[string]interface{}
+
+// Logger writes structured lines at or above its level. It is safe for
+// concurrent use.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	format Format
+	now    func() time.Time
+}
+
+func New(out io.Writer, level Level, format Format) *Logger {
+	return &Logger{out: out, level: level, format: format, now: time.Now}
+}
+
+// Discard is a logger writing nothing.
+var Discard = New(ioutil.Discard, Error+1, JSON)
+
+// Enabled reports whether lines of level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+// Log writes msg with fields if level is enabled. Fields are written in key
+// order after time, level and msg.
+func (l *Logger) Log(level Level, msg string, fields Fields) {
+	if !l.Enabled(level) {
+		return
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var line bytes.Buffer
+	now := l.now().UTC().Format(time.RFC3339Nano)
+	if l.format == Text {
+		fmt.Fprintf(&line, "%s %s %s", now, level, msg)
+		for _, key := range keys {
+			fmt.Fprintf(&line, " %s=%s", key, textValue(fields[key]))
+		}
+	} else {
+		fmt.Fprintf(&line, `{"time":%q,"level":%q,"msg":%s`, now, level, jsonValue(msg))
+		for _, key := range keys {
+			fmt.Fprintf(&line, ",%s:%s", jsonValue(key), jsonValue(fields[key]))
+		}
+		line.WriteByte('}')
+	}
+	line.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line.Bytes())
+}
+
+func (l *Logger) Debug(msg string, fields Fields) { l.Log(Debug, msg, fields) }
+func (l *Logger) Info(msg string, fields Fields)  { l.Log(Info, msg, fields) }
+func (l *Logger) Warn(msg string, fields Fields)  { l.Log(Warn, msg, fields) }
+func (l *Logger) Error(msg string, fields Fields) { l.Log(Error, msg, fields) }
+
+// StdLogger returns a *log.Logger writing each message as a line of level,
+// e.g. for http.Server.ErrorLog.
+func (l *Logger) StdLogger(level Level) *log.Logger {
+	return log.New(writerFunc(func(p []byte) (int, error) {
+		l.Log(level, strings.TrimSuffix(string(p), "\n"), nil)
+		return len(p), nil
+	}), "", 0)
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (fn writerFunc) Write(p []byte) (int, error) {
+	return fn(p)
+}
+
+func jsonValue(value interface{}) string {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return string(encoded)
+}
+
+func textValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if text == "" || strings.ContainsAny(text, " \t\n\"=") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+	annotationsKey
+)
+
+// WithLogger returns a copy of ctx carrying logger.
+func WithLogger(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger carried by ctx, or Discard.
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return logger
+	}
+	return Discard
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// annotations collects the fields added to the access log line of a request
+// by the middlewares and handlers serving it.
+type annotations struct {
+	mu     sync.Mutex
+	fields Fields
+}
+
+// WithAnnotations returns a copy of ctx collecting annotations.
+func WithAnnotations(ctx context.Context) context.Context {
+	return context.WithValue(ctx, annotationsKey, &annotations{fields: Fields{}})
+}
+
+// Annotate adds a field to the access log line of the request of ctx. It does
+// nothing when ctx does not collect annotations.
+func Annotate(ctx context.Context, key string, value interface{}) {
+	a, ok := ctx.Value(annotationsKey).(*annotations)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fields[key] = value
+}
+
+// Annotations returns a copy of the fields annotated on ctx.
+func Annotations(ctx context.Context) Fields {
+	fields := Fields{}
+	a, ok := ctx.Value(annotationsKey).(*annotations)
+	if !ok {
+		return fields
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, value := range a.fields {
+		fields[key] = value
+	}
+	return fields
+}
@@ -0,0 +1,94 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	var out bytes.Buffer
+	newLogger := func(level Level, format Format) *Logger {
+		out.Reset()
+		logger := New(&out, level, format)
+		logger.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+		return logger
+	}
+
+	t.Run("json", func(t *testing.T) {
+		logger := newLogger(Info, JSON)
+		logger.Info("request", Fields{"status": 200, "path": "/sum", "error": errors.New("failed")})
+
+		var line map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]interface{}{
+			"time": "2024-01-02T03:04:05Z", "level": "info", "msg": "request",
+			"status": 200.0, "path": "/sum", "error": "failed",
+		}
+		for key, value := range want {
+			if line[key] != value {
+				t.Errorf("got %v=%v want %v", key, line[key], value)
+			}
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		logger := newLogger(Info, Text)
+		logger.Warn("request", Fields{"status": 404, "panic": "index out of range"})
+
+		want := "2024-01-02T03:04:05Z warn request panic=\"index out of range\" status=404\n"
+		if out.String() != want {
+			t.Errorf("got %q want %q", out.String(), want)
+		}
+	})
+
+	t.Run("level", func(t *testing.T) {
+		logger := newLogger(Warn, Text)
+		logger.Info("skipped", nil)
+		if out.Len() != 0 {
+			t.Errorf("got %q want nothing", out.String())
+		}
+		logger.StdLogger(Error).Printf("accept: %s", "too many open files")
+		if !strings.Contains(out.String(), "error accept: too many open files") {
+			t.Errorf("got %q want the message logged as error", out.String())
+		}
+	})
+}
+
+func TestParse(t *testing.T) {
+	if level, err := ParseLevel("WARN"); err != nil || level != Warn {
+		t.Errorf("got %v, %v want %v", level, err, Warn)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("got no error want unknown level")
+	}
+	if format, err := ParseFormat("text"); err != nil || format != Text {
+		t.Errorf("got %v, %v want %v", format, err, Text)
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("got no error want unknown format")
+	}
+}
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	if FromContext(ctx) != Discard || RequestID(ctx) != "" {
+		t.Errorf("got logger or request ID from an empty context")
+	}
+	Annotate(ctx, "ignored", true)
+
+	ctx = WithAnnotations(WithRequestID(ctx, "request-42"))
+	Annotate(ctx, "operation", "/sum")
+	if got := RequestID(ctx); got != "request-42" {
+		t.Errorf("got %v want %v", got, "request-42")
+	}
+	if got := Annotations(ctx); len(got) != 1 || got["operation"] != "/sum" {
+		t.Errorf("got %v want map[operation:/sum]", got)
+	}
+}
@@ -1,11 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/rsa"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	auth "takehome/auth"
+	cache "takehome/cache"
 	handlers "takehome/handlers"
+	jobs "takehome/jobs"
+	logging "takehome/logging"
 	middlewares "takehome/middlewares"
+	ratelimit "takehome/ratelimit"
+	router "takehome/router"
+	store "takehome/store"
+	tracing "takehome/tracing"
+	webhooks "takehome/webhooks"
 
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/kelseyhightower/envconfig"
@@ -13,8 +29,140 @@ import (
 
 type Config struct {
 	Port uint16 `envconfig:"PORT" required:"true"`
+
+	// Upload limits, see middlewares.Limits. The default cell length fits
+	// any 64-bit integer with its sign.
+	MaxBodyBytes  int64 `envconfig:"MAX_BODY_BYTES" default:"33554432"`
+	MaxRows       int   `envconfig:"MAX_ROWS" default:"5000"`
+	MaxCols       int   `envconfig:"MAX_COLS" default:"5000"`
+	MaxCells      int   `envconfig:"MAX_CELLS" default:"1000000"`
+	MaxCellLength int   `envconfig:"MAX_CELL_LENGTH" default:"20"`
+
+	// Server timeouts, see http.Server. ShutdownTimeout bounds the time
+	// given to in-flight requests, then to jobs, webhooks and traces, after
+	// ShutdownDelay; keep their sum below the termination grace period of
+	// the orchestrator, e.g. 30s on Kubernetes, leaving time to exit.
+	ReadHeaderTimeout time.Duration `envconfig:"READ_HEADER_TIMEOUT" default:"5s"`
+	ReadTimeout       time.Duration `envconfig:"READ_TIMEOUT" default:"30s"`
+	WriteTimeout      time.Duration `envconfig:"WRITE_TIMEOUT" default:"60s"`
+	IdleTimeout       time.Duration `envconfig:"IDLE_TIMEOUT" default:"120s"`
+	ShutdownTimeout   time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"20s"`
+	// ShutdownDelay keeps serving with /readyz failing after SIGTERM, so
+	// load balancers stop routing requests before the listener closes.
+	ShutdownDelay time.Duration `envconfig:"SHUTDOWN_DELAY" default:"5s"`
+
+	// Log lines at or above LogLevel (debug, info, warn or error) are
+	// written to stderr, in LogFormat (json or text).
+	LogLevel  string `envconfig:"LOG_LEVEL" default:"info"`
+	LogFormat string `envconfig:"LOG_FORMAT" default:"json"`
+
+	// Completed spans are exported to TraceExporter: none, stdout, or otlp
+	// posting to TraceOTLPEndpoint as TraceServiceName.
+	TraceExporter     string `envconfig:"TRACE_EXPORTER" default:"none"`
+	TraceOTLPEndpoint string `envconfig:"TRACE_OTLP_ENDPOINT" default:"http://localhost:4318/v1/traces"`
+	TraceServiceName  string `envconfig:"TRACE_SERVICE_NAME" default:"takehome"`
+
+	// APIKeysFile lists the API keys allowed to call matrix operations,
+	// checked for changes every APIKeysReloadInterval. Without it the
+	// operations are open to everyone.
+	APIKeysFile           string        `envconfig:"API_KEYS_FILE"`
+	APIKeysReloadInterval time.Duration `envconfig:"API_KEYS_RELOAD_INTERVAL" default:"10s"`
+
+	// JWTs are accepted as Bearer tokens once JWTSecret, for HS256, or
+	// JWTJWKSFile, for RS256, is set. JWTIssuer and JWTAudience, when set,
+	// must match the iss and aud claims, exp and nbf being checked with
+	// JWTLeeway.
+	JWTSecret   string        `envconfig:"JWT_HS256_SECRET"`
+	JWTJWKSFile string        `envconfig:"JWT_JWKS_FILE"`
+	JWTIssuer   string        `envconfig:"JWT_ISSUER"`
+	JWTAudience string        `envconfig:"JWT_AUDIENCE"`
+	JWTLeeway   time.Duration `envconfig:"JWT_LEEWAY" default:"30s"`
+
+	// Matrix operations of each client are limited to RateLimitRate tokens
+	// per second, up to RateLimitBurst, and RateLimitConcurrency requests in
+	// flight, a zero value disabling the limit. An operation costs its weight
+	// for every started group of RateLimitCellsPerToken cells. Clients may
+	// get other limits in RateLimitOverridesFile, checked for changes every
+	// RateLimitReloadInterval.
+	RateLimitRate           float64       `envconfig:"RATE_LIMIT_RATE" default:"0"`
+	RateLimitBurst          float64       `envconfig:"RATE_LIMIT_BURST" default:"0"`
+	RateLimitConcurrency    int           `envconfig:"RATE_LIMIT_CONCURRENCY" default:"0"`
+	RateLimitCellsPerToken  int           `envconfig:"RATE_LIMIT_CELLS_PER_TOKEN" default:"100000"`
+	RateLimitOverridesFile  string        `envconfig:"RATE_LIMIT_OVERRIDES_FILE"`
+	RateLimitReloadInterval time.Duration `envconfig:"RATE_LIMIT_RELOAD_INTERVAL" default:"10s"`
+
+	// Browsers may call the API from CORSAllowedOrigins, see
+	// middlewares.CORSOptions. Without origins CORS is disabled.
+	CORSAllowedOrigins   []string      `envconfig:"CORS_ALLOWED_ORIGINS"`
+	CORSAllowedMethods   []string      `envconfig:"CORS_ALLOWED_METHODS" default:"GET,HEAD,POST,PUT,PATCH,DELETE"`
+	CORSAllowedHeaders   []string      `envconfig:"CORS_ALLOWED_HEADERS" default:"Authorization,Content-Type,X-API-Key,X-Request-ID"`
+	CORSExposedHeaders   []string      `envconfig:"CORS_EXPOSED_HEADERS" default:"X-Request-ID,Server-Timing,X-Matrix-Rows,X-Matrix-Cols,X-Matrix-Cells,RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,RateLimit-Policy,Retry-After,ETag,X-Cache,Location,Content-Location"`
+	CORSAllowCredentials bool          `envconfig:"CORS_ALLOW_CREDENTIALS" default:"false"`
+	CORSMaxAge           time.Duration `envconfig:"CORS_MAX_AGE" default:"10m"`
+
+	// Responses of at least CompressionMinBytes are compressed when the
+	// client accepts gzip or deflate, unless Compression is off.
+	Compression         bool `envconfig:"COMPRESSION" default:"true"`
+	CompressionMinBytes int  `envconfig:"COMPRESSION_MIN_BYTES" default:"1024"`
+
+	// Results of matrix operations are cached in memory for CacheTTL, up to
+	// CacheMaxEntries and CacheMaxBytes, entries evicted from memory being
+	// spilled to CacheDir, if set, up to CacheMaxDiskBytes. A zero
+	// CacheMaxEntries disables the cache.
+	CacheMaxEntries   int           `envconfig:"CACHE_MAX_ENTRIES" default:"1024"`
+	CacheMaxBytes     int64         `envconfig:"CACHE_MAX_BYTES" default:"67108864"`
+	CacheTTL          time.Duration `envconfig:"CACHE_TTL" default:"10m"`
+	CacheDir          string        `envconfig:"CACHE_DIR"`
+	CacheMaxDiskBytes int64         `envconfig:"CACHE_MAX_DISK_BYTES" default:"1073741824"`
+
+	// Matrices uploaded to /matrices are kept in memory, or as files of
+	// MatrixStoreDir when it is set.
+	MatrixStoreDir string `envconfig:"MATRIX_STORE_DIR"`
+
+	// Operations asked for with ?async=true run as jobs on JobsWorkers
+	// workers, up to JobsQueueSize jobs waiting for one, and up to
+	// JobsMaxPerClient jobs queued or running for each client. Jobs are kept
+	// in memory, or as files of JobsDir when it is set, for JobsRetention
+	// once finished.
+	JobsWorkers      int           `envconfig:"JOBS_WORKERS" default:"4"`
+	JobsQueueSize    int           `envconfig:"JOBS_QUEUE_SIZE" default:"64"`
+	JobsMaxPerClient int           `envconfig:"JOBS_MAX_PER_CLIENT" default:"16"`
+	JobsDir          string        `envconfig:"JOBS_DIR"`
+	JobsRetention    time.Duration `envconfig:"JOBS_RETENTION" default:"24h"`
+
+	// Jobs submitted with a callback_url notify it once finished, signing
+	// with WebhookSecret, when it is set. Each notification is attempted up
+	// to WebhookMaxAttempts times, waiting WebhookBackoff after the first
+	// failed attempt and twice as long after each next one. Callbacks may not
+	// reach loopback, private or link-local addresses, except those of
+	// WebhookAllowedNetworks, e.g. 10.1.0.0/16.
+	WebhookSecret          string        `envconfig:"WEBHOOK_SECRET"`
+	WebhookMaxAttempts     int           `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"5"`
+	WebhookBackoff         time.Duration `envconfig:"WEBHOOK_BACKOFF" default:"1s"`
+	WebhookTimeout         time.Duration `envconfig:"WEBHOOK_TIMEOUT" default:"10s"`
+	WebhookAllowedNetworks []string      `envconfig:"WEBHOOK_ALLOWED_NETWORKS"`
+
+	// Batches hold up to BatchMaxItems matrices, whose operations run on
+	// BatchParallelism goroutines.
+	BatchMaxItems    int `envconfig:"BATCH_MAX_ITEMS" default:"10000"`
+	BatchParallelism int `envconfig:"BATCH_PARALLELISM" default:"8"`
 }
 
+// Scopes granted to API keys: reading matrices back, computing on them, or
+// storing and deleting them.
+const (
+	ScopeRead    = "matrix:read"
+	ScopeCompute = "matrix:compute"
+	ScopeWrite   = "matrix:write"
+)
+
+// Rate limit weights of the operations, computing costing more than reading
+// matrices back.
+const (
+	WeightRead    = 1
+	WeightCompute = 2
+)
+
 // Run with
 //		go run .
 // Send request with:
@@ -27,13 +175,396 @@ func main() {
 		panic(err.Error())
 	}
 
-	router := http.NewServeMux()
+	level, err := logging.ParseLevel(c.LogLevel)
+	if err != nil {
+		panic(err.Error())
+	}
+	format, err := logging.ParseFormat(c.LogFormat)
+	if err != nil {
+		panic(err.Error())
+	}
+	logger := logging.New(os.Stderr, level, format)
+	exporter, err := newTraceExporter(c)
+	if err != nil {
+		panic(err.Error())
+	}
+	tracer := tracing.NewTracer(exporter, func(err error) {
+		logger.Warn("trace export", logging.Fields{"error": err})
+	})
+	readiness := &handlers.Readiness{}
+
+	var keys *auth.Keys
+	if c.APIKeysFile != "" {
+		keys, err = auth.LoadKeys(c.APIKeysFile)
+		if err != nil {
+			panic(err.Error())
+		}
+		go keys.Watch(context.Background(), c.APIKeysReloadInterval, func(err error) {
+			if err != nil {
+				logger.Error("API keys reload", logging.Fields{"error": err})
+				return
+			}
+			logger.Info("API keys reloaded", logging.Fields{"file": c.APIKeysFile})
+		})
+	}
+	verifier, err := newVerifier(c)
+	if err != nil {
+		panic(err.Error())
+	}
+	if keys == nil && verifier == nil {
+		logger.Warn("API keys and JWTs not configured, matrix operations are open to everyone", nil)
+	}
+	limiter, overrides, err := newLimiter(c)
+	if err != nil {
+		panic(err.Error())
+	}
+	if overrides != nil {
+		go overrides.Watch(context.Background(), c.RateLimitReloadInterval, func(err error) {
+			if err != nil {
+				logger.Error("rate limit overrides reload", logging.Fields{"error": err})
+				return
+			}
+			logger.Info("rate limit overrides reloaded", logging.Fields{"file": c.RateLimitOverridesFile})
+		})
+	}
+
+	results, err := newCache(c)
+	if err != nil {
+		panic(err.Error())
+	}
+	matrices, err := newStore(c)
+	if err != nil {
+		panic(err.Error())
+	}
+	runner, err := newRunner(c, logger)
+	if err != nil {
+		panic(err.Error())
+	}
+	notifier, err := newNotifier(c, logger)
+	if err != nil {
+		panic(err.Error())
+	}
+	if notifier != nil {
+		runner.OnFinish = notifier.Notify
+	}
+
+	// Every request is logged and traced, including those whose handler
+	// panicked. CORS preflight requests are answered before routing, and
+	// responses are compressed last.
+	var handler http.Handler = newRouter(c, services{readiness, keys, verifier, limiter, results, matrices, runner, notifier})
+	if c.Compression {
+		handler = middlewares.NewCompressionMiddleware(handler, c.CompressionMinBytes)
+	}
+	if len(c.CORSAllowedOrigins) > 0 {
+		options := middlewares.CORSOptions{
+			AllowedOrigins:   c.CORSAllowedOrigins,
+			AllowedMethods:   c.CORSAllowedMethods,
+			AllowedHeaders:   c.CORSAllowedHeaders,
+			ExposedHeaders:   c.CORSExposedHeaders,
+			AllowCredentials: c.CORSAllowCredentials,
+			MaxAge:           c.CORSMaxAge,
+		}
+		if err := options.Validate(); err != nil {
+			panic(err.Error())
+		}
+		handler = middlewares.NewCORSMiddleware(handler, options)
+	}
+	handler = middlewares.NewRecoveryMiddleware(handler, logger)
+	handler = middlewares.NewTracingMiddleware(handler, tracer)
+	handler = middlewares.NewLoggingMiddleware(handler, logger)
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		ErrorLog:          logger.StdLogger(logging.Error),
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", c.Port))
+	if err != nil {
+		logger.Error("listen", logging.Fields{"error": err})
+		os.Exit(1)
+	}
+	logger.Info("listening", logging.Fields{"addr": listener.Addr().String()})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	policy := shutdownPolicy{
+		Delay:     c.ShutdownDelay,
+		Grace:     c.ShutdownTimeout,
+		Readiness: readiness,
+		Flush: func(ctx context.Context) {
+			if err := runner.Shutdown(ctx); err != nil {
+				logger.Warn("jobs shutdown", logging.Fields{"error": err})
+			}
+			if notifier != nil {
+				if err := notifier.Shutdown(ctx); err != nil {
+					logger.Warn("webhooks shutdown", logging.Fields{"error": err})
+				}
+			}
+			if err := tracer.Shutdown(ctx); err != nil {
+				logger.Warn("trace export", logging.Fields{"error": err})
+			}
+		},
+	}
+	if err := serve(ctx, server, listener, policy, logger); err != nil {
+		logger.Error("serve", logging.Fields{"error": err})
+		os.Exit(1)
+	}
+}
+
+// newVerifier returns the JWT verifier configured by JWT_HS256_SECRET and
+// JWT_JWKS_FILE, nil when neither is set.
+func newVerifier(c Config) (*auth.Verifier, error) {
+	if c.JWTSecret == "" && c.JWTJWKSFile == "" {
+		return nil, nil
+	}
+	var keys map[string]*rsa.PublicKey
+	if c.JWTJWKSFile != "" {
+		var err error
+		if keys, err = auth.LoadJWKS(c.JWTJWKSFile); err != nil {
+			return nil, err
+		}
+	}
+	verifier := auth.NewVerifier([]byte(c.JWTSecret), keys)
+	verifier.Issuer = c.JWTIssuer
+	verifier.Audience = c.JWTAudience
+	verifier.Leeway = c.JWTLeeway
+	return verifier, nil
+}
+
+// newLimiter returns the rate limiter configured by the RATE_LIMIT_*
+// variables and its overrides, nil when no limit is set.
+func newLimiter(c Config) (*ratelimit.Limiter, *ratelimit.Overrides, error) {
+	defaults := ratelimit.Limits{
+		Rate:        c.RateLimitRate,
+		Burst:       c.RateLimitBurst,
+		Concurrency: c.RateLimitConcurrency,
+	}
+	if c.RateLimitOverridesFile == "" {
+		if defaults.Rate <= 0 && defaults.Concurrency <= 0 {
+			return nil, nil, nil
+		}
+		return ratelimit.NewLimiter(defaults, nil), nil, nil
+	}
+	overrides, err := ratelimit.LoadOverrides(c.RateLimitOverridesFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ratelimit.NewLimiter(defaults, overrides), overrides, nil
+}
+
+// newCache returns the results cache configured by the CACHE_* variables,
+// nil when it is disabled.
+func newCache(c Config) (*cache.Cache, error) {
+	if c.CacheMaxEntries <= 0 {
+		return nil, nil
+	}
+	return cache.New(cache.Options{
+		MaxEntries:   c.CacheMaxEntries,
+		MaxBytes:     c.CacheMaxBytes,
+		TTL:          c.CacheTTL,
+		Dir:          c.CacheDir,
+		MaxDiskBytes: c.CacheMaxDiskBytes,
+	})
+}
+
+// newStore returns the matrix store configured by MATRIX_STORE_DIR.
+func newStore(c Config) (store.Store, error) {
+	if c.MatrixStoreDir == "" {
+		return store.NewMemoryStore(), nil
+	}
+	return store.NewFileStore(c.MatrixStoreDir)
+}
+
+// newRunner returns the job runner configured by the JOBS_* variables,
+// logging the errors of its store with logger.
+func newRunner(c Config, logger *logging.Logger) (*jobs.Runner, error) {
+	var jobStore jobs.Store = jobs.NewMemoryStore()
+	if c.JobsDir != "" {
+		fileStore, err := jobs.NewFileStore(c.JobsDir)
+		if err != nil {
+			return nil, err
+		}
+		jobStore = fileStore
+	}
+	runner, err := jobs.NewRunner(jobStore, c.JobsWorkers, c.JobsQueueSize, c.JobsRetention)
+	if err != nil {
+		return nil, err
+	}
+	runner.MaxPerClient = c.JobsMaxPerClient
+	runner.Logger = logger
+	return runner, nil
+}
+
+// newNotifier returns the notifier of job callbacks configured by the
+// WEBHOOK_* variables, nil when WEBHOOK_SECRET is not set.
+func newNotifier(c Config, logger *logging.Logger) (*webhooks.Notifier, error) {
+	if c.WebhookSecret == "" {
+		return nil, nil
+	}
+	allowed, err := webhooks.ParseNetworks(c.WebhookAllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	client := webhooks.NewClient(c.WebhookTimeout, allowed)
+	return webhooks.NewNotifier([]byte(c.WebhookSecret), client, c.WebhookMaxAttempts, c.WebhookBackoff, logger), nil
+}
+
+// newTraceExporter returns the span exporter configured by TRACE_EXPORTER,
+// nil for none.
+func newTraceExporter(c Config) (tracing.Exporter, error) {
+	switch c.TraceExporter {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return tracing.NewJSONExporter(os.Stdout), nil
+	case "otlp":
+		client := &http.Client{Timeout: 10 * time.Second}
+		return tracing.NewOTLPExporter(c.TraceOTLPEndpoint, c.TraceServiceName, client), nil
+	}
+	return nil, fmt.Errorf("Unknown trace exporter '%s'.", c.TraceExporter)
+}
+
+// services are the dependencies of the routes, a nil one disabling its
+// feature.
+type services struct {
+	readiness *handlers.Readiness
+	keys      *auth.Keys
+	verifier  *auth.Verifier
+	limiter   *ratelimit.Limiter
+	results   *cache.Cache
+	matrices  store.Store
+	jobs      *jobs.Runner
+	webhooks  *webhooks.Notifier
+}
+
+// newRouter routes every endpoint through the middlewares it requires, so
+// only matrix operations parse an upload, once their method and API key are
+// checked. Matrix operations accept API keys when keys are given and JWTs
+// when verifier is, and are open to everyone without either. They are rate
+// limited by limiter, if any, before their upload is parsed, which charges
+// the rest of their cost, then served from the results cache, if any. The
+// same operations are served on the matrices of the matrices store, if any,
+// at /matrices/{id}/sum and so on, and on many matrices at once at /batch.
+// Every operation runs as a job of the jobs runner, if any, with
+// ?async=true, the job being rate limited as the request submitting it and
+// each client having a bounded number of jobs queued or running, and
+// notifies its callback_url when the webhooks notifier is given. Every
+// response of the router is counted in the request metrics of its route.
+func newRouter(c Config, s services) http.Handler {
+	keys, verifier, limiter := s.keys, s.verifier, s.limiter
+	limits := middlewares.Limits{
+		MaxBodyBytes:  c.MaxBodyBytes,
+		MaxRows:       c.MaxRows,
+		MaxCols:       c.MaxCols,
+		MaxCells:      c.MaxCells,
+		MaxCellLength: c.MaxCellLength,
+	}
+	decodeMatrix := func(next http.Handler) http.Handler {
+		return middlewares.NewFileToMatrixMiddleware(next, limits)
+	}
+
+	requireScope := func(scope string) router.Middleware {
+		return func(next http.Handler) http.Handler {
+			switch {
+			case keys == nil && verifier == nil:
+				return next
+			case verifier == nil:
+				return middlewares.NewAuthMiddleware(next, keys, scope)
+			case keys == nil:
+				return middlewares.NewJWTMiddleware(next, verifier, scope)
+			}
+			apiKeys := middlewares.NewAuthMiddleware(next, keys, scope)
+			tokens := middlewares.NewJWTMiddleware(next, verifier, scope)
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if middlewares.BearerJWT(r) {
+					tokens.ServeHTTP(w, r)
+					return
+				}
+				apiKeys.ServeHTTP(w, r)
+			})
+		}
+	}
+	read, compute, write := requireScope(ScopeRead), requireScope(ScopeCompute), requireScope(ScopeWrite)
+
+	limit := func(weight float64) router.Middleware {
+		return func(next http.Handler) http.Handler {
+			if limiter == nil {
+				return next
+			}
+			return middlewares.NewRateLimitMiddleware(next, limiter, weight, c.RateLimitCellsPerToken)
+		}
+	}
+	limitRead, limitCompute := limit(WeightRead), limit(WeightCompute)
+
+	async := func(next http.Handler) http.Handler {
+		if s.jobs == nil {
+			return next
+		}
+		return middlewares.NewAsyncMiddleware(next, s.jobs, s.webhooks != nil)
+	}
+	cached := func(next http.Handler) http.Handler {
+		if s.results == nil {
+			return next
+		}
+		return middlewares.NewCacheMiddleware(next, s.results)
+	}
+
+	rt := router.New()
+
+	rt.Handle(http.MethodPost, "/echo", handlers.RootHandler(handlers.Echo), read, limitRead, decodeMatrix, async, cached)
+	rt.Handle(http.MethodPost, "/invert", handlers.RootHandler(handlers.Invert), read, limitRead, decodeMatrix, async, cached)
+	rt.Handle(http.MethodPost, "/multiply", handlers.RootHandler(handlers.Multiply), compute, limitCompute, decodeMatrix, async, cached)
+	rt.Handle(http.MethodPost, "/flatten", handlers.RootHandler(handlers.Flatten), read, limitRead, decodeMatrix, async, cached)
+	rt.Handle(http.MethodPost, "/sum", handlers.RootHandler(handlers.Sum), compute, limitCompute, decodeMatrix, async, cached)
+
+	// A batch may run any operation, so it requires the compute scope.
+	decodeBatch := func(next http.Handler) http.Handler {
+		return middlewares.NewBatchMiddleware(next, limits, c.BatchMaxItems)
+	}
+	rt.Handle(http.MethodPost, "/batch", handlers.RootHandler(handlers.NewBatch(c.BatchParallelism).Serve), compute, limitCompute, decodeBatch)
+
+	if s.matrices != nil {
+		stored := func(next http.Handler) http.Handler {
+			return middlewares.NewStoredMatrixMiddleware(next, s.matrices)
+		}
+		matrices := handlers.NewMatrices(s.matrices, c.MaxBodyBytes)
+		rt.Handle(http.MethodPost, "/matrices", handlers.RootHandler(matrices.Create), write, limitRead, decodeMatrix)
+		rt.Handle(http.MethodGet, "/matrices/{id}", handlers.RootHandler(matrices.Get), read, stored, limitRead)
+		rt.Handle(http.MethodPut, "/matrices/{id}", handlers.RootHandler(matrices.Replace), write, limitRead, stored, decodeMatrix)
+		rt.Handle(http.MethodPatch, "/matrices/{id}", handlers.RootHandler(matrices.Update), write, stored, limitRead)
+		rt.Handle(http.MethodDelete, "/matrices/{id}", handlers.RootHandler(matrices.Delete), write, stored)
+		rt.Handle(http.MethodGet, "/matrices/{id}/versions", handlers.RootHandler(matrices.Versions), read, stored)
+		rt.Handle(http.MethodGet, "/matrices/{id}/versions/{version}", handlers.RootHandler(matrices.GetVersion), read, stored, limitRead)
+		rt.Handle(http.MethodGet, "/matrices/{id}/diff", handlers.RootHandler(matrices.Diff), read, stored, limitRead)
+
+		rt.Handle(http.MethodPost, "/matrices/{id}/echo", handlers.RootHandler(handlers.Echo), read, stored, limitRead, async, cached)
+		rt.Handle(http.MethodPost, "/matrices/{id}/invert", handlers.RootHandler(handlers.Invert), read, stored, limitRead, async, cached)
+		rt.Handle(http.MethodPost, "/matrices/{id}/multiply", handlers.RootHandler(handlers.Multiply), compute, stored, limitCompute, async, cached)
+		rt.Handle(http.MethodPost, "/matrices/{id}/flatten", handlers.RootHandler(handlers.Flatten), read, stored, limitRead, async, cached)
+		rt.Handle(http.MethodPost, "/matrices/{id}/sum", handlers.RootHandler(handlers.Sum), compute, stored, limitCompute, async, cached)
+	}
+
+	if s.jobs != nil {
+		// Jobs are served to the clients which submitted them, whatever the
+		// scope of their operation.
+		authenticated := requireScope("")
+		submitted := handlers.NewJobs(s.jobs)
+		rt.Handle(http.MethodGet, "/jobs/{id}", handlers.RootHandler(submitted.Get), authenticated)
+		rt.Handle(http.MethodGet, "/jobs/{id}/result", handlers.RootHandler(submitted.Result), authenticated)
+		rt.Handle(http.MethodGet, "/jobs/{id}/events", handlers.RootHandler(submitted.Events), authenticated)
+		rt.Handle(http.MethodDelete, "/jobs/{id}", handlers.RootHandler(submitted.Cancel), authenticated)
+	}
 
-	router.Handle("/echo", handlers.RootHandler(handlers.Echo))
-	router.Handle("/invert", handlers.RootHandler(handlers.Invert))
-	router.Handle("/multiply", handlers.RootHandler(handlers.Multiply))
-	router.Handle("/flatten", handlers.RootHandler(handlers.Flatten))
-	router.Handle("/sum", handlers.RootHandler(handlers.Sum))
+	rt.Handle(http.MethodGet, "/errors", handlers.RootHandler(handlers.ErrorCatalog))
+	rt.Handle(http.MethodGet, "/healthz", handlers.RootHandler(handlers.Healthz))
+	rt.Handle(http.MethodGet, "/readyz", handlers.RootHandler(s.readiness.Readyz))
+	rt.Handle(http.MethodGet, "/version", handlers.RootHandler(handlers.Version))
+	rt.Handle(http.MethodGet, "/metrics", handlers.RootHandler(handlers.Metrics))
 
-	http.ListenAndServe(fmt.Sprintf(":%d", c.Port), middlewares.NewFileToMatrixMiddleware(middlewares.NewPOSTMethodOnlyMiddleware(router)))
+	return middlewares.NewMetricsMiddleware(rt, rt.Route)
 }
@@ -1,9 +1,352 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	auth "takehome/auth"
+	handlers "takehome/handlers"
+	logging "takehome/logging"
+	webhooks "takehome/webhooks"
 )
 
 func TestMain(t *testing.T) {
+	// Listen on a random port, main exits the test binary on errors.
+	os.Setenv("PORT", "0")
 	go main()
 }
+
+func TestRouter(t *testing.T) {
+	readiness := &handlers.Readiness{}
+	readiness.SetReady(true)
+	router := newRouter(Config{}, services{readiness: readiness})
+
+	tests := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{"GET", "/healthz", http.StatusOK},
+		{"GET", "/readyz", http.StatusOK},
+		{"GET", "/version", http.StatusOK},
+		{"GET", "/errors", http.StatusOK},
+		{"GET", "/metrics", http.StatusOK},
+		{"GET", "/echo", http.StatusMethodNotAllowed},
+		{"POST", "/echo", http.StatusBadRequest},
+		{"POST", "/healthz", http.StatusMethodNotAllowed},
+		{"POST", "/unknown", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(test.method, test.target, nil))
+
+			if w.Code != test.want {
+				t.Errorf("got %v want %v", w.Code, test.want)
+			}
+		})
+	}
+}
+
+func TestNewTraceExporter(t *testing.T) {
+	for _, name := range []string{"none", "stdout", "otlp"} {
+		if _, err := newTraceExporter(Config{TraceExporter: name}); err != nil {
+			t.Errorf("%v: got %v want no error", name, err)
+		}
+	}
+	if _, err := newTraceExporter(Config{TraceExporter: "zipkin"}); err == nil {
+		t.Errorf("got no error want unknown exporter")
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	if limiter, _, err := newLimiter(Config{}); limiter != nil || err != nil {
+		t.Errorf("got %v, %v want no limiter", limiter, err)
+	}
+	if limiter, _, err := newLimiter(Config{RateLimitConcurrency: 4}); limiter == nil || err != nil {
+		t.Errorf("got %v, %v want a limiter", limiter, err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	if _, _, err := newLimiter(Config{RateLimitOverridesFile: missing}); err == nil {
+		t.Errorf("got no error want a missing overrides file")
+	}
+}
+
+func TestRouterAuth(t *testing.T) {
+	// The key "read-secret" may only read matrices.
+	path := filepath.Join(t.TempDir(), "keys.json")
+	digest := sha256.Sum256([]byte("read-secret"))
+	keyFile := `{"keys": [{"id": "reader", "sha256": "` + hex.EncodeToString(digest[:]) + `", "scopes": ["` + ScopeRead + `"]}]}`
+	if err := ioutil.WriteFile(path, []byte(keyFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tokens signed with "jwt-secret" are accepted as well.
+	verifier, err := newVerifier(Config{JWTSecret: "jwt-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(Config{}, services{readiness: &handlers.Readiness{}, keys: keys, verifier: verifier})
+
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","exp":4102444800,"scope":"`+ScopeCompute+`"}`))
+	mac := hmac.New(sha256.New, []byte("jwt-secret"))
+	mac.Write([]byte(signed))
+	token := signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		method string
+		target string
+		key    string
+		want   int
+	}{
+		{"POST", "/echo", "", http.StatusUnauthorized},
+		{"POST", "/echo", "wrong-secret", http.StatusUnauthorized},
+		{"POST", "/echo", "read-secret", http.StatusBadRequest},
+		{"POST", "/sum", "read-secret", http.StatusForbidden},
+		{"POST", "/sum", token, http.StatusBadRequest},
+		{"POST", "/echo", token, http.StatusForbidden},
+		{"POST", "/sum", token + "x", http.StatusUnauthorized},
+		{"GET", "/healthz", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.target, nil)
+			if test.key != "" {
+				r.Header.Set("Authorization", "Bearer "+test.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != test.want {
+				t.Errorf("got %v want %v", w.Code, test.want)
+			}
+		})
+	}
+}
+
+// loadKeys returns API keys of ids granting every scope, the secret of each
+// being its ID followed by "-secret".
+func loadKeys(t *testing.T, ids ...string) *auth.Keys {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	var entries []string
+	for _, id := range ids {
+		digest := sha256.Sum256([]byte(id + "-secret"))
+		entries = append(entries, `{"id": "`+id+`", "sha256": "`+hex.EncodeToString(digest[:])+`", "scopes": ["*"]}`)
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"keys": [`+strings.Join(entries, ",")+`]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// upload returns a multipart request of target uploading csv.
+func upload(target, csv string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "matrix.csv")
+	io.WriteString(part, csv)
+	writer.Close()
+	r := httptest.NewRequest("POST", target, body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestRouterMatrices(t *testing.T) {
+	keys := loadKeys(t, "owner", "other")
+	matrices, err := newStore(Config{MatrixStoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(Config{}, services{readiness: &handlers.Readiness{}, keys: keys, matrices: matrices})
+
+	serve := func(r *http.Request, key string) *httptest.ResponseRecorder {
+		r.Header.Set("Authorization", "Bearer "+key+"-secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(upload("/matrices", "1,2\n3,4\n"), "owner")
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusCreated || !strings.HasPrefix(location, "/matrices/") {
+		t.Fatalf("got %v %v want %v", w.Code, w.Body.String(), http.StatusCreated)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		key    string
+		want   int
+		body   string
+	}{
+		{"POST", location + "/sum", "owner", http.StatusOK, "10"},
+		{"POST", location + "/invert", "owner", http.StatusOK, "1,3\n2,4\n"},
+		{"GET", location, "owner", http.StatusOK, ""},
+		{"PATCH", location, "other", http.StatusNotFound, ""},
+		{"PATCH", location, "owner", http.StatusOK, ""},
+		{"POST", location + "/sum", "owner", http.StatusOK, "15"},
+		{"GET", location + "/versions", "owner", http.StatusOK, ""},
+		{"GET", location + "/diff", "owner", http.StatusOK, ""},
+		{"GET", location + "/sum", "owner", http.StatusMethodNotAllowed, ""},
+		{"GET", location, "other", http.StatusNotFound, ""},
+		{"POST", location + "/sum", "other", http.StatusNotFound, ""},
+		{"DELETE", location, "other", http.StatusNotFound, ""},
+		{"DELETE", location, "owner", http.StatusNoContent, ""},
+		{"GET", location, "owner", http.StatusNotFound, ""},
+		{"DELETE", location, "owner", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		// PATCH requests set the first cell to 6.
+		patch := strings.NewReader(`{"set": [{"row": 1, "col": 1, "value": 6}]}`)
+		w := serve(httptest.NewRequest(test.method, test.target, patch), test.key)
+		if w.Code != test.want || (test.body != "" && w.Body.String() != test.body) {
+			t.Errorf("%v %v as %v: got %v %q want %v %q", test.method, test.target, test.key, w.Code, w.Body.String(), test.want, test.body)
+		}
+	}
+}
+
+func TestRouterBatch(t *testing.T) {
+	router := newRouter(Config{BatchMaxItems: 2, BatchParallelism: 2}, services{readiness: &handlers.Readiness{}})
+	serve := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/batch?ops=sum", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(`[{"id": "a", "matrix": [[1, 2], [3, 4]]}, {"id": "b", "matrix": [[1, "x"], [3, 4]], "ops": ["sum", "echo"]}]`)
+	want := `{"items":[{"index":0,"id":"a","results":[{"op":"sum","status":200,"result":10}]},{"index":1,"id":"b","error":`
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), want) {
+		t.Errorf("got %v %v want %v", w.Code, w.Body.String(), want)
+	}
+	if w := serve(`[{"matrix": [[1]]}, {"matrix": [[1]]}, {"matrix": [[1]]}]`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("got %v want %v", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestRouterJobs(t *testing.T) {
+	keys := loadKeys(t, "owner", "other")
+	runner, err := newRunner(Config{JobsWorkers: 1, JobsQueueSize: 1, JobsDir: t.TempDir()}, logging.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Shutdown(context.Background())
+	router := newRouter(Config{}, services{readiness: &handlers.Readiness{}, keys: keys, jobs: runner})
+
+	serve := func(r *http.Request, key string) *httptest.ResponseRecorder {
+		r.Header.Set("Authorization", "Bearer "+key+"-secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(upload("/sum?async=true", "1,2\n3,4\n"), "owner")
+	location := w.Header().Get("Location")
+	if w.Code != http.StatusAccepted || !strings.HasPrefix(location, "/jobs/") {
+		t.Fatalf("got %v %v want %v", w.Code, w.Body.String(), http.StatusAccepted)
+	}
+	for i := 0; i < 200 && !strings.Contains(w.Body.String(), `"status":"succeeded"`); i++ {
+		time.Sleep(5 * time.Millisecond)
+		w = serve(httptest.NewRequest("GET", location, nil), "owner")
+	}
+
+	tests := []struct {
+		method string
+		target string
+		key    string
+		want   int
+		body   string
+	}{
+		{"GET", location + "/result", "owner", http.StatusOK, "10"},
+		{"GET", location + "/events", "owner", http.StatusOK, ""},
+		{"GET", location + "/events", "other", http.StatusNotFound, ""},
+		{"GET", location, "other", http.StatusNotFound, ""},
+		{"GET", location + "/result", "other", http.StatusNotFound, ""},
+		{"DELETE", location, "owner", http.StatusConflict, ""},
+		{"GET", "/jobs/unknown", "owner", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := serve(httptest.NewRequest(test.method, test.target, nil), test.key)
+		if w.Code != test.want || (test.body != "" && w.Body.String() != test.body) {
+			t.Errorf("%v %v as %v: got %v %q want %v %q", test.method, test.target, test.key, w.Code, w.Body.String(), test.want, test.body)
+		}
+	}
+	if w := serve(httptest.NewRequest("GET", location, nil), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("got %v want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRouterWebhooks(t *testing.T) {
+	events := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !webhooks.Verify([]byte("webhook-secret"), r.Header.Get(webhooks.TimestampHeader), body, r.Header.Get(webhooks.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		events <- body
+	}))
+	defer receiver.Close()
+
+	// The receiver listens on a loopback address, which must be allowed.
+	c := Config{JobsWorkers: 1, JobsQueueSize: 1, WebhookSecret: "webhook-secret", WebhookMaxAttempts: 1,
+		WebhookAllowedNetworks: []string{"127.0.0.1"}}
+	runner, err := newRunner(c, logging.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Shutdown(context.Background())
+	notifier, err := newNotifier(c, logging.New(ioutil.Discard, logging.Error, logging.JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.OnFinish = notifier.Notify
+	router := newRouter(c, services{readiness: &handlers.Readiness{}, jobs: runner, webhooks: notifier})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, upload("/sum?async=true&callback_url="+receiver.URL, "1,2\n3,4\n"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got %v %v want %v", w.Code, w.Body.String(), http.StatusAccepted)
+	}
+	select {
+	case body := <-events:
+		if !strings.Contains(string(body), `"status":"succeeded"`) || !strings.Contains(string(body), `"body":"10"`) {
+			t.Errorf("got %s want the result", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no notification")
+	}
+	notifier.Shutdown(context.Background())
+
+	// Without a secret, callbacks are rejected.
+	router = newRouter(Config{}, services{readiness: &handlers.Readiness{}, jobs: runner})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, upload("/sum?async=true&callback_url="+receiver.URL, "1,2\n3,4\n"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %v want %v", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,57 @@
+package matrix
+
+// Operations of cell changes.
+const (
+	CellChanged = "changed"
+	CellAdded   = "added"
+	CellRemoved = "removed"
+)
+
+// CellChange is a cell differing between two matrices. Row and Col are
+// 1-based, From is empty for added cells and To for removed ones.
+type CellChange struct {
+	Row  int    `json:"row"`
+	Col  int    `json:"col"`
+	Op   string `json:"op"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Diff returns the cells differing from m to other, row after row. Cells
+// only one of the matrices has, when their shapes differ, are added or
+// removed.
+func (m Matrix) Diff(other Matrix) []CellChange {
+	changes := []CellChange{}
+	rows := len(m.Data)
+	if len(other.Data) > rows {
+		rows = len(other.Data)
+	}
+	for i := 0; i < rows; i++ {
+		var from, to []string
+		if i < len(m.Data) {
+			from = m.Data[i]
+		}
+		if i < len(other.Data) {
+			to = other.Data[i]
+		}
+		cols := len(from)
+		if len(to) > cols {
+			cols = len(to)
+		}
+		for j := 0; j < cols; j++ {
+			change := CellChange{Row: i + 1, Col: j + 1}
+			switch {
+			case j >= len(from):
+				change.Op, change.To = CellAdded, to[j]
+			case j >= len(to):
+				change.Op, change.From = CellRemoved, from[j]
+			case from[j] != to[j]:
+				change.Op, change.From, change.To = CellChanged, from[j], to[j]
+			default:
+				continue
+			}
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
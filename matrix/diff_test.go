@@ -0,0 +1,41 @@
+package matrix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+
+	t.Run("same matrix", func(t *testing.T) {
+		got := matrix.Diff(*matrix)
+		if len(got) != 0 {
+			t.Errorf("got %v want no change", got)
+		}
+	})
+
+	t.Run("changed cells", func(t *testing.T) {
+		other := Matrix{[][]string{{"1", "2", "3"}, {"4", "0", "6"}, {"7", "8", "-9"}}}
+		got := matrix.Diff(other)
+		want := []CellChange{
+			{Row: 2, Col: 2, Op: CellChanged, From: "5", To: "0"},
+			{Row: 3, Col: 3, Op: CellChanged, From: "9", To: "-9"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("reshaped matrix", func(t *testing.T) {
+		got := rectangularMatrix.Diff(Matrix{[][]string{{"1", "2"}, {"4", "5"}, {"7", "8"}}})
+		want := []CellChange{
+			{Row: 1, Col: 3, Op: CellRemoved, From: "3"},
+			{Row: 2, Col: 3, Op: CellRemoved, From: "6"},
+			{Row: 3, Col: 1, Op: CellAdded, To: "7"},
+			{Row: 3, Col: 2, Op: CellAdded, To: "8"},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+}
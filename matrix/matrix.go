@@ -1,12 +1,29 @@
 package matrix
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
 
+// ErrOverflow is returned when a result does not fit in an int.
+var ErrOverflow = errors.New("Integer overflow.")
+
+// NonNumberError is returned when a cell is not an integer, Row and Col are
+// 1-based.
+type NonNumberError struct {
+	Row   int
+	Col   int
+	Value string
+}
+
+func (e *NonNumberError) Error() string {
+	return "Non number value found."
+}
+
 type Matrix struct {
 	Data [][]string
 }
@@ -28,6 +45,12 @@ func (m Matrix) Invert() string {
 	return result
 }
 
+// Transpose returns the matrix whose rows are the columns of m, as printed
+// by Invert.
+func (m Matrix) Transpose() Matrix {
+	return Matrix{Data: transpose(m.Data)}
+}
+
 func transpose(data [][]string) [][]string {
 	xl := len(data[0])
 	yl := len(data)
@@ -54,28 +77,53 @@ func (m Matrix) Flatten() string {
 }
 
 func (m Matrix) Sum() (int, error) {
+	return m.SumContext(context.Background())
+}
+
+// SumContext is Sum reporting its progress to ctx, which stops it with its
+// error once done.
+func (m Matrix) SumContext(ctx context.Context) (int, error) {
 	result := 0
-	for _, row := range m.Data {
-		for _, val := range row {
+	for i, row := range m.Data {
+		for j, val := range row {
 			number, err := strconv.Atoi(val)
 			if err != nil {
-				return -1, errors.New("Non number value found.")
+				return -1, &NonNumberError{i + 1, j + 1, val}
+			}
+			if (number > 0 && result > math.MaxInt-number) || (number < 0 && result < math.MinInt-number) {
+				return -1, ErrOverflow
 			}
 			result += number
 		}
+		if err := m.rowDone(ctx, i); err != nil {
+			return -1, err
+		}
 	}
 	return result, nil
 }
 
 func (m Matrix) Multiply() (int, error) {
+	return m.MultiplyContext(context.Background())
+}
+
+// MultiplyContext is Multiply reporting its progress to ctx, which stops it
+// with its error once done.
+func (m Matrix) MultiplyContext(ctx context.Context) (int, error) {
 	result := 1
-	for _, row := range m.Data {
-		for _, val := range row {
+	for i, row := range m.Data {
+		for j, val := range row {
 			number, err := strconv.Atoi(val)
 			if err != nil {
-				return -1, errors.New("Non number value found.")
+				return -1, &NonNumberError{i + 1, j + 1, val}
 			}
-			result *= number
+			product := result * number
+			if number != 0 && (product/number != result || (number == -1 && result == math.MinInt)) {
+				return -1, ErrOverflow
+			}
+			result = product
+		}
+		if err := m.rowDone(ctx, i); err != nil {
+			return -1, err
 		}
 	}
 	return result, nil
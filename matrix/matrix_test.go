@@ -134,3 +134,32 @@ func TestMultiply(t *testing.T) {
 		testMultiply(t, matrixError, want, errorWanted)
 	})
 }
+
+func TestOverflow(t *testing.T) {
+	overflowMatrix := &Matrix{
+		[][]string{
+			{"9223372036854775807", "1"},
+			{"-9223372036854775808", "-1"},
+		},
+	}
+
+	t.Run("sum", func(t *testing.T) {
+		if _, err := overflowMatrix.Sum(); err != ErrOverflow {
+			t.Errorf("got %v want %v", err, ErrOverflow)
+		}
+	})
+
+	t.Run("multiply", func(t *testing.T) {
+		if _, err := overflowMatrix.Multiply(); err != ErrOverflow {
+			t.Errorf("got %v want %v", err, ErrOverflow)
+		}
+	})
+
+	t.Run("multiply by zero", func(t *testing.T) {
+		zeroMatrix := &Matrix{[][]string{{"9223372036854775807", "0"}, {"9223372036854775807", "2"}}}
+		got, err := zeroMatrix.Multiply()
+		if err != nil || got != 0 {
+			t.Errorf("got %v, %v want 0", got, err)
+		}
+	})
+}
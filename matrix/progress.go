@@ -0,0 +1,53 @@
+package matrix
+
+import "context"
+
+// Progress is how far an operation went through a matrix, reported after
+// each row.
+type Progress struct {
+	Rows     int `json:"rows"`
+	RowsDone int `json:"rows_done"`
+}
+
+// Percent returns the completed percentage of the operation.
+func (p Progress) Percent() float64 {
+	if p.Rows == 0 {
+		return 100
+	}
+	return float64(p.RowsDone) * 100 / float64(p.Rows)
+}
+
+// ProgressFunc receives the progress of an operation. It is called by the
+// goroutine running the operation, and should return quickly.
+type ProgressFunc func(Progress)
+
+type contextKey int
+
+const progressKey contextKey = 0
+
+// WithProgress returns a copy of ctx whose operations report their progress
+// to fn, then to the functions already subscribed with ctx.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	if parent, ok := ctx.Value(progressKey).(ProgressFunc); ok {
+		subscribed := fn
+		fn = func(p Progress) {
+			subscribed(p)
+			parent(p)
+		}
+	}
+	return context.WithValue(ctx, progressKey, fn)
+}
+
+// ReportProgress reports p to the functions subscribed with ctx, if any.
+func ReportProgress(ctx context.Context, p Progress) {
+	if fn, ok := ctx.Value(progressKey).(ProgressFunc); ok {
+		fn(p)
+	}
+}
+
+// rowDone reports row i of m done, and returns the error of ctx once it is
+// done so the operation stops.
+func (m Matrix) rowDone(ctx context.Context, i int) error {
+	ReportProgress(ctx, Progress{Rows: len(m.Data), RowsDone: i + 1})
+	return ctx.Err()
+}
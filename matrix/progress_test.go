@@ -0,0 +1,49 @@
+package matrix
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	t.Run("subscribers", func(t *testing.T) {
+		var got []string
+		ctx := WithProgress(context.Background(), func(p Progress) { got = append(got, "first") })
+		ctx = WithProgress(ctx, func(p Progress) { got = append(got, "second") })
+		if _, err := matrix.SumContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 6 || got[0] != "second" || got[1] != "first" {
+			t.Errorf("got %v want both subscribers called per row", got)
+		}
+	})
+
+	t.Run("rows", func(t *testing.T) {
+		var got []Progress
+		ctx := WithProgress(context.Background(), func(p Progress) { got = append(got, p) })
+		product, err := matrix.MultiplyContext(ctx)
+		if err != nil || product != 362880 {
+			t.Fatalf("got %v, %v want 362880", product, err)
+		}
+		if len(got) != 3 || got[0] != (Progress{Rows: 3, RowsDone: 1}) || got[2].Percent() != 100 {
+			t.Errorf("got %v want a progress per row", got)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx = WithProgress(ctx, func(p Progress) { cancel() })
+		if _, err := matrix.SumContext(ctx); err != context.Canceled {
+			t.Errorf("got %v want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("percent", func(t *testing.T) {
+		if got := (Progress{Rows: 4, RowsDone: 1}).Percent(); got != 25 {
+			t.Errorf("got %v want 25", got)
+		}
+		if got := (Progress{}).Percent(); got != 100 {
+			t.Errorf("got %v want 100", got)
+		}
+	})
+}
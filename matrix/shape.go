@@ -0,0 +1,110 @@
+package matrix
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Shape holds the dimensions of a matrix.
+type Shape struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+}
+
+// String returns the shape in "<rows>x<cols>" format.
+func (s Shape) String() string {
+	return fmt.Sprintf("%dx%d", s.Rows, s.Cols)
+}
+
+// Cells returns the number of cells of a matrix with this shape.
+func (s Shape) Cells() int {
+	return s.Rows * s.Cols
+}
+
+// ParseShape parses a shape in "<rows>x<cols>" format, e.g. "3x3".
+func ParseShape(value string) (Shape, error) {
+	parts := strings.Split(strings.ToLower(value), "x")
+	if len(parts) != 2 {
+		return Shape{}, fmt.Errorf("Shape '%s' is not in <rows>x<cols> format.", value)
+	}
+	rows, err := strconv.Atoi(parts[0])
+	if err != nil || rows < 0 {
+		return Shape{}, fmt.Errorf("Shape '%s' has an invalid row count.", value)
+	}
+	cols, err := strconv.Atoi(parts[1])
+	if err != nil || cols < 0 {
+		return Shape{}, fmt.Errorf("Shape '%s' has an invalid column count.", value)
+	}
+	return Shape{Rows: rows, Cols: cols}, nil
+}
+
+// Shape returns the dimensions of the matrix. Columns are counted on the
+// first row, the csv reader guarantees every row has the same length.
+func (m Matrix) Shape() Shape {
+	if len(m.Data) == 0 {
+		return Shape{}
+	}
+	return Shape{Rows: len(m.Data), Cols: len(m.Data[0])}
+}
+
+// Constraint is a requirement on the shape of a matrix.
+type Constraint struct {
+	// Name identifies the constraint, e.g. "square".
+	Name string
+	// Expected describes the shapes satisfying the constraint, e.g. "NxN".
+	Expected string
+	check    func(Shape) bool
+}
+
+var (
+	// NonEmpty requires at least one cell.
+	NonEmpty = Constraint{"non-empty", "at least 1x1", func(s Shape) bool {
+		return s.Rows > 0 && s.Cols > 0
+	}}
+	// Square requires as many rows as columns.
+	Square = Constraint{"square", "NxN", func(s Shape) bool {
+		return s.Rows == s.Cols
+	}}
+	// Vector requires a single row or a single column.
+	Vector = Constraint{"vector", "1xN or Nx1", func(s Shape) bool {
+		return s.Rows == 1 || s.Cols == 1
+	}}
+)
+
+// MaxCells requires at most n cells.
+func MaxCells(n int) Constraint {
+	return Constraint{"max-cells", fmt.Sprintf("at most %d cells", n), func(s Shape) bool {
+		return s.Cells() <= n
+	}}
+}
+
+// ExactShape requires the given shape.
+func ExactShape(shape Shape) Constraint {
+	return Constraint{"shape", shape.String(), func(s Shape) bool {
+		return s == shape
+	}}
+}
+
+// ShapeError is returned when a matrix violates a Constraint.
+type ShapeError struct {
+	Constraint string
+	Actual     Shape
+	Expected   string
+}
+
+func (e *ShapeError) Error() string {
+	return fmt.Sprintf("%s constraint violated: got %s, expected %s", e.Constraint, e.Actual, e.Expected)
+}
+
+// Validate checks the matrix against the constraints in order and returns a
+// *ShapeError for the first one it violates.
+func (m Matrix) Validate(constraints ...Constraint) error {
+	shape := m.Shape()
+	for _, c := range constraints {
+		if !c.check(shape) {
+			return &ShapeError{Constraint: c.Name, Actual: shape, Expected: c.Expected}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package matrix
+
+import (
+	"testing"
+)
+
+var rectangularMatrix = &Matrix{
+	[][]string{
+		{"1", "2", "3"},
+		{"4", "5", "6"},
+	},
+}
+
+func TestShape(t *testing.T) {
+
+	testShape := func(t *testing.T, matrix *Matrix, want Shape) {
+		t.Helper()
+		got := matrix.Shape()
+		if got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	}
+
+	t.Run("square matrix", func(t *testing.T) {
+		testShape(t, matrix, Shape{3, 3})
+	})
+
+	t.Run("rectangular matrix", func(t *testing.T) {
+		testShape(t, rectangularMatrix, Shape{2, 3})
+	})
+
+	t.Run("empty matrix", func(t *testing.T) {
+		testShape(t, &Matrix{}, Shape{0, 0})
+	})
+}
+
+func TestParseShape(t *testing.T) {
+
+	t.Run("correct shape", func(t *testing.T) {
+		want := Shape{3, 4}
+		got, err := ParseShape("3x4")
+		if err != nil || got != want {
+			t.Errorf("got %v, %v want %v", got, err, want)
+		}
+	})
+
+	for _, value := range []string{"", "3", "3x", "ax3", "3x-1", "3x3x3"} {
+		t.Run("incorrect shape "+value, func(t *testing.T) {
+			if _, err := ParseShape(value); err == nil {
+				t.Errorf("got nil error for %q", value)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	testValidate := func(t *testing.T, matrix *Matrix, constraints []Constraint, wantConstraint string) {
+		t.Helper()
+		err := matrix.Validate(constraints...)
+		if wantConstraint == "" {
+			if err != nil {
+				t.Errorf("got %v want nil", err)
+			}
+			return
+		}
+		shapeErr, ok := err.(*ShapeError)
+		if !ok {
+			t.Fatalf("got %v want *ShapeError", err)
+		}
+		if shapeErr.Constraint != wantConstraint {
+			t.Errorf("got %v want %v", shapeErr.Constraint, wantConstraint)
+		}
+		if shapeErr.Actual != matrix.Shape() {
+			t.Errorf("got %v want %v", shapeErr.Actual, matrix.Shape())
+		}
+	}
+
+	t.Run("square matrix", func(t *testing.T) {
+		testValidate(t, matrix, []Constraint{NonEmpty, Square, MaxCells(9), ExactShape(Shape{3, 3})}, "")
+	})
+
+	t.Run("empty matrix", func(t *testing.T) {
+		testValidate(t, &Matrix{}, []Constraint{NonEmpty, Square}, "non-empty")
+	})
+
+	t.Run("rectangular matrix", func(t *testing.T) {
+		testValidate(t, rectangularMatrix, []Constraint{NonEmpty, Square}, "square")
+	})
+
+	t.Run("not a vector", func(t *testing.T) {
+		testValidate(t, matrix, []Constraint{Vector}, "vector")
+	})
+
+	t.Run("too many cells", func(t *testing.T) {
+		testValidate(t, matrix, []Constraint{MaxCells(8)}, "max-cells")
+	})
+
+	t.Run("unexpected shape", func(t *testing.T) {
+		testValidate(t, matrix, []Constraint{ExactShape(Shape{2, 2})}, "shape")
+	})
+
+	t.Run("error message", func(t *testing.T) {
+		want := "square constraint violated: got 2x3, expected NxN"
+		got := rectangularMatrix.Validate(Square).Error()
+		if got != want {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are latency buckets in seconds, from 5ms to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets returns count buckets, the first one being start and
+// each next one factor times the previous one.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Registry holds metric families and writes them in the Prometheus text
+// exposition format. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry served by the /metrics endpoint.
+var Default = NewRegistry()
+
+// NewCounterVec registers a counter partitioned by the labels.
+func (rg *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{rg.register(name, help, "counter", labels, nil)}
+}
+
+// NewGaugeVec registers a gauge partitioned by the labels.
+func (rg *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{rg.register(name, help, "gauge", labels, nil)}
+}
+
+// NewHistogramVec registers a histogram with the upper bounds of its
+// buckets, in increasing order, partitioned by the labels.
+func (rg *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{rg.register(name, help, "histogram", labels, buckets)}
+}
+
+func (rg *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	for _, registered := range rg.families {
+		if registered.name == name {
+			panic(fmt.Sprintf("metrics: %s registered twice", name))
+		}
+	}
+	rg.families = append(rg.families, f)
+	return f
+}
+
+// WriteTo writes every metric in the text exposition format, families in
+// name order and series in label order.
+func (rg *Registry) WriteTo(w io.Writer) (int64, error) {
+	rg.mu.Lock()
+	families := append([]*family(nil), rg.families...)
+	rg.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var out strings.Builder
+	for _, f := range families {
+		f.write(&out)
+	}
+	n, err := io.WriteString(w, out.String())
+	return int64(n), err
+}
+
+// family is a metric and its series, one per combination of label values.
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	// value is the counter or gauge value, or the sum of the histogram
+	// observations.
+	value float64
+	// counts are the observations per bucket, the last one being +Inf.
+	counts []uint64
+	count  uint64
+}
+
+// update calls fn with the series of the label values, under lock.
+func (f *family) update(values []string, fn func(s *series)) {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s got %d label values want %d", f.name, len(values), len(f.labels)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets)+1)
+		}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+func (f *family) write(out *strings.Builder) {
+	fmt.Fprintf(out, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(out, "# TYPE %s %s\n", f.name, f.kind)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(out, "%s%s %s\n", f.name, f.labelSet(s.values, ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, count := range s.counts {
+			cumulative += count
+			bound := math.Inf(1)
+			if i < len(f.buckets) {
+				bound = f.buckets[i]
+			}
+			fmt.Fprintf(out, "%s_bucket%s %d\n", f.name, f.labelSet(s.values, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(out, "%s_sum%s %s\n", f.name, f.labelSet(s.values, ""), formatFloat(s.value))
+		fmt.Fprintf(out, "%s_count%s %d\n", f.name, f.labelSet(s.values, ""), s.count)
+	}
+}
+
+// labelSet formats the label values, followed by the le label of histogram
+// buckets when given.
+func (f *family) labelSet(values []string, le string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, value := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", f.labels[i], escapeLabel(value)))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=\"%s\"", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string   { return helpEscaper.Replace(help) }
+func escapeLabel(value string) string { return labelEscaper.Replace(value) }
+
+// CounterVec is a counter per combination of label values.
+type CounterVec struct {
+	family *family
+}
+
+// Inc adds 1 to the counter of the label values.
+func (cv *CounterVec) Inc(values ...string) {
+	cv.Add(1, values...)
+}
+
+// Add adds delta, which must not be negative, to the counter of the label
+// values.
+func (cv *CounterVec) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: %s decreased", cv.family.name))
+	}
+	cv.family.update(values, func(s *series) { s.value += delta })
+}
+
+// GaugeVec is a gauge per combination of label values.
+type GaugeVec struct {
+	family *family
+}
+
+// Add adds delta to the gauge of the label values.
+func (gv *GaugeVec) Add(delta float64, values ...string) {
+	gv.family.update(values, func(s *series) { s.value += delta })
+}
+
+// Set sets the gauge of the label values.
+func (gv *GaugeVec) Set(value float64, values ...string) {
+	gv.family.update(values, func(s *series) { s.value = value })
+}
+
+// HistogramVec is a histogram per combination of label values.
+type HistogramVec struct {
+	family *family
+}
+
+// Observe adds v to the histogram of the label values.
+func (hv *HistogramVec) Observe(v float64, values ...string) {
+	buckets := hv.family.buckets
+	i := sort.SearchFloat64s(buckets, v)
+	hv.family.update(values, func(s *series) {
+		s.counts[i]++
+		s.count++
+		s.value += v
+	})
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Requests served.", "operation", "status")
+	inFlight := registry.NewGaugeVec("in_flight", "Requests being served.")
+	duration := registry.NewHistogramVec("duration_seconds", "Time spent.", []float64{0.1, 1})
+
+	requests.Inc("/sum", "200")
+	requests.Add(2, "/sum", "200")
+	requests.Inc("/echo", "400")
+	requests.Inc(`"quoted"`, "200")
+	inFlight.Add(1)
+	duration.Observe(0.05)
+	duration.Observe(0.1)
+	duration.Observe(3)
+
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP duration_seconds Time spent.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 2
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 3.15
+duration_seconds_count 3
+# HELP in_flight Requests being served.
+# TYPE in_flight gauge
+in_flight 1
+# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{operation="\"quoted\"",status="200"} 1
+requests_total{operation="/echo",status="400"} 1
+requests_total{operation="/sum",status="200"} 3
+`
+	if out.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", out.String(), want)
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("requests_total", "Requests served.", "operation")
+
+	tests := map[string]func(){
+		"registered twice":    func() { registry.NewGaugeVec("requests_total", "Requests served.") },
+		"missing label value": func() { requests.Inc() },
+		"decreased counter":   func() { requests.Add(-1, "/sum") },
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("got no panic want one")
+				}
+			}()
+			test()
+		})
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	got := ExponentialBuckets(1, 10, 3)
+	if len(got) != 3 || got[0] != 1 || got[1] != 10 || got[2] != 100 {
+		t.Errorf("got %v want [1 10 100]", got)
+	}
+}
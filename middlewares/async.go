@@ -0,0 +1,181 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	auth "takehome/auth"
+	e "takehome/errors"
+	jobs "takehome/jobs"
+	logging "takehome/logging"
+	router "takehome/router"
+)
+
+// Query parameters clients use to run an operation as a job, e.g.
+// ?async=true, and to be notified once it finished, e.g.
+// ?async=true&callback_url=https://example.com/hook.
+const (
+	AsyncParam    = "async"
+	CallbackParam = "callback_url"
+)
+
+// LimitJobs is the limit of RATE_LIMITED problems rejecting a job whose
+// client has as many jobs queued or running as it may.
+const LimitJobs = "jobs"
+
+// AsyncMiddleware runs the requests asking for it with ?async=true as jobs
+// of runner, answering 202 with the job status and its URL in the Location
+// header, or 429 when the client, identified as by the rate limiter, has as
+// many jobs queued or running as the runner allows. The job serves the
+// request as it would have been served, with the values of its context,
+// e.g. the uploaded matrix, and keeps the response as its result. Jobs may
+// be given a callback URL, notified once they finished, when callbacks is
+// set.
+//
+// Requests accepting text/event-stream run as jobs as well, unless they ask
+// not to with ?async=false, and their response streams the progress and the
+// outcome of the job as StreamJob does. The job is canceled when the client
+// goes away before it finished.
+type AsyncMiddleware struct {
+	handler   http.Handler
+	runner    *jobs.Runner
+	callbacks bool
+}
+
+func (am *AsyncMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	value, callback := query.Get(AsyncParam), query.Get(CallbackParam)
+	stream := AcceptsEventStream(r)
+	if value == "" && callback == "" && !stream {
+		am.handler.ServeHTTP(w, r)
+		return
+	}
+	async := stream
+	if value != "" {
+		var err error
+		if async, err = strconv.ParseBool(value); err != nil {
+			e.WriteResponse(w, r, e.NewHTTPError(err, e.CodeInvalidParameter, e.Params{"param": AsyncParam, "value": value}))
+			return
+		}
+	}
+	if callback != "" && (!async || !am.callbacks || !validCallbackURL(callback)) {
+		e.WriteResponse(w, r, e.NewHTTPError(nil, e.CodeInvalidParameter, e.Params{"param": CallbackParam, "value": callback}))
+		return
+	}
+	// The parameters are dropped, so results are cached alike.
+	query.Del(AsyncParam)
+	query.Del(CallbackParam)
+	jobRequest := r.Clone(r.Context())
+	jobRequest.URL.RawQuery = query.Encode()
+	jobRequest.RequestURI = jobRequest.URL.RequestURI()
+	if !async {
+		am.handler.ServeHTTP(w, jobRequest)
+		return
+	}
+	jobRequest.Body = http.NoBody
+
+	logger := logging.FromContext(r.Context())
+	// Jobs run past the recovery middleware of the server.
+	handler := NewRecoveryMiddleware(am.handler, logger)
+	job := &jobs.Job{Operation: router.Pattern(r), Owner: auth.Principal(r.Context()), Client: clientID(r), CallbackURL: callback}
+	err := am.runner.Submit(r.Context(), job, func(ctx context.Context) *jobs.Result {
+		start := time.Now()
+		recorder := &resultRecorder{header: http.Header{}}
+		handler.ServeHTTP(recorder, jobRequest.WithContext(ctx))
+		result := recorder.result()
+		logger.Info("job finished", logging.Fields{
+			"job_id":     job.ID,
+			"request_id": logging.RequestID(ctx),
+			"operation":  job.Operation,
+			"status":     result.Status,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+		})
+		return result
+	})
+	if err == jobs.ErrQueueFull {
+		const retryAfter = 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		e.WriteResponse(w, r, e.NewHTTPError(err, e.CodeJobQueueFull, e.Params{"retry_after": retryAfter}).
+			With("retry_after", retryAfter))
+		return
+	}
+	if err == jobs.ErrClientLimit {
+		const retryAfter = 1
+		limit := LimitJobs
+		rateLimited.Inc(limit)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		e.WriteResponse(w, r, e.NewHTTPError(err, e.CodeRateLimited, e.Params{"limit": limit, "retry_after": retryAfter}).
+			With("limit", limit).
+			With("retry_after", retryAfter))
+		return
+	}
+	if err != nil {
+		e.WriteResponse(w, r, err)
+		return
+	}
+	logging.Annotate(r.Context(), "job_id", job.ID)
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	if stream {
+		if err := StreamJob(w, r, am.runner, job.ID); err != nil {
+			e.WriteResponse(w, r, err)
+			return
+		}
+		if r.Context().Err() != nil {
+			am.runner.Cancel(job.ID)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+func NewAsyncMiddleware(handlerToWrap http.Handler, runner *jobs.Runner, callbacks bool) *AsyncMiddleware {
+	return &AsyncMiddleware{handlerToWrap, runner, callbacks}
+}
+
+// validCallbackURL reports whether callback is an absolute HTTP or HTTPS
+// URL.
+func validCallbackURL(callback string) bool {
+	u, err := url.Parse(callback)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// resultRecorder keeps the response of a job.
+type resultRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *resultRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *resultRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+}
+
+func (rr *resultRecorder) Write(p []byte) (int, error) {
+	rr.WriteHeader(http.StatusOK)
+	return rr.body.Write(p)
+}
+
+func (rr *resultRecorder) result() *jobs.Result {
+	status := rr.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	// The content type is sniffed as the server would have.
+	if _, ok := rr.header["Content-Type"]; !ok && rr.body.Len() > 0 {
+		rr.header.Set("Content-Type", http.DetectContentType(rr.body.Bytes()))
+	}
+	return &jobs.Result{Status: status, Header: rr.header, Body: rr.body.Bytes()}
+}
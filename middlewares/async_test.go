@@ -0,0 +1,196 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jobs "takehome/jobs"
+	m "takehome/matrix"
+)
+
+func TestAsyncMiddleware(t *testing.T) {
+	runner, err := jobs.NewRunner(jobs.NewMemoryStore(), 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Shutdown(context.Background())
+
+	release := make(chan struct{})
+	handler := NewAsyncMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("mode") {
+		case "panic":
+			panic("boom")
+		case "wait":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		matrix := r.Context().Value(RequestFileMatrixKey).(*m.Matrix)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.URL.RawQuery + " " + matrix.Data[0][0]))
+	}), runner, true)
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, nil)
+		r = r.WithContext(context.WithValue(r.Context(), RequestFileMatrixKey, &m.Matrix{Data: [][]string{{"7"}}}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	wait := func(t *testing.T, id string) *jobs.Job {
+		t.Helper()
+		for i := 0; i < 200; i++ {
+			if job, err := runner.Get(id); err == nil && job.Status.Finished() {
+				return job
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("got job %v unfinished", id)
+		return nil
+	}
+	submit := func(t *testing.T, target string) *jobs.Job {
+		t.Helper()
+		w := serve(target)
+		var job jobs.Job
+		json.Unmarshal(w.Body.Bytes(), &job)
+		if w.Code != http.StatusAccepted || w.Header().Get("Location") != "/jobs/"+job.ID || job.Status != jobs.StatusQueued {
+			t.Fatalf("got %v %v want %v", w.Code, w.Body.String(), http.StatusAccepted)
+		}
+		return &job
+	}
+
+	t.Run("sync", func(t *testing.T) {
+		for _, target := range []string{"/sum?x=1", "/sum?x=1&async=false"} {
+			if w := serve(target); w.Code != http.StatusOK || w.Body.String() != "x=1 7" {
+				t.Errorf("%v: got %v %v want the result", target, w.Code, w.Body.String())
+			}
+		}
+		for _, target := range []string{
+			"/sum?async=maybe",
+			"/sum?callback_url=http://localhost/hook",
+			"/sum?async=false&callback_url=http://localhost/hook",
+			"/sum?async=true&callback_url=localhost/hook",
+			"/sum?async=true&callback_url=ftp://localhost/hook",
+		} {
+			if w := serve(target); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_PARAMETER") {
+				t.Errorf("%v: got %v %v want an invalid parameter", target, w.Code, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("result", func(t *testing.T) {
+		job := wait(t, submit(t, "/sum?async=true&x=1").ID)
+		if job.Status != jobs.StatusSucceeded || string(job.Result.Body) != "x=1 7" ||
+			job.Result.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("got %+v %+v want the result", job, job.Result)
+		}
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		job := wait(t, submit(t, "/sum?async=true&x=1&callback_url=https://example.com/hook%3Fa%3D1").ID)
+		if job.CallbackURL != "https://example.com/hook?a=1" || string(job.Result.Body) != "x=1 7" {
+			t.Errorf("got %+v %+v want a callback URL", job, job.Result)
+		}
+
+		// Without callbacks, the parameter is rejected.
+		r := httptest.NewRequest("POST", "/sum?async=true&callback_url=https://example.com/hook", nil)
+		w := httptest.NewRecorder()
+		NewAsyncMiddleware(http.NotFoundHandler(), runner, false).ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got %v want %v", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		r := httptest.NewRequest("POST", "/sum?x=1", nil)
+		r.Header.Set("Accept", EventStreamType)
+		r = r.WithContext(context.WithValue(r.Context(), RequestFileMatrixKey, &m.Matrix{Data: [][]string{{"7"}}}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		body := w.Body.String()
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Location"), "/jobs/") ||
+			!strings.Contains(body, "event: result\n") || !strings.Contains(body, `"body":"x=1 7"`) {
+			t.Errorf("got %v %q want the result event", w.Code, body)
+		}
+
+		// A client going away cancels the job.
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), RequestFileMatrixKey, &m.Matrix{Data: [][]string{{"7"}}}))
+		cancel()
+		r = httptest.NewRequest("POST", "/sum?mode=wait", nil).WithContext(ctx)
+		r.Header.Set("Accept", EventStreamType)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if job, _ := runner.Get(strings.TrimPrefix(w.Header().Get("Location"), "/jobs/")); job == nil || job.Status != jobs.StatusCanceled {
+			t.Errorf("got %+v want a canceled job", job)
+		}
+
+		// ?async=false serves the request.
+		r = httptest.NewRequest("POST", "/sum?x=1&async=false", nil)
+		r.Header.Set("Accept", EventStreamType)
+		r = r.WithContext(context.WithValue(r.Context(), RequestFileMatrixKey, &m.Matrix{Data: [][]string{{"7"}}}))
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Body.String() != "x=1 7" {
+			t.Errorf("got %q want the result", w.Body.String())
+		}
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		job := wait(t, submit(t, "/sum?async=true&mode=panic").ID)
+		if job.Status != jobs.StatusFailed || job.Result.Status != http.StatusInternalServerError {
+			t.Errorf("got %+v want a failed job", job)
+		}
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		running := submit(t, "/sum?async=true&mode=wait")
+		for i := 0; i < 200; i++ {
+			if job, _ := runner.Get(running.ID); job.Status == jobs.StatusRunning {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		submit(t, "/sum?async=true")
+		w := serve("/sum?async=true")
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" || !strings.Contains(w.Body.String(), "JOB_QUEUE_FULL") {
+			t.Errorf("got %v %v want %v", w.Code, w.Body.String(), http.StatusServiceUnavailable)
+		}
+		close(release)
+	})
+
+	t.Run("client limit", func(t *testing.T) {
+		limited, _ := jobs.NewRunner(jobs.NewMemoryStore(), 1, 4, 0)
+		defer limited.Shutdown(context.Background())
+		limited.MaxPerClient = 1
+		release := make(chan struct{})
+		defer close(release)
+		handler := NewAsyncMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}), limited, false)
+		submit := func(remoteAddr string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("POST", "/sum?async=true", nil)
+			r.RemoteAddr = remoteAddr
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w
+		}
+
+		if w := submit("192.0.2.1:1234"); w.Code != http.StatusAccepted {
+			t.Fatalf("got %v want %v", w.Code, http.StatusAccepted)
+		}
+		w := submit("192.0.2.1:5678")
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" || !strings.Contains(w.Body.String(), `"limit":"jobs"`) {
+			t.Errorf("got %v %v want %v", w.Code, w.Body.String(), http.StatusTooManyRequests)
+		}
+		if w := submit("192.0.2.2:1234"); w.Code != http.StatusAccepted {
+			t.Errorf("got %v want other clients accepted", w.Code)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+
+	auth "takehome/auth"
+	e "takehome/errors"
+	logging "takehome/logging"
+	tracing "takehome/tracing"
+)
+
+// APIKeyHeader carries an API key, as an alternative to a Bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// AuthMiddleware requires an API key granting scope, sent as a Bearer token
+// or in the X-API-Key header. Requests without a known key get a 401
+// problem, those whose key lacks the scope a 403 problem. The ID of the key
+// is added to the access log. An empty scope only requires a known key.
+type AuthMiddleware struct {
+	handler http.Handler
+	keys    *auth.Keys
+	scope   string
+}
+
+func (am *AuthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := am.keys.Authenticate(apiKey(r))
+	if !ok {
+		unauthorized(w, r, e.NewHTTPError(nil, e.CodeUnauthorized, nil), "")
+		return
+	}
+
+	logging.Annotate(r.Context(), "key_id", key.ID)
+	tracing.SpanFromContext(r.Context()).SetAttribute("auth.key_id", key.ID)
+	if am.scope != "" && !key.Allows(am.scope) {
+		forbidden(w, r, key.ID, am.scope)
+		return
+	}
+
+	am.handler.ServeHTTP(w, r.WithContext(auth.WithKey(r.Context(), key)))
+}
+
+func NewAuthMiddleware(handlerToWrap http.Handler, keys *auth.Keys, scope string) *AuthMiddleware {
+	return &AuthMiddleware{handlerToWrap, keys, scope}
+}
+
+// apiKey returns the key sent by the client, preferring the Authorization
+// header.
+func apiKey(r *http.Request) string {
+	if token, ok := bearerToken(r); ok {
+		return token
+	}
+	return r.Header.Get(APIKeyHeader)
+}
+
+// bearerToken returns the Bearer token of the Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[len(prefix):]), true
+}
+
+// unauthorized answers with a 401 problem challenging the client for a
+// Bearer token, with the RFC 6750 error code when given.
+func unauthorized(w http.ResponseWriter, r *http.Request, problem *e.HTTPError, bearerError string) {
+	challenge := `Bearer realm="takehome"`
+	if bearerError != "" {
+		challenge += `, error="` + bearerError + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	e.WriteResponse(w, r, problem)
+}
+
+// forbidden answers with a 403 problem naming the principal and the scope it
+// lacks.
+func forbidden(w http.ResponseWriter, r *http.Request, principal, scope string) {
+	params := e.Params{"principal": principal, "scope": scope}
+	e.WriteResponse(w, r, e.NewHTTPError(nil, e.CodeForbidden, params).With("scope", scope))
+}
@@ -0,0 +1,101 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	auth "takehome/auth"
+	e "takehome/errors"
+	logging "takehome/logging"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	digest := sha256.Sum256([]byte("reader-secret"))
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keyFile := `{"keys": [{"id": "reader", "sha256": "` + hex.EncodeToString(digest[:]) + `", "scopes": ["matrix:read"]}]}`
+	if err := ioutil.WriteFile(path, []byte(keyFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, logging.Info, logging.Text)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(auth.KeyFromContext(r.Context()).ID))
+	})
+	serve := func(scope string, header, value string) *httptest.ResponseRecorder {
+		logs.Reset()
+		r := httptest.NewRequest("POST", "/echo", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		NewLoggingMiddleware(NewAuthMiddleware(ok, keys, scope), logger).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("missing key", func(t *testing.T) {
+		w := serve("matrix:read", "", "")
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("got %v want %v", w.Code, http.StatusUnauthorized)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="takehome"` {
+			t.Errorf("got %v want a Bearer challenge", got)
+		}
+		if got := w.Header().Get("Content-Type"); got != e.ProblemContentType {
+			t.Errorf("got %v want %v", got, e.ProblemContentType)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		for _, header := range []string{"Authorization", APIKeyHeader} {
+			if w := serve("matrix:read", header, "Bearer unknown"); w.Code != http.StatusUnauthorized {
+				t.Errorf("%v: got %v want %v", header, w.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+
+	t.Run("known key", func(t *testing.T) {
+		for header, value := range map[string]string{"Authorization": "bearer reader-secret", APIKeyHeader: "reader-secret"} {
+			w := serve("matrix:read", header, value)
+			if w.Code != http.StatusOK || w.Body.String() != "reader" {
+				t.Errorf("%v: got %v %v want %v reader", header, w.Code, w.Body.String(), http.StatusOK)
+			}
+			if !strings.Contains(logs.String(), "key_id=reader") || strings.Contains(logs.String(), "reader-secret") {
+				t.Errorf("got log %q want the key ID without the key", logs.String())
+			}
+		}
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		w := serve("matrix:compute", APIKeyHeader, "reader-secret")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("got %v want %v", w.Code, http.StatusForbidden)
+		}
+		var body struct {
+			Code   e.Code `json:"code"`
+			Detail string `json:"detail"`
+			Scope  string `json:"scope"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Code != e.CodeForbidden || body.Scope != "matrix:compute" || !strings.Contains(body.Detail, "reader") {
+			t.Errorf("got %+v want the missing scope of the reader key", body)
+		}
+		if !strings.Contains(logs.String(), "key_id=reader") {
+			t.Errorf("got log %q want the key ID", logs.String())
+		}
+	})
+}
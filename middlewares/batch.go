@@ -0,0 +1,237 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	e "takehome/errors"
+	logging "takehome/logging"
+	m "takehome/matrix"
+)
+
+// OpsParam is the query parameter listing the operations run on the items
+// of a batch which list none, e.g. ?ops=sum,flatten.
+const OpsParam = "ops"
+
+// Reasons of INVALID_BATCH problems.
+const (
+	BatchMalformed     = "malformed"
+	BatchNoItems       = "no_items"
+	BatchInvalidMatrix = "invalid_matrix"
+)
+
+// BatchItem is a matrix of a batch and the operations to run on it. Error
+// tells why the matrix could not be read, Matrix being nil then.
+type BatchItem struct {
+	ID     string
+	Matrix *m.Matrix
+	Ops    []string
+	Error  error
+}
+
+// BatchMiddleware reads a batch of matrices, either the files of a multipart
+// upload, identified by their file names, or a JSON array of
+// {"id", "matrix", "ops"} objects whose matrix is an array of rows. Each
+// matrix is checked against the limits, except MaxBodyBytes which bounds the
+// whole batch, and a matrix failing them fails its item only. Items listing
+// no operations get those of the ops query parameter. The handler gets the
+// items under BatchKey.
+type BatchMiddleware struct {
+	handler  http.Handler
+	limits   Limits
+	maxItems int
+}
+
+func (bm *BatchMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if bm.limits.MaxBodyBytes > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, bm.limits.MaxBodyBytes)
+	}
+	body, err := decodedBody(r, bm.limits.MaxBodyBytes)
+	if err != nil {
+		problem, ok := bodyError(err)
+		if !ok {
+			problem = e.NewHTTPError(err, e.CodeInvalidCompression, nil)
+		}
+		rejectUpload(w, r, problem)
+		return
+	}
+	r.Body = body
+
+	var items []*BatchItem
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		items, err = bm.readFiles(r)
+	case "application/json":
+		items, err = bm.readJSON(r.Body)
+	default:
+		err = invalidBatch(nil, BatchMalformed)
+	}
+	if err == nil && len(items) == 0 {
+		err = invalidBatch(nil, BatchNoItems)
+	}
+	if err != nil {
+		rejectUpload(w, r, err)
+		return
+	}
+
+	var ops []string
+	if param := r.URL.Query().Get(OpsParam); param != "" {
+		ops = strings.Split(param, ",")
+	}
+	for _, item := range items {
+		if len(item.Ops) == 0 {
+			item.Ops = ops
+		}
+		switch {
+		case item.Error != nil:
+			countRejected(item.Error)
+		case len(item.Ops) == 0:
+			item.Error = e.NewHTTPError(nil, e.CodeInvalidParameter, e.Params{"param": OpsParam, "value": ""})
+		default:
+			inputCells.Observe(float64(item.Matrix.Shape().Cells()))
+		}
+	}
+	logging.Annotate(r.Context(), "batch_items", len(items))
+	chargeCells(w, r, batchCells(items))
+
+	bm.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), BatchKey, items)))
+}
+
+// readFiles reads an item from every file of the multipart body. Errors
+// reading the body fail the batch, errors reading a file its item.
+func (bm *BatchMiddleware) readFiles(r *http.Request) ([]*BatchItem, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, invalidBatch(err, BatchMalformed)
+	}
+	var items []*BatchItem
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, batchBodyError(err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		if bm.maxItems > 0 && len(items) == bm.maxItems {
+			return nil, limitError("max_batch_items", bm.maxItems)
+		}
+		item := &BatchItem{ID: part.FileName()}
+		file, err := decodedPart(part, bm.limits.MaxBodyBytes)
+		if err == nil {
+			item.Matrix, err = readMatrix(file, bm.limits)
+		}
+		if _, ok := PayloadTooLargeError(err); ok {
+			return nil, batchBodyError(err)
+		}
+		if problem, ok := bodyError(err); ok {
+			item.Error = problem
+		} else if err != nil {
+			item.Error = e.NewHTTPError(err, e.CodeInvalidCompression, nil)
+		}
+		items = append(items, item)
+	}
+}
+
+// batchItem is an item of a JSON batch.
+type batchItem struct {
+	ID     string          `json:"id"`
+	Matrix json.RawMessage `json:"matrix"`
+	Ops    []string        `json:"ops"`
+}
+
+// readJSON reads the items of a JSON array one at a time. An invalid item
+// fails the batch, an invalid matrix its item.
+func (bm *BatchMiddleware) readJSON(body io.Reader) ([]*BatchItem, error) {
+	decoder := json.NewDecoder(body)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, batchBodyError(err)
+	}
+	var items []*BatchItem
+	for decoder.More() {
+		if bm.maxItems > 0 && len(items) == bm.maxItems {
+			return nil, limitError("max_batch_items", bm.maxItems)
+		}
+		var decoded batchItem
+		if err := decoder.Decode(&decoded); err != nil {
+			return nil, batchBodyError(err)
+		}
+		item := &BatchItem{ID: decoded.ID, Ops: decoded.Ops}
+		item.Matrix, item.Error = jsonMatrix(decoded.Matrix, bm.limits)
+		items = append(items, item)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, batchBodyError(err)
+	}
+	return items, nil
+}
+
+// jsonMatrix reads a JSON array of rows of equal length, whose cells are
+// numbers or strings.
+func jsonMatrix(data json.RawMessage, limits Limits) (*m.Matrix, error) {
+	var rows [][]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rows); err != nil || rows == nil {
+		return nil, invalidBatch(err, BatchInvalidMatrix)
+	}
+	builder := &matrixBuilder{limits: limits}
+	for _, values := range rows {
+		if len(values) != len(rows[0]) {
+			return nil, invalidBatch(nil, BatchInvalidMatrix)
+		}
+		row := make([]string, len(values))
+		for j, value := range values {
+			switch cell := value.(type) {
+			case json.Number:
+				row[j] = cell.String()
+			case string:
+				row[j] = cell
+			default:
+				return nil, invalidBatch(nil, BatchInvalidMatrix)
+			}
+		}
+		if err := builder.add(row); err != nil {
+			return nil, err
+		}
+	}
+	return builder.matrix()
+}
+
+// batchBodyError returns the problem of an error reading the batch, the body
+// exceeding its limit or not being a valid batch.
+func batchBodyError(err error) error {
+	if problem, ok := bodyError(err); ok {
+		return problem
+	}
+	return invalidBatch(err, BatchMalformed)
+}
+
+func invalidBatch(cause error, reason string) error {
+	return e.NewHTTPError(cause, e.CodeInvalidBatch, e.Params{"reason": reason}).With("reason", reason)
+}
+
+// batchCells returns the cells of the items times the operations run on
+// them.
+func batchCells(items []*BatchItem) int {
+	cells := 0
+	for _, item := range items {
+		if item.Matrix != nil {
+			cells += item.Matrix.Shape().Cells() * len(item.Ops)
+		}
+	}
+	return cells
+}
+
+func NewBatchMiddleware(handlerToWrap http.Handler, limits Limits, maxItems int) *BatchMiddleware {
+	return &BatchMiddleware{handlerToWrap, limits, maxItems}
+}
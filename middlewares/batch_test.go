@@ -0,0 +1,126 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	e "takehome/errors"
+)
+
+func TestBatchMiddleware(t *testing.T) {
+	var got []*BatchItem
+	handler := NewBatchMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Context().Value(BatchKey).([]*BatchItem)
+	}), Limits{MaxBodyBytes: 1024, MaxCells: 4}, 3)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		got = nil
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	postJSON := func(target, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		return serve(r)
+	}
+	code := func(err error) e.Code {
+		if problem, ok := err.(*e.HTTPError); ok {
+			return problem.Code
+		}
+		return ""
+	}
+
+	t.Run("json", func(t *testing.T) {
+		w := postJSON("/batch?ops=sum", `[
+			{"id": "a", "matrix": [[1, 2], [3, 4]], "ops": ["invert", "sum"]},
+			{"id": "b", "matrix": [["5", "x"]]},
+			{"matrix": [[1, 2], [3]]}
+		]`)
+		if w.Code != http.StatusOK || len(got) != 3 {
+			t.Fatalf("got %v %v want 3 items", w.Code, w.Body.String())
+		}
+		if got[0].ID != "a" || got[0].Matrix.Data[1][0] != "3" || strings.Join(got[0].Ops, ",") != "invert,sum" || got[0].Error != nil {
+			t.Errorf("got %+v want the first item", got[0])
+		}
+		if got[1].ID != "b" || got[1].Matrix != nil || code(got[1].Error) != e.CodeCellNotNumeric || strings.Join(got[1].Ops, ",") != "sum" {
+			t.Errorf("got %+v want a non numeric cell", got[1])
+		}
+		if code(got[2].Error) != e.CodeInvalidBatch {
+			t.Errorf("got %+v want an invalid matrix", got[2])
+		}
+	})
+
+	t.Run("item errors", func(t *testing.T) {
+		postJSON("/batch", `[
+			{"matrix": [[1, 2, 3], [4, 5, 6]], "ops": ["sum"]},
+			{"matrix": {"rows": 1}, "ops": ["sum"]},
+			{"matrix": [[1]]}
+		]`)
+		if len(got) != 3 || code(got[0].Error) != e.CodeLimitExceeded || code(got[1].Error) != e.CodeInvalidBatch ||
+			code(got[2].Error) != e.CodeInvalidParameter {
+			t.Errorf("got %+v want an error per item", got)
+		}
+	})
+
+	t.Run("files", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for name, csv := range map[string]string{"a.csv": "1,2\n3,4\n", "b.csv": "1,2\n3\n"} {
+			part, _ := writer.CreateFormFile("file", name)
+			io.WriteString(part, csv)
+		}
+		writer.WriteField("comment", "ignored")
+		writer.Close()
+		r := httptest.NewRequest("POST", "/batch?ops=sum,flatten", body)
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		if w := serve(r); w.Code != http.StatusOK || len(got) != 2 {
+			t.Fatalf("got %v %v want 2 items", w.Code, w.Body.String())
+		}
+		for _, item := range got {
+			if strings.Join(item.Ops, ",") != "sum,flatten" {
+				t.Errorf("got %v want the ops of the query", item.Ops)
+			}
+			switch {
+			case item.ID == "a.csv" && (item.Error != nil || item.Matrix.Data[1][1] != "4"):
+				t.Errorf("got %+v want the matrix", item)
+			case item.ID == "b.csv" && code(item.Error) != e.CodeInvalidCSV:
+				t.Errorf("got %+v want an invalid CSV", item)
+			}
+		}
+	})
+
+	t.Run("batch errors", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			contentType string
+			body        string
+			status      int
+			want        string
+		}{
+			{"malformed", "application/json", `[{"id": 1}]`, http.StatusBadRequest, `"reason":"malformed"`},
+			{"not an array", "application/json", `{}`, http.StatusBadRequest, `"reason":"malformed"`},
+			{"truncated", "application/json", `[{"matrix": [[1]]}`, http.StatusBadRequest, `"reason":"malformed"`},
+			{"no items", "application/json", `[]`, http.StatusBadRequest, `"reason":"no_items"`},
+			{"content type", "text/csv", "1,2\n", http.StatusBadRequest, `"reason":"malformed"`},
+			{"too many items", "application/json", `[{"matrix": [[1]]}, {"matrix": [[1]]}, {"matrix": [[1]]}, {"matrix": [[1]]}]`,
+				http.StatusUnprocessableEntity, `"limit":"max_batch_items"`},
+			{"too large", "application/json", `[{"matrix": [["` + strings.Repeat("1", 2000) + `"]]}]`,
+				http.StatusRequestEntityTooLarge, `"code":"PAYLOAD_TOO_LARGE"`},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				r := httptest.NewRequest("POST", "/batch?ops=sum", strings.NewReader(test.body))
+				r.Header.Set("Content-Type", test.contentType)
+				w := serve(r)
+				if w.Code != test.status || !strings.Contains(w.Body.String(), test.want) || got != nil {
+					t.Errorf("got %v %v want %v %v", w.Code, w.Body.String(), test.status, test.want)
+				}
+			})
+		}
+	})
+}
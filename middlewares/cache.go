@@ -0,0 +1,243 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	cache "takehome/cache"
+	logging "takehome/logging"
+	m "takehome/matrix"
+	metrics "takehome/metrics"
+)
+
+// CacheStatusHeader tells whether a result was served from the cache, HIT,
+// or computed, MISS.
+const CacheStatusHeader = "X-Cache"
+
+var cacheLookups = metrics.Default.NewCounterVec("matrix_cache_lookups_total",
+	"Results of matrix operations looked up in the cache, by result: hit, miss or not_modified.", "result")
+
+// CacheMiddleware serves the results of matrix operations from the cache,
+// keyed by the SHA-256 digest of the operation, its parameters and the
+// normalized matrix, so it must run once the upload is parsed. Successful
+// results are stored unless the response has Cache-Control: no-store. They
+// carry an ETag, and GET or HEAD requests sending it in If-None-Match get a
+// 304 without computing the result.
+type CacheMiddleware struct {
+	handler http.Handler
+	cache   *cache.Cache
+}
+
+// cachedResponse is the stored form of a result.
+type cachedResponse struct {
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+func (cm *CacheMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	matrix, ok := r.Context().Value(RequestFileMatrixKey).(*m.Matrix)
+	if !ok {
+		cm.handler.ServeHTTP(w, r)
+		return
+	}
+	key := cacheKey(r, matrix)
+	// The ETag is weak, as the compressed and identity responses share it.
+	etag := `W/"` + key[:32] + `"`
+	header := w.Header()
+
+	if safeMethod(r.Method) && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		lookup(r, "not_modified")
+		header.Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if data, ok := cm.cache.Get(key); ok {
+		var cached cachedResponse
+		if err := json.Unmarshal(data, &cached); err == nil {
+			lookup(r, "hit")
+			for name, values := range cached.Header {
+				for _, value := range values {
+					header.Add(name, value)
+				}
+			}
+			header.Set("ETag", etag)
+			header.Set(CacheStatusHeader, "HIT")
+			header.Set("Server-Timing", hitServerTiming(r))
+			w.WriteHeader(http.StatusOK)
+			w.Write(cached.Body)
+			return
+		}
+	}
+
+	lookup(r, "miss")
+	header.Set("ETag", etag)
+	header.Set(CacheStatusHeader, "MISS")
+	recorder := &cacheRecorder{ResponseWriter: w, before: header.Clone(), limit: cm.cache.MaxBytes()}
+	cm.handler.ServeHTTP(recorder, r)
+	if cached, ok := recorder.result(); ok {
+		if data, err := json.Marshal(cached); err == nil {
+			cm.cache.Set(key, data)
+		}
+	}
+}
+
+func NewCacheMiddleware(handlerToWrap http.Handler, cache *cache.Cache) *CacheMiddleware {
+	return &CacheMiddleware{handlerToWrap, cache}
+}
+
+func lookup(r *http.Request, result string) {
+	cacheLookups.Inc(result)
+	logging.Annotate(r.Context(), "cache", result)
+}
+
+// cacheKey returns the hex SHA-256 digest of the operation, its query
+// parameters, the representation asked for and the matrix, its integers
+// normalized so e.g. 007 and 7 share results.
+func cacheKey(r *http.Request, matrix *m.Matrix) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n%s\n%s\n", r.Method, r.URL.Path, r.URL.Query().Encode(), r.Header.Get("Accept"))
+	for _, row := range matrix.Data {
+		for j, cell := range row {
+			if j > 0 {
+				io.WriteString(hash, ",")
+			}
+			if value, err := strconv.Atoi(cell); err == nil {
+				cell = strconv.Itoa(value)
+			}
+			io.WriteString(hash, cell)
+		}
+		io.WriteString(hash, "\n")
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// safeMethod reports whether a request of method may get a 304, RFC 9110
+// allowing it for GET and HEAD only.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, with the
+// weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hitServerTiming reports the upload parsing and the cache hit.
+func hitServerTiming(r *http.Request) string {
+	timing := `cache;desc="hit"`
+	if parse, ok := r.Context().Value(RequestParseDurationKey).(time.Duration); ok {
+		millis := float64(parse.Microseconds()) / 1000
+		timing = "parse;dur=" + strconv.FormatFloat(millis, 'f', 3, 64) + ", " + timing
+	}
+	return timing
+}
+
+// cacheRecorder passes the response through, keeping a copy of a successful
+// one up to limit bytes.
+type cacheRecorder struct {
+	http.ResponseWriter
+	before http.Header
+	limit  int64
+
+	status  int
+	header  http.Header
+	body    []byte
+	tooLong bool
+}
+
+// capture records the headers the handler added, and drops the ETag of
+// responses which are not cached results.
+func (cr *cacheRecorder) capture(status int) {
+	if cr.status != 0 {
+		return
+	}
+	cr.status = status
+	header := cr.Header()
+	if status != http.StatusOK {
+		header.Del("ETag")
+		header.Del(CacheStatusHeader)
+		return
+	}
+	cr.header = http.Header{}
+	for name, values := range header {
+		if name == "Server-Timing" {
+			continue
+		}
+		previous := cr.before[name]
+		if len(values) >= len(previous) && equalValues(values[:len(previous)], previous) {
+			values = values[len(previous):]
+		}
+		if len(values) > 0 {
+			cr.header[name] = append([]string(nil), values...)
+		}
+	}
+}
+
+func equalValues(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (cr *cacheRecorder) WriteHeader(status int) {
+	cr.capture(status)
+	cr.ResponseWriter.WriteHeader(status)
+}
+
+func (cr *cacheRecorder) Write(p []byte) (int, error) {
+	cr.capture(http.StatusOK)
+	if cr.status == http.StatusOK && !cr.tooLong {
+		if cr.limit > 0 && int64(len(cr.body)+len(p)) > cr.limit {
+			cr.tooLong = true
+			cr.body = nil
+		} else {
+			cr.body = append(cr.body, p...)
+		}
+	}
+	return cr.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (cr *cacheRecorder) Flush() {
+	if flusher, ok := cr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (cr *cacheRecorder) Unwrap() http.ResponseWriter {
+	return cr.ResponseWriter
+}
+
+// result returns the response to cache, if it may be.
+func (cr *cacheRecorder) result() (cachedResponse, bool) {
+	if cr.status != http.StatusOK || cr.tooLong {
+		return cachedResponse{}, false
+	}
+	for _, directive := range strings.Split(cr.Header().Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+			return cachedResponse{}, false
+		}
+	}
+	return cachedResponse{Header: cr.header, Body: cr.body}, true
+}
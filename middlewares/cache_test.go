@@ -0,0 +1,135 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	cache "takehome/cache"
+	m "takehome/matrix"
+)
+
+func TestCacheMiddleware(t *testing.T) {
+	results, err := cache.New(cache.Options{MaxEntries: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	computed := 0
+	handler := NewCacheMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		computed++
+		switch r.URL.Query().Get("mode") {
+		case "error":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		case "no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Add("Vary", "Accept")
+		w.Header().Set("Server-Timing", "compute;dur=1.000")
+		fmt.Fprintf(w, "result %d", computed)
+	}), results)
+
+	serve := func(method, target string, data [][]string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		ctx := context.WithValue(r.Context(), RequestFileMatrixKey, &m.Matrix{Data: data})
+		ctx = context.WithValue(ctx, RequestParseDurationKey, 2*time.Millisecond)
+		r = r.WithContext(ctx)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		// Headers set by outer middlewares must not be cached.
+		w.Header().Set("X-Request-ID", target)
+		w.Header().Add("Vary", "Accept-Encoding")
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	matrix := [][]string{{"1", "2"}, {"3", "4"}}
+
+	first := serve("POST", "/sum", matrix, nil)
+	etag := first.Header().Get("ETag")
+	if first.Body.String() != "result 1" || first.Header().Get(CacheStatusHeader) != "MISS" || etag == "" {
+		t.Fatalf("got %v %v want a computed result with an ETag", first.Body.String(), first.Header())
+	}
+
+	t.Run("hit", func(t *testing.T) {
+		// Equal integers are normalized.
+		w := serve("POST", "/sum", [][]string{{"01", "2"}, {"3", "+4"}}, nil)
+		if w.Code != http.StatusOK || w.Body.String() != "result 1" || w.Header().Get(CacheStatusHeader) != "HIT" {
+			t.Errorf("got %v %v %v want the cached result", w.Code, w.Body.String(), w.Header().Get(CacheStatusHeader))
+		}
+		if got := w.Header().Get("ETag"); got != etag {
+			t.Errorf("got %v want %v", got, etag)
+		}
+		if got := w.Header().Get("Server-Timing"); got != `parse;dur=2.000, cache;desc="hit"` {
+			t.Errorf("got %v want the parse and cache timings", got)
+		}
+		if got := w.Header().Values("Vary"); len(got) != 2 || got[0] != "Accept-Encoding" || got[1] != "Accept" {
+			t.Errorf("got %v want the outer and the cached Vary values", got)
+		}
+		if got := w.Header().Get("X-Request-ID"); got != "/sum" || w.Header().Get("Content-Type") != "text/plain" {
+			t.Errorf("got %v want the headers of this request", w.Header())
+		}
+	})
+
+	t.Run("not modified", func(t *testing.T) {
+		// Unsafe methods ignore If-None-Match.
+		for _, ifNoneMatch := range []string{etag, "*"} {
+			w := serve("POST", "/sum", matrix, map[string]string{"If-None-Match": ifNoneMatch})
+			if w.Code != http.StatusOK || w.Header().Get(CacheStatusHeader) != "HIT" {
+				t.Errorf("%v: got %v %v want the cached result", ifNoneMatch, w.Code, w.Header().Get(CacheStatusHeader))
+			}
+		}
+
+		getETag := serve("GET", "/sum", matrix, nil).Header().Get("ETag")
+		for _, ifNoneMatch := range []string{getETag, `"other", ` + getETag[2:], "*"} {
+			w := serve("GET", "/sum", matrix, map[string]string{"If-None-Match": ifNoneMatch})
+			if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("ETag") != getETag {
+				t.Errorf("%v: got %v %q want %v", ifNoneMatch, w.Code, w.Body.String(), http.StatusNotModified)
+			}
+		}
+		w := serve("GET", "/sum", matrix, map[string]string{"If-None-Match": `W/"other"`})
+		if w.Code != http.StatusOK || w.Header().Get(CacheStatusHeader) != "HIT" {
+			t.Errorf("got %v %v want the cached result", w.Code, w.Header().Get(CacheStatusHeader))
+		}
+		if computed != 2 {
+			t.Errorf("got %v computations want 2", computed)
+		}
+	})
+
+	t.Run("misses", func(t *testing.T) {
+		tests := []struct {
+			target  string
+			data    [][]string
+			headers map[string]string
+		}{
+			{"/multiply", matrix, nil},
+			{"/sum?expect_shape=2x2", matrix, nil},
+			{"/sum", matrix, map[string]string{"Accept": "application/json"}},
+			{"/sum", [][]string{{"1", "2", "3", "4"}}, nil},
+		}
+		for _, test := range tests {
+			before := computed
+			w := serve("POST", test.target, test.data, test.headers)
+			if computed != before+1 || w.Header().Get(CacheStatusHeader) != "MISS" || w.Header().Get("ETag") == etag {
+				t.Errorf("%v %v: got %v want a computed result with another ETag", test.target, test.headers, w.Header())
+			}
+		}
+	})
+
+	t.Run("not cached", func(t *testing.T) {
+		for _, mode := range []string{"error", "no-store"} {
+			serve("POST", "/invert?mode="+mode, matrix, nil)
+			w := serve("POST", "/invert?mode="+mode, matrix, nil)
+			if w.Header().Get(CacheStatusHeader) == "HIT" {
+				t.Errorf("%v: got a cached result want none", mode)
+			}
+			if mode == "error" && w.Header().Get("ETag") != "" {
+				t.Errorf("got ETag %v want none on errors", w.Header().Get("ETag"))
+			}
+		}
+	})
+}
@@ -0,0 +1,327 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	e "takehome/errors"
+)
+
+// Content codings negotiated for responses.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// CompressionMiddleware compresses responses with gzip or deflate, as
+// negotiated with the Accept-Encoding header. Responses shorter than minSize
+// are sent as is, as compressing them saves too little.
+type CompressionMiddleware struct {
+	handler http.Handler
+	minSize int
+}
+
+func (cm *CompressionMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" || r.Method == http.MethodHead {
+		cm.handler.ServeHTTP(w, r)
+		return
+	}
+
+	cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: cm.minSize}
+	defer cw.Close()
+	cm.handler.ServeHTTP(cw, r)
+}
+
+func NewCompressionMiddleware(handlerToWrap http.Handler, minSize int) *CompressionMiddleware {
+	return &CompressionMiddleware{handlerToWrap, minSize}
+}
+
+// negotiateEncoding returns the preferred encoding of an Accept-Encoding
+// header, gzip winning ties, or "" when neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	quality := map[string]float64{}
+	wildcard := -1.0
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(item, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch name {
+		case "*":
+			wildcard = q
+		case EncodingGzip, "x-gzip":
+			quality[EncodingGzip] = q
+		case EncodingDeflate:
+			quality[EncodingDeflate] = q
+		}
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range []string{EncodingGzip, EncodingDeflate} {
+		q, ok := quality[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQuality {
+			best, bestQuality = encoding, q
+		}
+	}
+	return best
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	zlibWriters = sync.Pool{New: func() interface{} { return zlib.NewWriter(nil) }}
+)
+
+// compressor is the interface of gzip and zlib writers.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressWriter buffers the start of the response until it knows whether
+// it reaches minSize, then sends it compressed or as is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status     int
+	buffer     []byte
+	decided    bool
+	compressor compressor
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.buffer = append(cw.buffer, p...)
+		if len(cw.buffer) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.compressor != nil {
+		return cw.compressor.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressing the response when asked to and when
+// it may be, then the buffered start of the response.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if compress && header.Get("Content-Encoding") == "" && status != http.StatusNoContent &&
+		status != http.StatusNotModified && status != http.StatusPartialContent {
+		// net/http sniffs the type of the bytes written, which would be
+		// the compressed ones.
+		if _, ok := header["Content-Type"]; !ok && len(cw.buffer) > 0 {
+			header.Set("Content-Type", http.DetectContentType(cw.buffer))
+		}
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		if cw.encoding == EncodingGzip {
+			cw.compressor = gzipWriters.Get().(*gzip.Writer)
+		} else {
+			cw.compressor = zlibWriters.Get().(*zlib.Writer)
+		}
+		cw.compressor.Reset(cw.ResponseWriter)
+	}
+	if cw.status != 0 || len(cw.buffer) > 0 {
+		cw.ResponseWriter.WriteHeader(status)
+	}
+
+	buffer := cw.buffer
+	cw.buffer = nil
+	if len(buffer) == 0 {
+		return nil
+	}
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(buffer)
+	} else {
+		_, err = cw.ResponseWriter.Write(buffer)
+	}
+	return err
+}
+
+// Flush compresses what was written so far, so streamed responses are
+// compressed whatever their size.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close sends a response shorter than minSize as is, or completes the
+// compressed stream.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		return cw.decide(false)
+	}
+	if cw.compressor == nil {
+		return nil
+	}
+	err := cw.compressor.Close()
+	switch c := cw.compressor.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(c)
+	case *zlib.Writer:
+		zlibWriters.Put(c)
+	}
+	cw.compressor = nil
+	return err
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decompressError reports corrupt compressed data.
+type decompressError struct {
+	err error
+}
+
+func (de *decompressError) Error() string { return de.err.Error() }
+
+func (de *decompressError) Unwrap() error { return de.err }
+
+// decompressReader reports the errors of a gzip stream as decompressError,
+// except the end of the stream and the errors of the body under it.
+type decompressReader struct {
+	reader io.Reader
+}
+
+func (dr *decompressReader) Read(p []byte) (int, error) {
+	n, err := dr.reader.Read(p)
+	var maxBytesError *http.MaxBytesError
+	if err != nil && err != io.EOF && !errors.As(err, &maxBytesError) {
+		err = &decompressError{err}
+	}
+	return n, err
+}
+
+// maxBytesReader bounds decompressed data to limit bytes, failing with an
+// http.MaxBytesError like the body limit, so a small compressed upload
+// cannot expand past the upload size limits.
+type maxBytesReader struct {
+	reader    io.Reader
+	limit     int64
+	remaining int64
+}
+
+func (mr *maxBytesReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > mr.remaining+1 {
+		p = p[:mr.remaining+1]
+	}
+	n, err := mr.reader.Read(p)
+	if int64(n) <= mr.remaining {
+		mr.remaining -= int64(n)
+		return n, err
+	}
+	n = int(mr.remaining)
+	mr.remaining = 0
+	return n, &http.MaxBytesError{Limit: mr.limit}
+}
+
+// gunzip returns the decompressed stream of reader, bounded to limit bytes
+// when limit is positive.
+func gunzip(reader io.Reader, limit int64) (io.Reader, error) {
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if !errors.As(err, &maxBytesError) {
+			err = &decompressError{err}
+		}
+		return nil, err
+	}
+	var decompressed io.Reader = &decompressReader{gz}
+	if limit > 0 {
+		decompressed = &maxBytesReader{decompressed, limit, limit}
+	}
+	return decompressed, nil
+}
+
+// decodedBody returns the body of r decoded from its Content-Encoding.
+func decodedBody(r *http.Request, limit int64) (io.ReadCloser, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return r.Body, nil
+	case EncodingGzip, "x-gzip":
+		body, err := gunzip(r.Body, limit)
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{body, r.Body}, nil
+	}
+	params := e.Params{"encoding": encoding}
+	return nil, e.NewHTTPError(nil, e.CodeUnsupportedEncoding, params).With("encoding", encoding)
+}
+
+// decodedPart returns the content of the uploaded file, decompressed when it
+// is gzipped, e.g. matrix.csv.gz.
+func decodedPart(part *multipart.Part, limit int64) (io.Reader, error) {
+	contentType := strings.ToLower(part.Header.Get("Content-Type"))
+	gzipped := strings.HasSuffix(strings.ToLower(part.FileName()), ".gz") ||
+		contentType == "application/gzip" || contentType == "application/x-gzip"
+	if !gzipped {
+		return part, nil
+	}
+	return gunzip(part, limit)
+}
+
+// invalidCompressionError reports whether err comes from corrupt compressed
+// data, and the problem to answer with.
+func invalidCompressionError(err error) (*e.HTTPError, bool) {
+	var decompressErr *decompressError
+	if !errors.As(err, &decompressErr) {
+		return nil, false
+	}
+	return e.NewHTTPError(err, e.CodeInvalidCompression, nil), true
+}
@@ -0,0 +1,230 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	e "takehome/errors"
+	m "takehome/matrix"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"identity":                  "",
+		"gzip":                      EncodingGzip,
+		"deflate":                   EncodingDeflate,
+		"deflate, gzip":             EncodingGzip,
+		"gzip;q=0.5, deflate":       EncodingDeflate,
+		"GZIP;q=0.8, deflate;q=0.2": EncodingGzip,
+		"gzip;q=0":                  "",
+		"*":                         EncodingGzip,
+		"*, gzip;q=0":               EncodingDeflate,
+		"br":                        "",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("%q: got %q want %q", header, got, want)
+		}
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	large := strings.Repeat("1,2,3\n", 100)
+	handler := NewCompressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Length", "1")
+		if r.URL.Query().Get("status") == "created" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		io.WriteString(w, r.URL.Query().Get("body"))
+	}), 256)
+	serve := func(method, body, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/echo?status=created&body="+strings.ReplaceAll(body, "\n", "%0A"), nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		w := serve("GET", large, "gzip, deflate")
+		if got := w.Header().Get("Content-Encoding"); got != EncodingGzip {
+			t.Fatalf("got %v want %v", got, EncodingGzip)
+		}
+		if w.Code != http.StatusCreated || w.Header().Get("Content-Length") != "" || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("got %v %v want the status, no length and a Vary header", w.Code, w.Header())
+		}
+		reader, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadAll(reader); string(got) != large {
+			t.Errorf("got %q want %q", got, large)
+		}
+		if w.Body.Len() > len(large)/2 {
+			t.Errorf("got %v bytes want them compressed", w.Body.Len())
+		}
+	})
+
+	t.Run("deflate", func(t *testing.T) {
+		w := serve("GET", large, "deflate")
+		if got := w.Header().Get("Content-Encoding"); got != EncodingDeflate {
+			t.Fatalf("got %v want %v", got, EncodingDeflate)
+		}
+		reader, err := zlib.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := ioutil.ReadAll(reader); string(got) != large {
+			t.Errorf("got %q want %q", got, large)
+		}
+	})
+
+	t.Run("content type", func(t *testing.T) {
+		handler := NewCompressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "<html>"+large)
+		}), 256)
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		r, _ := http.NewRequest("GET", server.URL, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		want := "text/html; charset=utf-8"
+		if resp.Header.Get("Content-Encoding") != EncodingGzip || resp.Header.Get("Content-Type") != want {
+			t.Errorf("got %v want %v sniffed from the uncompressed body", resp.Header, want)
+		}
+	})
+
+	t.Run("not compressed", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			method         string
+			body           string
+			acceptEncoding string
+		}{
+			{"below threshold", "GET", "1,2,3", "gzip"},
+			{"not accepted", "GET", large, ""},
+			{"HEAD", "HEAD", large, "gzip"},
+		}
+		for _, test := range tests {
+			w := serve(test.method, test.body, test.acceptEncoding)
+			if got := w.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("%v: got %v want none", test.name, got)
+			}
+			if w.Code != http.StatusCreated || w.Body.String() != test.body {
+				t.Errorf("%v: got %v %q want %v %q", test.name, w.Code, w.Body.String(), http.StatusCreated, test.body)
+			}
+		}
+	})
+}
+
+// newGzipUploadRequest returns a request uploading data as the file named
+// filename, the body being gzipped when gzipBody is set.
+func newGzipUploadRequest(t *testing.T, filename string, data []byte, gzipBody bool) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	payload := body.Bytes()
+	if gzipBody {
+		payload = gzipped(payload)
+	}
+	r := httptest.NewRequest("POST", "/echo", bytes.NewReader(payload))
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	if gzipBody {
+		r.Header.Set("Content-Encoding", "gzip")
+	}
+	return r
+}
+
+func gzipped(data []byte) []byte {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(data)
+	writer.Close()
+	return compressed.Bytes()
+}
+
+func TestCompressedUploads(t *testing.T) {
+	var got *m.Matrix
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Context().Value(RequestFileMatrixKey).(*m.Matrix)
+	})
+	handler := NewFileToMatrixMiddleware(next, Limits{MaxBodyBytes: 4096})
+	csv := []byte("1,2\n3,4\n")
+
+	t.Run("accepted", func(t *testing.T) {
+		requests := map[string]*http.Request{
+			"gzip body":     newGzipUploadRequest(t, "matrix.csv", csv, true),
+			"csv.gz file":   newGzipUploadRequest(t, "matrix.csv.gz", gzipped(csv), false),
+			"both gzipped":  newGzipUploadRequest(t, "matrix.csv.gz", gzipped(csv), true),
+			"identity body": newGzipUploadRequest(t, "matrix.csv", csv, false),
+		}
+		for name, r := range requests {
+			got = nil
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != http.StatusOK || got == nil || got.Shape().Cells() != 4 {
+				t.Errorf("%v: got %v %v want the matrix", name, w.Code, w.Body.String())
+			}
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		// 1 MB of zeros compresses to about 1 kB, but exceeds MaxBodyBytes
+		// once decompressed.
+		bomb := bytes.Repeat([]byte("0,"), 1<<19)
+		unsupported := newGzipUploadRequest(t, "matrix.csv", csv, false)
+		unsupported.Header.Set("Content-Encoding", "br")
+		corruptBody := newGzipUploadRequest(t, "matrix.csv", csv, false)
+		corruptBody.Header.Set("Content-Encoding", "gzip")
+		truncated := gzipped(csv)
+		truncated = truncated[:len(truncated)-6]
+
+		tests := []struct {
+			name string
+			r    *http.Request
+			code e.Code
+		}{
+			{"unsupported encoding", unsupported, e.CodeUnsupportedEncoding},
+			{"corrupt body", corruptBody, e.CodeInvalidCompression},
+			{"corrupt file", newGzipUploadRequest(t, "matrix.csv.gz", csv, false), e.CodeInvalidCompression},
+			{"truncated file", newGzipUploadRequest(t, "matrix.csv.gz", truncated, false), e.CodeInvalidCompression},
+			{"bomb body", newGzipUploadRequest(t, "matrix.csv", bomb, true), e.CodePayloadTooLarge},
+			{"bomb file", newGzipUploadRequest(t, "matrix.csv.gz", gzipped(bomb), false), e.CodePayloadTooLarge},
+		}
+		for _, test := range tests {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, test.r)
+			var body struct {
+				Code e.Code `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+			if body.Code != test.code {
+				t.Errorf("%v: got %v %v want %v", test.name, w.Code, body.Code, test.code)
+			}
+		}
+	})
+}
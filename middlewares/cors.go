@@ -0,0 +1,147 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the CORS middleware.
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed to call the API, e.g.
+	// "https://dashboard.example.com", or "*" for any origin.
+	AllowedOrigins []string
+	// AllowedMethods and AllowedHeaders are granted to preflight requests,
+	// "*" in AllowedHeaders granting any header.
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read.
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies and Authorization headers
+	// from the listed origins, which are then echoed. It may not be combined
+	// with "*", which would let any site make calls with the credentials of
+	// its visitors.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// ErrCORSAnyOriginCredentials rejects options allowing credentials from any
+// origin.
+var ErrCORSAnyOriginCredentials = errors.New("CORS credentials may not be allowed from any origin.")
+
+// Validate returns an error when the options are unsafe.
+func (o CORSOptions) Validate() error {
+	if o.AllowCredentials && containsFold(o.AllowedOrigins, "*") {
+		return ErrCORSAnyOriginCredentials
+	}
+	return nil
+}
+
+// CORSMiddleware sets the CORS headers of requests from allowed origins and
+// answers preflight requests itself, so they never reach the method checks
+// or the upload parsing. Requests from other origins get no CORS header,
+// making browsers block them. Options should be validated first, credentials
+// being never allowed from "*".
+type CORSMiddleware struct {
+	handler http.Handler
+	options CORSOptions
+}
+
+func (cm *CORSMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		cm.handler.ServeHTTP(w, r)
+		return
+	}
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		cm.preflight(header, r, origin)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if cm.allowOrigin(header, origin) && len(cm.options.ExposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(cm.options.ExposedHeaders, ", "))
+	}
+	cm.handler.ServeHTTP(w, r)
+}
+
+func NewCORSMiddleware(handlerToWrap http.Handler, options CORSOptions) *CORSMiddleware {
+	return &CORSMiddleware{handlerToWrap, options}
+}
+
+// preflight grants the requested method and headers when the origin and
+// they are allowed.
+func (cm *CORSMiddleware) preflight(header http.Header, r *http.Request, origin string) {
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !containsFold(cm.options.AllowedMethods, method) {
+		return
+	}
+	requested := requestedHeaders(r)
+	if !containsFold(cm.options.AllowedHeaders, "*") {
+		for _, name := range requested {
+			if !containsFold(cm.options.AllowedHeaders, name) {
+				return
+			}
+		}
+	}
+	if !cm.allowOrigin(header, origin) {
+		return
+	}
+
+	header.Set("Access-Control-Allow-Methods", strings.Join(cm.options.AllowedMethods, ", "))
+	if len(requested) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if cm.options.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(cm.options.MaxAge.Seconds())))
+	}
+}
+
+// allowOrigin sets the headers allowing the origin, and reports whether it is
+// allowed.
+func (cm *CORSMiddleware) allowOrigin(header http.Header, origin string) bool {
+	anyOrigin := containsFold(cm.options.AllowedOrigins, "*")
+	if !anyOrigin && !containsFold(cm.options.AllowedOrigins, origin) {
+		return false
+	}
+	if anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if cm.options.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// requestedHeaders returns the headers of the Access-Control-Request-Headers
+// header.
+func requestedHeaders(r *http.Request) []string {
+	var names []string
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, strings.ToLower(name))
+			}
+		}
+	}
+	return names
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	options := CORSOptions{
+		AllowedOrigins: []string{"https://dashboard.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "X-Request-ID"},
+		ExposedHeaders: []string{"X-Request-ID", "Server-Timing"},
+		MaxAge:         10 * time.Minute,
+	}
+	var served bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	})
+	// Preflight requests must be answered before the POST only check.
+	handler := NewCORSMiddleware(NewPOSTMethodOnlyMiddleware(next), options)
+	serve := func(handler http.Handler, method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		served = false
+		r := httptest.NewRequest(method, "/sum", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	preflight := map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "authorization, x-request-id",
+	}
+
+	t.Run("allowed origin preflight", func(t *testing.T) {
+		w := serve(handler, "OPTIONS", "https://dashboard.example.com", preflight)
+		if w.Code != http.StatusNoContent || served {
+			t.Errorf("got %v, served %v want %v unserved", w.Code, served, http.StatusNoContent)
+		}
+		want := map[string]string{
+			"Access-Control-Allow-Origin":  "https://dashboard.example.com",
+			"Access-Control-Allow-Methods": "GET, POST",
+			"Access-Control-Allow-Headers": "authorization, x-request-id",
+			"Access-Control-Max-Age":       "600",
+		}
+		for header, value := range want {
+			if got := w.Header().Get(header); got != value {
+				t.Errorf("%v: got %v want %v", header, got, value)
+			}
+		}
+		if got := w.Header().Values("Vary"); len(got) != 3 || got[0] != "Origin" {
+			t.Errorf("got Vary %v want Origin and the preflight headers", got)
+		}
+	})
+
+	t.Run("allowed origin request", func(t *testing.T) {
+		w := serve(handler, "POST", "https://dashboard.example.com", nil)
+		if !served {
+			t.Errorf("got unserved want served")
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example.com" {
+			t.Errorf("got %v want the origin", got)
+		}
+		if got := w.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-ID, Server-Timing" {
+			t.Errorf("got %v want the exposed headers", got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+			t.Errorf("got %v want no credentials", got)
+		}
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		w := serve(handler, "OPTIONS", "https://evil.example.com", preflight)
+		if w.Code != http.StatusNoContent || served {
+			t.Errorf("got %v, served %v want %v unserved", w.Code, served, http.StatusNoContent)
+		}
+		for _, header := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Headers"} {
+			if got := w.Header().Get(header); got != "" {
+				t.Errorf("%v: got %v want none", header, got)
+			}
+		}
+
+		w = serve(handler, "POST", "https://evil.example.com", nil)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" || !served {
+			t.Errorf("got %v, served %v want no CORS header", got, served)
+		}
+	})
+
+	t.Run("disallowed method or header", func(t *testing.T) {
+		for name, value := range map[string]string{"Access-Control-Request-Method": "DELETE", "Access-Control-Request-Headers": "x-custom"} {
+			headers := map[string]string{"Access-Control-Request-Method": "POST", name: value}
+			w := serve(handler, "OPTIONS", "https://dashboard.example.com", headers)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+				t.Errorf("%v: got %v want none", value, got)
+			}
+		}
+	})
+
+	t.Run("no origin", func(t *testing.T) {
+		w := serve(handler, "OPTIONS", "", preflight)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Vary") != "" {
+			t.Errorf("got %v want %v without CORS", w.Code, http.StatusMethodNotAllowed)
+		}
+	})
+
+	t.Run("any origin", func(t *testing.T) {
+		anyOrigin := options
+		anyOrigin.AllowedOrigins = []string{"*"}
+		w := serve(NewCORSMiddleware(next, anyOrigin), "POST", "https://any.example.com", nil)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+			t.Errorf("got %v want *", got)
+		}
+
+		// Credentials are never allowed from any origin.
+		anyOrigin.AllowCredentials = true
+		if err := anyOrigin.Validate(); err != ErrCORSAnyOriginCredentials {
+			t.Errorf("got %v want %v", err, ErrCORSAnyOriginCredentials)
+		}
+		w = serve(NewCORSMiddleware(next, anyOrigin), "POST", "https://any.example.com", nil)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("got %v %v want * without credentials", got, w.Header().Get("Access-Control-Allow-Credentials"))
+		}
+	})
+
+	t.Run("credentials", func(t *testing.T) {
+		credentials := options
+		credentials.AllowCredentials = true
+		if err := credentials.Validate(); err != nil {
+			t.Errorf("got %v want no error", err)
+		}
+		w := serve(NewCORSMiddleware(next, credentials), "POST", "https://dashboard.example.com", nil)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example.com" {
+			t.Errorf("got %v want the origin echoed with credentials", got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Errorf("got %v want true", got)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	jobs "takehome/jobs"
+)
+
+// EventStreamType is the media type of Server-Sent Events.
+const EventStreamType = "text/event-stream"
+
+// Events of job streams.
+const (
+	EventProgress = "progress"
+	EventResult   = "result"
+	EventError    = "error"
+)
+
+// keepAliveInterval is how often a comment is sent on idle streams, so
+// proxies keep them open.
+const keepAliveInterval = 15 * time.Second
+
+// AcceptsEventStream reports whether the client asks for Server-Sent Events
+// with the Accept header.
+func AcceptsEventStream(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == EventStreamType {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamJob streams the job of id as Server-Sent Events: a progress event
+// with the job after each change of its status or progress, then its
+// outcome in a result event once it succeeded, or in an error event once
+// it failed or was canceled. The stream ends then, or once the client went
+// away. It returns jobs.ErrNotFound, before writing anything, for unknown
+// jobs.
+func StreamJob(w http.ResponseWriter, r *http.Request, runner *jobs.Runner, id string) error {
+	job, changes, stop, err := runner.Watch(id)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	// The stream lasts as long as the job, past the write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", EventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	if !job.Status.Finished() {
+		writeEvent(w, EventProgress, job)
+	}
+	for !job.Status.Finished() {
+		select {
+		case changed, ok := <-changes:
+			if !ok {
+				// The job finished, its last change already received.
+				changes = nil
+				if job, err = runner.Get(id); err != nil {
+					return nil
+				}
+				continue
+			}
+			job = changed
+			if !job.Status.Finished() {
+				writeEvent(w, EventProgress, job)
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flush(w)
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+	event := EventResult
+	if job.Status != jobs.StatusSucceeded {
+		event = EventError
+	}
+	writeEvent(w, event, jobs.NewOutcome(job))
+	return nil
+}
+
+// writeEvent writes and flushes an event whose data is the JSON of v.
+func writeEvent(w http.ResponseWriter, event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	flush(w)
+}
+
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,136 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	jobs "takehome/jobs"
+	m "takehome/matrix"
+)
+
+func TestAcceptsEventStream(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"application/json", false},
+		{"text/event-stream", true},
+		{"application/json, text/event-stream;q=0.9", true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/jobs/1/events", nil)
+		r.Header.Set("Accept", test.accept)
+		if got := AcceptsEventStream(r); got != test.want {
+			t.Errorf("%q: got %v want %v", test.accept, got, test.want)
+		}
+	}
+}
+
+// eventRecorder sends what is written to it on a channel, so the events of
+// a stream are read as they are written.
+type eventRecorder struct {
+	header http.Header
+	writes chan string
+}
+
+func (er *eventRecorder) Header() http.Header { return er.header }
+func (er *eventRecorder) WriteHeader(int)     {}
+func (er *eventRecorder) Write(p []byte) (int, error) {
+	er.writes <- string(p)
+	return len(p), nil
+}
+
+func TestStreamJob(t *testing.T) {
+	runner, err := jobs.NewRunner(jobs.NewMemoryStore(), 1, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Shutdown(context.Background())
+
+	stream := func(ctx context.Context, id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/jobs/"+id+"/events", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		if err := StreamJob(w, r, runner, id); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	t.Run("result", func(t *testing.T) {
+		step := make(chan struct{})
+		job := &jobs.Job{Operation: "/sum"}
+		runner.Submit(context.Background(), job, func(ctx context.Context) *jobs.Result {
+			<-step
+			m.ReportProgress(ctx, m.Progress{Rows: 2, RowsDone: 1})
+			<-step
+			header := http.Header{"Content-Type": {"text/plain"}}
+			return &jobs.Result{Status: http.StatusOK, Header: header, Body: []byte("10")}
+		})
+		w := &eventRecorder{http.Header{}, make(chan string)}
+		go func() {
+			r := httptest.NewRequest("GET", "/jobs/"+job.ID+"/events", nil)
+			StreamJob(w, r, runner, job.ID)
+			close(w.writes)
+		}()
+		body := <-w.writes
+		if w.Header().Get("Content-Type") != EventStreamType || !strings.HasPrefix(body, "event: progress\ndata: {") {
+			t.Errorf("got %v %q want a progress event", w.Header(), body)
+		}
+		// Each step waits for the progress event of the previous one.
+		step <- struct{}{}
+		for !strings.Contains(body, `"progress":50,"rows":2,"rows_done":1`) {
+			body += <-w.writes
+		}
+		step <- struct{}{}
+		for write := range w.writes {
+			body += write
+		}
+		if want := "event: result\ndata: {\"job\":{\"id\":\"" + job.ID; !strings.Contains(body, want) ||
+			!strings.Contains(body, `"rows":2,"rows_done":2`) ||
+			!strings.HasSuffix(body, `"result":{"status":200,"content_type":"text/plain","body":"10"}}`+"\n\n") {
+			t.Errorf("got %q want the result event last", body)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		job := &jobs.Job{Operation: "/sum"}
+		runner.Submit(context.Background(), job, func(ctx context.Context) *jobs.Result {
+			return &jobs.Result{Status: http.StatusUnprocessableEntity}
+		})
+		for {
+			if finished, _ := runner.Get(job.ID); finished.Status.Finished() {
+				break
+			}
+		}
+		body := stream(context.Background(), job.ID).Body.String()
+		if !strings.HasPrefix(body, "event: error\n") || !strings.Contains(body, `"status":"failed"`) || strings.Count(body, "event:") != 1 {
+			t.Errorf("got %q want a single error event", body)
+		}
+	})
+
+	t.Run("client gone", func(t *testing.T) {
+		job := &jobs.Job{Operation: "/sum"}
+		runner.Submit(context.Background(), job, func(ctx context.Context) *jobs.Result {
+			<-ctx.Done()
+			return nil
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		body := stream(ctx, job.ID).Body.String()
+		if strings.Contains(body, "event: result") || strings.Contains(body, "event: error") {
+			t.Errorf("got %q want no outcome", body)
+		}
+		runner.Cancel(job.ID)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/jobs/unknown/events", nil)
+		if err := StreamJob(httptest.NewRecorder(), r, runner, "unknown"); err != jobs.ErrNotFound {
+			t.Errorf("got %v want %v", err, jobs.ErrNotFound)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+
+	auth "takehome/auth"
+	e "takehome/errors"
+	logging "takehome/logging"
+	tracing "takehome/tracing"
+)
+
+// JWTMiddleware requires a JWT Bearer token verified by the verifier and
+// granting scope. Requests without a token get a 401 UNAUTHORIZED problem,
+// those with an invalid one a 401 INVALID_TOKEN problem with the reason, and
+// those whose token lacks the scope a 403 problem. The claims are stored in
+// the request context, see auth.ClaimsFromContext, and the subject is added
+// to the access log. An empty scope only requires a valid token.
+type JWTMiddleware struct {
+	handler  http.Handler
+	verifier *auth.Verifier
+	scope    string
+}
+
+func (jm *JWTMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := bearerToken(r)
+	if !ok {
+		unauthorized(w, r, e.NewHTTPError(nil, e.CodeUnauthorized, nil), "")
+		return
+	}
+	claims, err := jm.verifier.Verify(token)
+	if err != nil {
+		reason := auth.ReasonMalformed
+		if tokenError, ok := err.(*auth.TokenError); ok {
+			reason = tokenError.Reason
+		}
+		problem := e.NewHTTPError(err, e.CodeInvalidToken, e.Params{"reason": reason}).With("reason", reason)
+		unauthorized(w, r, problem, "invalid_token")
+		return
+	}
+
+	logging.Annotate(r.Context(), "subject", claims.Subject)
+	tracing.SpanFromContext(r.Context()).SetAttribute("auth.subject", claims.Subject)
+	if jm.scope != "" && !claims.Allows(jm.scope) {
+		forbidden(w, r, claims.Subject, jm.scope)
+		return
+	}
+
+	jm.handler.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+}
+
+func NewJWTMiddleware(handlerToWrap http.Handler, verifier *auth.Verifier, scope string) *JWTMiddleware {
+	return &JWTMiddleware{handlerToWrap, verifier, scope}
+}
+
+// BearerJWT reports whether the request carries a JWT as Bearer token, to
+// tell tokens from API keys sent the same way.
+func BearerJWT(r *http.Request) bool {
+	token, ok := bearerToken(r)
+	return ok && strings.Count(token, ".") == 2
+}
@@ -0,0 +1,130 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	auth "takehome/auth"
+	e "takehome/errors"
+	logging "takehome/logging"
+)
+
+// hs256 returns a JWT of the claims signed with secret.
+func hs256(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	verifier := auth.NewVerifier([]byte("secret"), nil)
+	exp := time.Now().Add(time.Hour).Unix()
+
+	var logs bytes.Buffer
+	logger := logging.New(&logs, logging.Info, logging.Text)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(auth.ClaimsFromContext(r.Context()).String("tenant")))
+	})
+	serve := func(scope, token string) *httptest.ResponseRecorder {
+		logs.Reset()
+		r := httptest.NewRequest("POST", "/echo", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		NewLoggingMiddleware(NewJWTMiddleware(ok, verifier, scope), logger).ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("missing token", func(t *testing.T) {
+		w := serve("matrix:read", "")
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("got %v want %v", w.Code, http.StatusUnauthorized)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="takehome"` {
+			t.Errorf("got %v want a Bearer challenge", got)
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		tokens := map[string]string{
+			auth.ReasonBadSignature: hs256(t, "other", map[string]interface{}{"sub": "alice", "exp": exp}),
+			auth.ReasonExpired:      hs256(t, "secret", map[string]interface{}{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()}),
+			auth.ReasonMalformed:    "not.a.jwt",
+		}
+		for reason, token := range tokens {
+			w := serve("matrix:read", token)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%v: got %v want %v", reason, w.Code, http.StatusUnauthorized)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != `Bearer realm="takehome", error="invalid_token"` {
+				t.Errorf("%v: got %v want an invalid_token challenge", reason, got)
+			}
+			var body struct {
+				Code   e.Code `json:"code"`
+				Reason string `json:"reason"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != e.CodeInvalidToken || body.Reason != reason {
+				t.Errorf("got %+v want %v %v", body, e.CodeInvalidToken, reason)
+			}
+		}
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		token := hs256(t, "secret", map[string]interface{}{"sub": "alice", "exp": exp, "scope": "matrix:read", "tenant": "acme"})
+		w := serve("matrix:read", token)
+		if w.Code != http.StatusOK || w.Body.String() != "acme" {
+			t.Errorf("got %v %v want %v acme", w.Code, w.Body.String(), http.StatusOK)
+		}
+		if !strings.Contains(logs.String(), "subject=alice") || strings.Contains(logs.String(), token) {
+			t.Errorf("got log %q want the subject without the token", logs.String())
+		}
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		token := hs256(t, "secret", map[string]interface{}{"sub": "alice", "exp": exp, "scp": []string{"matrix:read"}})
+		w := serve("matrix:compute", token)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("got %v want %v", w.Code, http.StatusForbidden)
+		}
+		if !strings.Contains(w.Body.String(), "alice") {
+			t.Errorf("got %v want the subject in the problem", w.Body.String())
+		}
+	})
+}
+
+func TestBearerJWT(t *testing.T) {
+	tests := map[string]bool{
+		"":                   false,
+		"Bearer api-key":     false,
+		"Bearer a.b.c":       true,
+		"bearer a.b.c":       true,
+		"Basic dXNlcjpwdw==": false,
+	}
+	for header, want := range tests {
+		r := httptest.NewRequest("POST", "/echo", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if got := BearerJWT(r); got != want {
+			t.Errorf("%q: got %v want %v", header, got, want)
+		}
+	}
+}
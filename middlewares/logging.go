@@ -0,0 +1,120 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+
+	logging "takehome/logging"
+)
+
+// maxRequestIDLength bounds the request IDs propagated from clients.
+const maxRequestIDLength = 128
+
+// LoggingMiddleware assigns each request an ID, or propagates the one sent in
+// the X-Request-ID header, and writes one access log line per request. The
+// ID, logger and access log annotations are stored in the request context,
+// see the logging package.
+type LoggingMiddleware struct {
+	handler http.Handler
+	logger  *logging.Logger
+}
+
+func (lm *LoggingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+
+	ctx := logging.WithLogger(r.Context(), lm.logger)
+	ctx = logging.WithRequestID(ctx, id)
+	ctx = logging.WithAnnotations(ctx)
+
+	recorder := NewStatusRecorder(w)
+	lm.handler.ServeHTTP(recorder, r.WithContext(ctx))
+
+	fields := logging.Annotations(ctx)
+	fields["request_id"] = id
+	fields["method"] = r.Method
+	fields["path"] = r.URL.Path
+	fields["status"] = recorder.Status()
+	fields["output_bytes"] = recorder.Bytes()
+	fields["latency_ms"] = float64(time.Since(start).Microseconds()) / 1000
+
+	level := logging.Info
+	if recorder.Status() >= http.StatusInternalServerError {
+		level = logging.Error
+	}
+	lm.logger.Log(level, "request", fields)
+}
+
+func NewLoggingMiddleware(handlerToWrap http.Handler, logger *logging.Logger) *LoggingMiddleware {
+	return &LoggingMiddleware{handlerToWrap, logger}
+}
+
+// validRequestID reports whether a client request ID is safe to propagate
+// and log: short and made of letters, digits, '-', '_' and '.'.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// StatusRecorder records the status code and size of a response.
+type StatusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+// Status returns the status code written, 200 until one is.
+func (sr *StatusRecorder) Status() int {
+	return sr.status
+}
+
+// Bytes returns the size of the body written.
+func (sr *StatusRecorder) Bytes() int64 {
+	return sr.bytes
+}
+
+func (sr *StatusRecorder) WriteHeader(status int) {
+	if !sr.wroteHeader {
+		sr.status = status
+		sr.wroteHeader = true
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *StatusRecorder) Write(p []byte) (int, error) {
+	sr.wroteHeader = true
+	n, err := sr.ResponseWriter.Write(p)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (sr *StatusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (sr *StatusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -0,0 +1,85 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	logging "takehome/logging"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger := logging.New(&logs, logging.Info, logging.JSON)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	handlerToTestLoggingMiddleware := NewLoggingMiddleware(NewFileToMatrixMiddleware(ok, Limits{}), logger)
+
+	serve := func(t *testing.T, r *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+		t.Helper()
+		logs.Reset()
+		w := httptest.NewRecorder()
+		handlerToTestLoggingMiddleware.ServeHTTP(w, r)
+
+		var line map[string]interface{}
+		if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+			t.Fatalf("got log %q: %v", logs.String(), err)
+		}
+		return w, line
+	}
+
+	t.Run("propagated request ID", func(t *testing.T) {
+		r := newUploadRequest(t, "/sum", "1,2\n3,4")
+		r.Header.Set(RequestIDHeader, "request-42")
+		w, line := serve(t, r)
+
+		if got := w.Header().Get(RequestIDHeader); got != "request-42" {
+			t.Errorf("got %v want %v", got, "request-42")
+		}
+		want := map[string]interface{}{
+			"level": "info", "msg": "request", "request_id": "request-42",
+			"method": "POST", "path": "/sum", "status": 200.0,
+			"shape": "2x2", "input_bytes": 7.0, "output_bytes": 2.0,
+		}
+		for key, value := range want {
+			if line[key] != value {
+				t.Errorf("got %v=%v want %v", key, line[key], value)
+			}
+		}
+		if _, ok := line["latency_ms"].(float64); !ok {
+			t.Errorf("got latency_ms=%v want a number", line["latency_ms"])
+		}
+	})
+
+	t.Run("generated request ID", func(t *testing.T) {
+		for _, id := range []string{"", "invalid id", string(bytes.Repeat([]byte("a"), 129))} {
+			r := newUploadRequest(t, "/sum", "1,a")
+			if id != "" {
+				r.Header.Set(RequestIDHeader, id)
+			}
+			w, line := serve(t, r)
+
+			generated := w.Header().Get(RequestIDHeader)
+			if len(generated) != 32 || line["request_id"] != generated {
+				t.Errorf("got request ID %q logged as %v want 32 hex characters", generated, line["request_id"])
+			}
+
+			var body struct {
+				RequestID string `json:"request_id"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.RequestID != generated {
+				t.Errorf("got %v want %v", body.RequestID, generated)
+			}
+			if line["status"] != 400.0 || line["error_code"] != "CELL_NOT_NUMERIC" {
+				t.Errorf("got status=%v error_code=%v want 400 CELL_NOT_NUMERIC", line["status"], line["error_code"])
+			}
+		}
+	})
+}
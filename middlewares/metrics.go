@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	metrics "takehome/metrics"
+)
+
+// UnmatchedOperation labels the requests whose path matches no route, so
+// unknown paths share a single series.
+const UnmatchedOperation = "unmatched"
+
+var (
+	requests = metrics.Default.NewCounterVec("matrix_http_requests_total",
+		"Requests served, by operation and status code.", "operation", "status")
+	requestDuration = metrics.Default.NewHistogramVec("matrix_http_request_duration_seconds",
+		"Time spent serving requests, by operation and status code.", metrics.DefaultBuckets, "operation", "status")
+	requestsInFlight = metrics.Default.NewGaugeVec("matrix_http_requests_in_flight",
+		"Requests being served, by operation.", "operation")
+)
+
+// MetricsMiddleware records the request metrics of every response of the
+// router it wraps, labeled by the pattern of the route given by route, so
+// the requests rejected by the router or by the middlewares of the route,
+// e.g. 404, 401 or 429, are counted as well as those served.
+type MetricsMiddleware struct {
+	handler http.Handler
+	route   func(*http.Request) string
+}
+
+func (mm *MetricsMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := mm.route(r)
+	if name == "" {
+		name = UnmatchedOperation
+	}
+	requestsInFlight.Add(1, name)
+	defer requestsInFlight.Add(-1, name)
+
+	start := time.Now()
+	recorder := NewStatusRecorder(w)
+	mm.handler.ServeHTTP(recorder, r)
+
+	status := strconv.Itoa(recorder.Status())
+	requests.Inc(name, status)
+	requestDuration.Observe(time.Since(start).Seconds(), name, status)
+}
+
+func NewMetricsMiddleware(handlerToWrap http.Handler, route func(*http.Request) string) *MetricsMiddleware {
+	return &MetricsMiddleware{handlerToWrap, route}
+}
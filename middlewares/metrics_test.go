@@ -0,0 +1,62 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metrics "takehome/metrics"
+	router "takehome/router"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	var inFlight bytes.Buffer
+	rt := router.New()
+	// The routes use patterns no other test uses.
+	rt.Handle("POST", "/metrics-test/{id}/sum", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Reset()
+		metrics.Default.WriteTo(&inFlight)
+	}), func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	handler := NewMetricsMiddleware(rt, rt.Route)
+	serve := func(method, target string, authorized bool) {
+		r := httptest.NewRequest(method, target, nil)
+		if authorized {
+			r.Header.Set("Authorization", "Bearer key")
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	serve("POST", "/metrics-test/1/sum", true)
+	serve("POST", "/metrics-test/2/sum", true)
+	serve("POST", "/metrics-test/1/sum", false)
+	serve("GET", "/metrics-test/1/sum", true)
+	serve("POST", "/metrics-test/unknown", true)
+
+	var exposition bytes.Buffer
+	metrics.Default.WriteTo(&exposition)
+	for _, want := range []string{
+		`matrix_http_requests_total{operation="/metrics-test/{id}/sum",status="200"} 2` + "\n",
+		`matrix_http_requests_total{operation="/metrics-test/{id}/sum",status="401"} 1` + "\n",
+		`matrix_http_requests_total{operation="/metrics-test/{id}/sum",status="405"} 1` + "\n",
+		`matrix_http_requests_total{operation="unmatched",status="404"} 1` + "\n",
+		`matrix_http_request_duration_seconds_count{operation="/metrics-test/{id}/sum",status="200"} 2` + "\n",
+		`matrix_http_requests_in_flight{operation="/metrics-test/{id}/sum"} 0` + "\n",
+	} {
+		if !strings.Contains(exposition.String(), want) {
+			t.Errorf("metrics %q do not contain %q", exposition.String(), want)
+		}
+	}
+	// The request is in flight while it is served.
+	if want := `matrix_http_requests_in_flight{operation="/metrics-test/{id}/sum"} 1` + "\n"; !strings.Contains(inFlight.String(), want) {
+		t.Errorf("metrics %q do not contain %q", inFlight.String(), want)
+	}
+}
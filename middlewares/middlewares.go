@@ -3,56 +3,265 @@ package middlewares
 import (
 	"context"
 	"encoding/csv"
-	"fmt"
+	"errors"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	e "takehome/errors"
+	logging "takehome/logging"
 	m "takehome/matrix"
+	metrics "takehome/metrics"
+	tracing "takehome/tracing"
 )
 
 type contextKey int
 
-const RequestFileMatrixKey contextKey = 0
+const (
+	RequestFileMatrixKey contextKey = iota
+	// RequestParseDurationKey holds the time.Duration spent reading and
+	// parsing the upload.
+	RequestParseDurationKey
+	// StoredMatrixKey holds the *store.Matrix of the routes operating on a
+	// stored matrix.
+	StoredMatrixKey
+	// BatchKey holds the []*BatchItem of the batch of the request.
+	BatchKey
+	// rateLimitChargeKey holds the *rateLimitCharge of requests allowed
+	// before their upload was parsed.
+	rateLimitChargeKey
+)
+
+var (
+	inputCells = metrics.Default.NewHistogramVec("matrix_input_cells",
+		"Cells of the uploaded matrices.", metrics.ExponentialBuckets(1, 10, 7))
+	inputBytes = metrics.Default.NewHistogramVec("matrix_input_bytes",
+		"Size of the uploaded CSV files.", metrics.ExponentialBuckets(64, 8, 8))
+	parseErrors = metrics.Default.NewCounterVec("matrix_parse_errors_total",
+		"Uploads rejected, by reason: the problem code or the limit exceeded.", "reason")
+)
+
+// Limits bounds the uploaded matrix, a zero field disables its limit.
+type Limits struct {
+	// MaxBodyBytes bounds the request body, answered with 413.
+	MaxBodyBytes int64
+	// MaxRows, MaxCols, MaxCells and MaxCellLength bound the matrix and are
+	// checked while the CSV is parsed, answered with 422.
+	MaxRows       int
+	MaxCols       int
+	MaxCells      int
+	MaxCellLength int
+}
 
 type FileToMatrixMiddleware struct {
 	handler http.Handler
+	limits  Limits
 }
 
 func (ftm *FileToMatrixMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	file, _, err := r.FormFile("file")
+	if ftm.limits.MaxBodyBytes > 0 && r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, ftm.limits.MaxBodyBytes)
+	}
+
+	start := time.Now()
+	body, err := decodedBody(r, ftm.limits.MaxBodyBytes)
 	if err != nil {
-		http.Error(w, `{"error": "File not found."}`, http.StatusBadRequest)
+		problem, ok := bodyError(err)
+		if !ok {
+			problem = e.NewHTTPError(err, e.CodeInvalidCompression, nil)
+		}
+		rejectUpload(w, r, problem)
 		return
 	}
-	defer file.Close()
+	r.Body = body
 
-	records, err := csv.NewReader(file).ReadAll()
+	_, parseSpan := tracing.Start(r.Context(), "multipart parse")
+	part, err := filePart(r, "file")
+	var file io.Reader
+	if err == nil {
+		file, err = decodedPart(part, ftm.limits.MaxBodyBytes)
+	}
+	parseSpan.SetError(err)
+	parseSpan.End()
 	if err != nil {
-		http.Error(w, `{"error": "Incorrect file data."}`, http.StatusBadRequest)
+		problem, ok := bodyError(err)
+		if !ok {
+			problem = e.NewHTTPError(err, e.CodeFileNotFound, nil)
+		}
+		rejectUpload(w, r, problem)
 		return
 	}
 
-	var matrix m.Matrix
-	for _, row := range records {
-		// var introw []int
-		for _, val := range row {
-			_, err := strconv.Atoi(val)
-			if err != nil {
-				http.Error(w, fmt.Sprintf(`{"error": "Item '%s' is not an integer."}`, val), http.StatusBadRequest)
-				return
-			}
-		}
-		matrix.Data = append(matrix.Data, row)
+	_, decodeSpan := tracing.Start(r.Context(), "csv decode")
+	input := &countingReader{reader: file}
+	matrix, err := ftm.readMatrix(input)
+	decodeSpan.SetAttribute("input_bytes", input.count)
+	decodeSpan.SetError(err)
+	decodeSpan.End()
+	logging.Annotate(r.Context(), "input_bytes", input.count)
+	if err != nil {
+		rejectUpload(w, r, err)
+		return
 	}
+	logging.Annotate(r.Context(), "shape", matrix.Shape().String())
+	inputCells.Observe(float64(matrix.Shape().Cells()))
+	inputBytes.Observe(float64(input.count))
+	chargeCells(w, r, matrix.Shape().Cells())
 
-	ctxWithMatrix := context.WithValue(r.Context(), RequestFileMatrixKey, &matrix)
+	ctxWithMatrix := context.WithValue(r.Context(), RequestFileMatrixKey, matrix)
+	ctxWithMatrix = context.WithValue(ctxWithMatrix, RequestParseDurationKey, time.Since(start))
 	rWithMatrix := r.WithContext(ctxWithMatrix)
 
 	ftm.handler.ServeHTTP(w, rWithMatrix)
 }
 
-func NewFileToMatrixMiddleware(handlerToWrap http.Handler) *FileToMatrixMiddleware {
-	return &FileToMatrixMiddleware{handlerToWrap}
+// rejectUpload answers with the problem rejecting the upload and counts it.
+func rejectUpload(w http.ResponseWriter, r *http.Request, problem error) {
+	countRejected(problem)
+	e.WriteResponse(w, r, problem)
+}
+
+// countRejected counts an upload, or an item of a batch, rejected with
+// problem.
+func countRejected(problem error) {
+	reason := "unknown"
+	if httpError, ok := problem.(*e.HTTPError); ok {
+		reason = strings.ToLower(string(httpError.Code))
+		if limit, ok := httpError.Extensions["limit"].(string); ok {
+			reason = limit
+		}
+	}
+	parseErrors.Inc(reason)
+}
+
+// filePart returns the part of the multipart body holding the named file,
+// without buffering the upload in memory or on disk.
+func filePart(r *http.Request, name string) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == name && part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+// readMatrix parses the CSV file one record at a time, so a matrix exceeding
+// the limits is rejected as soon as the limit is reached.
+func (ftm *FileToMatrixMiddleware) readMatrix(file io.Reader) (*m.Matrix, error) {
+	return readMatrix(file, ftm.limits)
+}
+
+func readMatrix(file io.Reader, limits Limits) (*m.Matrix, error) {
+	builder := &matrixBuilder{limits: limits}
+	reader := csv.NewReader(file)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if problem, ok := bodyError(err); ok {
+				return nil, problem
+			}
+			return nil, e.NewHTTPError(err, e.CodeInvalidCSV, nil)
+		}
+		if err := builder.add(row); err != nil {
+			return nil, err
+		}
+	}
+	return builder.matrix()
+}
+
+// matrixBuilder checks the rows of a matrix against the limits as they are
+// read, and collects the cells which are not integers.
+type matrixBuilder struct {
+	limits     Limits
+	data       [][]string
+	cells      int
+	cellErrors []e.Item
+}
+
+// add appends row to the matrix, or returns the limit it exceeds.
+func (mb *matrixBuilder) add(row []string) error {
+	i := len(mb.data)
+	mb.cells += len(row)
+	switch {
+	case mb.limits.MaxRows > 0 && i+1 > mb.limits.MaxRows:
+		return limitError("max_rows", mb.limits.MaxRows)
+	case mb.limits.MaxCols > 0 && len(row) > mb.limits.MaxCols:
+		return limitError("max_cols", mb.limits.MaxCols)
+	case mb.limits.MaxCells > 0 && mb.cells > mb.limits.MaxCells:
+		return limitError("max_cells", mb.limits.MaxCells)
+	}
+
+	for j, val := range row {
+		if mb.limits.MaxCellLength > 0 && len(val) > mb.limits.MaxCellLength {
+			return limitError("max_cell_length", mb.limits.MaxCellLength)
+		}
+		_, err := strconv.Atoi(val)
+		if err != nil {
+			params := e.Params{"row": i + 1, "col": j + 1, "value": val}
+			mb.cellErrors = append(mb.cellErrors, e.Item{Row: i + 1, Col: j + 1, Value: val, Params: params})
+		}
+	}
+	mb.data = append(mb.data, row)
+	return nil
+}
+
+// matrix returns the matrix read, unless cells are not integers.
+func (mb *matrixBuilder) matrix() (*m.Matrix, error) {
+	if len(mb.cellErrors) > 0 {
+		problem := e.NewHTTPError(nil, e.CodeCellNotNumeric, e.Params{"count": len(mb.cellErrors)})
+		return nil, problem.WithItems(mb.cellErrors...)
+	}
+	return &m.Matrix{Data: mb.data}, nil
+}
+
+// bodyError reports whether err comes from reading the upload: the body
+// exceeding the MaxBodyBytes limit, once decompressed too, or corrupt
+// compressed data, and the problem to answer with.
+func bodyError(err error) (*e.HTTPError, bool) {
+	if problem, ok := err.(*e.HTTPError); ok {
+		return problem, true
+	}
+	if problem, ok := PayloadTooLargeError(err); ok {
+		return problem, true
+	}
+	return invalidCompressionError(err)
+}
+
+// PayloadTooLargeError reports whether reading the body failed because it
+// exceeds the MaxBodyBytes limit, and the problem to answer with.
+func PayloadTooLargeError(err error) (*e.HTTPError, bool) {
+	var maxBytesError *http.MaxBytesError
+	if !errors.As(err, &maxBytesError) {
+		return nil, false
+	}
+	params := e.Params{"limit": "max_body_bytes", "max": maxBytesError.Limit}
+	return e.NewHTTPError(err, e.CodePayloadTooLarge, params).
+		With("limit", "max_body_bytes").
+		With("max", maxBytesError.Limit), true
+}
+
+// limitError reports a matrix exceeding the named limit.
+func limitError(limit string, max int) error {
+	return e.NewHTTPError(nil, e.CodeLimitExceeded, e.Params{"limit": limit, "max": max}).
+		With("limit", limit).
+		With("max", max)
+}
+
+func NewFileToMatrixMiddleware(handlerToWrap http.Handler, limits Limits) *FileToMatrixMiddleware {
+	return &FileToMatrixMiddleware{handlerToWrap, limits}
 }
 
 type POSTMethodOnlyMiddleware struct {
@@ -61,7 +270,8 @@ type POSTMethodOnlyMiddleware struct {
 
 func (pmom *POSTMethodOnlyMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Header().Set("Allow", http.MethodPost)
+		e.WriteResponse(w, r, e.NewHTTPError(nil, e.CodeMethodNotAllowed, e.Params{"method": r.Method, "allowed": http.MethodPost}))
 		return
 	}
 
@@ -71,3 +281,15 @@ func (pmom *POSTMethodOnlyMiddleware) ServeHTTP(w http.ResponseWriter, r *http.R
 func NewPOSTMethodOnlyMiddleware(handlerToWrap http.Handler) *POSTMethodOnlyMiddleware {
 	return &POSTMethodOnlyMiddleware{handlerToWrap}
 }
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.count += int64(n)
+	return n, err
+}
@@ -2,13 +2,18 @@ package middlewares
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	e "takehome/errors"
 	m "takehome/matrix"
+	metrics "takehome/metrics"
 )
 
 func TestServeHTTPFile(t *testing.T) {
@@ -17,9 +22,12 @@ func TestServeHTTPFile(t *testing.T) {
 		if val == nil {
 			t.Error("Matrix not provided.")
 		}
+		if _, ok := r.Context().Value(RequestParseDurationKey).(time.Duration); !ok {
+			t.Error("Parse duration not provided.")
+		}
 	})
 
-	handlerToTestFileToMatrixMiddleware := NewFileToMatrixMiddleware(nextHandlerFile)
+	handlerToTestFileToMatrixMiddleware := NewFileToMatrixMiddleware(nextHandlerFile, Limits{})
 
 	t.Run("missing file test", func(t *testing.T) {
 		r := httptest.NewRequest("POST", "/testing", nil)
@@ -30,6 +38,9 @@ func TestServeHTTPFile(t *testing.T) {
 		if w.Code != http.StatusBadRequest {
 			t.Errorf("got %v want %v", w.Code, http.StatusBadRequest)
 		}
+		if got := w.Header().Get("Content-Type"); got != e.ProblemContentType {
+			t.Errorf("got %v want %v", got, e.ProblemContentType)
+		}
 	})
 
 	t.Run("correct file data test", func(t *testing.T) {
@@ -82,7 +93,7 @@ func TestServeHTTPFile(t *testing.T) {
 				t.Error(err)
 			}
 
-			data := "1,2,3\nc,5,6\n7,8,9"
+			data := "1,2,3\nc,5,6\n7,8,d"
 
 			buf := bytes.NewBufferString(data)
 			io.Copy(part, buf)
@@ -96,6 +107,12 @@ func TestServeHTTPFile(t *testing.T) {
 		if w.Code != http.StatusBadRequest {
 			t.Errorf("got %v want %v", w.Code, http.StatusBadRequest)
 		}
+
+		want := `{"type":"/errors#CELL_NOT_NUMERIC","title":"Cell not numeric","status":400,"detail":"2 cell(s) are not integers.","instance":"/testing","code":"CELL_NOT_NUMERIC",` +
+			`"errors":[{"row":2,"col":1,"value":"c","detail":"Row 2, column 1: 'c' is not an integer."},{"row":3,"col":3,"value":"d","detail":"Row 3, column 3: 'd' is not an integer."}]}`
+		if w.Body.String() != want {
+			t.Errorf("got %v want %v", w.Body.String(), want)
+		}
 	})
 
 	t.Run("incorrect row items file data test", func(t *testing.T) {
@@ -140,6 +157,12 @@ func TestServeHTTP(t *testing.T) {
 		if w.Code != http.StatusMethodNotAllowed {
 			t.Errorf("got %v want %v", w.Code, http.StatusMethodNotAllowed)
 		}
+		if got := w.Header().Get("Allow"); got != http.MethodPost {
+			t.Errorf("got %v want %v", got, http.MethodPost)
+		}
+		if got := w.Header().Get("Content-Type"); got != e.ProblemContentType {
+			t.Errorf("got %v want %v", got, e.ProblemContentType)
+		}
 	})
 
 	t.Run("POST method test", func(t *testing.T) {
@@ -153,3 +176,107 @@ func TestServeHTTP(t *testing.T) {
 		}
 	})
 }
+
+// newUploadRequest returns a POST request uploading data as the matrix file.
+func newUploadRequest(t *testing.T, target, data string) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", "matrix.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(part, data)
+	writer.Close()
+
+	r := httptest.NewRequest("POST", target, body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestLimits(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	limits := Limits{
+		MaxBodyBytes:  512,
+		MaxRows:       3,
+		MaxCols:       3,
+		MaxCells:      6,
+		MaxCellLength: 4,
+	}
+	handlerToTestLimits := NewFileToMatrixMiddleware(nextHandler, limits)
+
+	testLimit := func(t *testing.T, data string, wantStatus int, wantLimit string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handlerToTestLimits.ServeHTTP(w, newUploadRequest(t, "/testing", data))
+
+		if w.Code != wantStatus {
+			t.Errorf("got %v want %v", w.Code, wantStatus)
+		}
+		if wantLimit == "" {
+			return
+		}
+		var body struct {
+			Limit string `json:"limit"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Limit != wantLimit {
+			t.Errorf("got %v want %v", body.Limit, wantLimit)
+		}
+	}
+
+	t.Run("within limits", func(t *testing.T) {
+		testLimit(t, "1,2\n3,4\n5,6", http.StatusOK, "")
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		testLimit(t, strings.Repeat("1", 600), http.StatusRequestEntityTooLarge, "max_body_bytes")
+	})
+
+	t.Run("too many rows", func(t *testing.T) {
+		testLimit(t, "1\n2\n3\n4", http.StatusUnprocessableEntity, "max_rows")
+	})
+
+	t.Run("too many columns", func(t *testing.T) {
+		testLimit(t, "1,2,3,4", http.StatusUnprocessableEntity, "max_cols")
+	})
+
+	t.Run("too many cells", func(t *testing.T) {
+		testLimit(t, "1,2,3\n4,5,6\n7,8,9", http.StatusUnprocessableEntity, "max_cells")
+	})
+
+	t.Run("cell too long", func(t *testing.T) {
+		testLimit(t, "1,22222", http.StatusUnprocessableEntity, "max_cell_length")
+	})
+}
+
+func TestUploadMetrics(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handlerToTestUploadMetrics := NewFileToMatrixMiddleware(nextHandler, Limits{MaxRows: 1})
+
+	uploads := []string{"1,2", "1,a", "1,\"2", "1\n2"}
+	for _, data := range uploads {
+		handlerToTestUploadMetrics.ServeHTTP(httptest.NewRecorder(), newUploadRequest(t, "/sum", data))
+	}
+	handlerToTestUploadMetrics.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/sum", nil))
+
+	var out strings.Builder
+	if _, err := metrics.Default.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`matrix_parse_errors_total{reason="cell_not_numeric"}`,
+		`matrix_parse_errors_total{reason="invalid_csv"}`,
+		`matrix_parse_errors_total{reason="max_rows"}`,
+		`matrix_parse_errors_total{reason="file_not_found"}`,
+		`matrix_input_cells_bucket{le="10"}`,
+		`matrix_input_bytes_count`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics %q do not contain %q", out.String(), want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	auth "takehome/auth"
+	e "takehome/errors"
+	logging "takehome/logging"
+	m "takehome/matrix"
+	metrics "takehome/metrics"
+	ratelimit "takehome/ratelimit"
+)
+
+// Rate limit headers, see the IETF RateLimit header fields draft.
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RateLimitPolicyHeader    = "RateLimit-Policy"
+)
+
+var rateLimited = metrics.Default.NewCounterVec("matrix_rate_limited_total",
+	"Requests rejected by the rate limiter, by limit: rate, concurrency or jobs.", "limit")
+
+// RateLimitMiddleware limits the requests of each client, identified by its
+// API key, JWT subject or IP address, so it must run after authentication.
+// A request costs its weight for every started group of cellsPerToken cells
+// of the uploaded matrix, or of every matrix of a batch times its operations.
+// It should run before the upload is parsed, so rejected clients cannot make
+// the server parse it: the request then costs its weight, and the rest of
+// its cost is charged once the upload is parsed. Rejected requests get a 429
+// RATE_LIMITED problem with a Retry-After header, and the state of the
+// bucket of the client is reported in RateLimit-* headers.
+type RateLimitMiddleware struct {
+	handler       http.Handler
+	limiter       *ratelimit.Limiter
+	weight        float64
+	cellsPerToken int
+}
+
+func (rm *RateLimitMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := clientID(r)
+	cells, parsed := 0, true
+	if matrix, ok := r.Context().Value(RequestFileMatrixKey).(*m.Matrix); ok {
+		cells = matrix.Shape().Cells()
+	} else if items, ok := r.Context().Value(BatchKey).([]*BatchItem); ok {
+		cells = batchCells(items)
+	} else {
+		parsed = false
+	}
+	cost := ratelimit.Cost(rm.weight, cells, rm.cellsPerToken)
+	decision, release := rm.limiter.Acquire(client, cost)
+	logging.Annotate(r.Context(), "client", client)
+	setRateLimitHeaders(w.Header(), decision)
+
+	if !decision.Allowed {
+		limit := string(decision.Limit)
+		retryAfter := ceilSeconds(decision.RetryAfter)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		rateLimited.Inc(limit)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		params := e.Params{"limit": limit, "retry_after": retryAfter}
+		e.WriteResponse(w, r, e.NewHTTPError(nil, e.CodeRateLimited, params).
+			With("limit", limit).
+			With("retry_after", retryAfter))
+		return
+	}
+	defer release()
+
+	if !parsed {
+		charge := &rateLimitCharge{rm.limiter, client, rm.weight, rm.cellsPerToken, decision.Limits, cost}
+		r = r.WithContext(context.WithValue(r.Context(), rateLimitChargeKey, charge))
+	}
+	rm.handler.ServeHTTP(w, r)
+}
+
+func NewRateLimitMiddleware(handlerToWrap http.Handler, limiter *ratelimit.Limiter, weight float64, cellsPerToken int) *RateLimitMiddleware {
+	return &RateLimitMiddleware{handlerToWrap, limiter, weight, cellsPerToken}
+}
+
+// rateLimitCharge is the cost of a request allowed before its upload was
+// parsed, paid being the tokens spent so far.
+type rateLimitCharge struct {
+	limiter       *ratelimit.Limiter
+	client        string
+	weight        float64
+	cellsPerToken int
+	limits        ratelimit.Limits
+	paid          float64
+}
+
+// chargeCells charges the cells parsed for r to the bucket of its client,
+// when it was allowed before they were parsed, and reports the bucket.
+func chargeCells(w http.ResponseWriter, r *http.Request, cells int) {
+	charge, ok := r.Context().Value(rateLimitChargeKey).(*rateLimitCharge)
+	if !ok {
+		return
+	}
+	cost := ratelimit.Cost(charge.weight, cells, charge.cellsPerToken)
+	if charge.limits.Rate > 0 {
+		// As in Acquire, the cost is capped to the burst.
+		cost = math.Min(cost, charge.limits.Burst)
+	}
+	if cost <= charge.paid {
+		return
+	}
+	decision := charge.limiter.Charge(charge.client, cost-charge.paid)
+	charge.paid = cost
+	setRateLimitHeaders(w.Header(), decision)
+}
+
+// clientID identifies the client of the request by its API key, JWT subject
+// or IP address, in that order.
+func clientID(r *http.Request) string {
+	if principal := auth.Principal(r.Context()); principal != "" {
+		return principal
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// setRateLimitHeaders reports the bucket of the client, when it is rate
+// limited.
+func setRateLimitHeaders(header http.Header, decision ratelimit.Decision) {
+	limits := decision.Limits
+	if limits.Rate <= 0 {
+		return
+	}
+	burst := int(limits.Burst)
+	header.Set(RateLimitLimitHeader, strconv.Itoa(burst))
+	header.Set(RateLimitRemainingHeader, strconv.Itoa(int(math.Max(decision.Remaining, 0))))
+	header.Set(RateLimitResetHeader, strconv.Itoa(ceilSeconds(decision.Reset)))
+	window := int(math.Ceil(limits.Burst / limits.Rate))
+	header.Set(RateLimitPolicyHeader, fmt.Sprintf("%d;w=%d", burst, window))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
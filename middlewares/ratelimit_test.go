@@ -0,0 +1,146 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	auth "takehome/auth"
+	e "takehome/errors"
+	logging "takehome/logging"
+	m "takehome/matrix"
+	ratelimit "takehome/ratelimit"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger := logging.New(&logs, logging.Info, logging.Text)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	serve := func(limiter *ratelimit.Limiter, r *http.Request) *httptest.ResponseRecorder {
+		logs.Reset()
+		w := httptest.NewRecorder()
+		NewLoggingMiddleware(NewRateLimitMiddleware(ok, limiter, 2, 2), logger).ServeHTTP(w, r)
+		return w
+	}
+	request := func(ctx context.Context) *http.Request {
+		r := httptest.NewRequest("POST", "/sum", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		return r.WithContext(ctx)
+	}
+
+	t.Run("headers", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(ratelimit.Limits{Rate: 1, Burst: 10}, nil)
+		w := serve(limiter, request(context.Background()))
+		if w.Code != http.StatusOK {
+			t.Errorf("got %v want %v", w.Code, http.StatusOK)
+		}
+		want := map[string]string{
+			RateLimitLimitHeader:     "10",
+			RateLimitRemainingHeader: "8",
+			RateLimitResetHeader:     "2",
+			RateLimitPolicyHeader:    "10;w=10",
+		}
+		for header, value := range want {
+			if got := w.Header().Get(header); got != value {
+				t.Errorf("%v: got %v want %v", header, got, value)
+			}
+		}
+		if !strings.Contains(logs.String(), "client=ip:192.0.2.1") {
+			t.Errorf("got log %q want the client", logs.String())
+		}
+	})
+
+	t.Run("cost by cells", func(t *testing.T) {
+		// 4 cells cost twice the weight with 2 cells per token.
+		limiter := ratelimit.NewLimiter(ratelimit.Limits{Rate: 1, Burst: 10}, nil)
+		matrix := &m.Matrix{Data: [][]string{{"1", "2"}, {"3", "4"}}}
+		w := serve(limiter, request(context.WithValue(context.Background(), RequestFileMatrixKey, matrix)))
+		if got := w.Header().Get(RateLimitRemainingHeader); got != "6" {
+			t.Errorf("got %v want 6", got)
+		}
+	})
+
+	t.Run("charged once parsed", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(ratelimit.Limits{Rate: 1, Burst: 10}, nil)
+		var parsed int
+		handler := NewRateLimitMiddleware(NewFileToMatrixMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parsed++
+		}), Limits{}), limiter, 2, 2)
+		upload := func() *httptest.ResponseRecorder {
+			r := newUploadRequest(t, "/sum", "1,2\n3,4\n")
+			r.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w
+		}
+
+		// 4 cells cost twice the weight, the second half charged once parsed.
+		if w := upload(); w.Code != http.StatusOK || w.Header().Get(RateLimitRemainingHeader) != "6" {
+			t.Errorf("got %v %v want 6 tokens left", w.Code, w.Header().Get(RateLimitRemainingHeader))
+		}
+		upload()
+		// The third upload takes the bucket to -2, the fourth is rejected
+		// before being parsed.
+		upload()
+		if w := upload(); w.Code != http.StatusTooManyRequests || parsed != 3 {
+			t.Errorf("got %v with %v uploads parsed want %v with 3", w.Code, parsed, http.StatusTooManyRequests)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(ratelimit.Limits{Rate: 0.5, Burst: 3}, nil)
+		serve(limiter, request(context.Background()))
+		w := serve(limiter, request(context.Background()))
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("got %v want %v", w.Code, http.StatusTooManyRequests)
+		}
+		if got := w.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("got %v want 2", got)
+		}
+		var body struct {
+			Code       e.Code `json:"code"`
+			Limit      string `json:"limit"`
+			RetryAfter int    `json:"retry_after"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Code != e.CodeRateLimited || body.Limit != "rate" || body.RetryAfter != 2 {
+			t.Errorf("got %+v want a rate limit problem", body)
+		}
+	})
+
+	t.Run("clients", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(ratelimit.Limits{Rate: 1, Burst: 2}, nil)
+		key := auth.WithKey(context.Background(), &auth.Key{ID: "batch"})
+		claims := auth.WithClaims(context.Background(), &auth.Claims{Subject: "alice"})
+		for _, ctx := range []context.Context{key, claims, context.Background()} {
+			if w := serve(limiter, request(ctx)); w.Code != http.StatusOK {
+				t.Errorf("got %v want each client its own bucket", w.Code)
+			}
+		}
+		if w := serve(limiter, request(key)); w.Code != http.StatusTooManyRequests {
+			t.Errorf("got %v want %v", w.Code, http.StatusTooManyRequests)
+		}
+		if !strings.Contains(logs.String(), "client=key:batch") {
+			t.Errorf("got log %q want the key ID as client", logs.String())
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(ratelimit.Limits{Concurrency: 1}, nil)
+		_, release := limiter.Acquire("ip:192.0.2.1", 1)
+		defer release()
+		w := serve(limiter, request(context.Background()))
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+			t.Errorf("got %v %v want %v", w.Code, w.Header().Get("Retry-After"), http.StatusTooManyRequests)
+		}
+		if w.Header().Get(RateLimitLimitHeader) != "" {
+			t.Errorf("got %v want no rate headers without a rate", w.Header().Get(RateLimitLimitHeader))
+		}
+	})
+}
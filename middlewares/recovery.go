@@ -0,0 +1,122 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	e "takehome/errors"
+	logging "takehome/logging"
+)
+
+// RequestIDHeader carries the identifier of a request across services.
+const RequestIDHeader = "X-Request-ID"
+
+// RecoveryMiddleware turns a panic of the wrapped handler into a 500 problem
+// response carrying an incident ID, which is logged with the stack so the
+// failure can be found from the client report. Once the header was sent,
+// the panic is only logged and the connection aborted, so clients do not
+// take the truncated response for a complete one.
+type RecoveryMiddleware struct {
+	handler http.Handler
+	logger  *logging.Logger
+}
+
+func (rm *RecoveryMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	recorder := &headerRecorder{ResponseWriter: w}
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		if recovered == http.ErrAbortHandler {
+			// Let net/http abort the response silently.
+			panic(recovered)
+		}
+		problem := Recovered(r, recovered, rm.logger)
+		if recorder.wroteHeader {
+			panic(http.ErrAbortHandler)
+		}
+		resetHeader(w.Header())
+		e.WriteResponse(w, r, problem)
+	}()
+
+	rm.handler.ServeHTTP(recorder, r)
+}
+
+func NewRecoveryMiddleware(handlerToWrap http.Handler, logger *logging.Logger) *RecoveryMiddleware {
+	return &RecoveryMiddleware{handlerToWrap, logger}
+}
+
+// Recovered logs the panic recovered while serving r with the stack, and
+// returns the 500 problem answering it, which carries the incident ID of the
+// log line.
+func Recovered(r *http.Request, recovered interface{}, logger *logging.Logger) error {
+	requestID := logging.RequestID(r.Context())
+	if requestID == "" {
+		requestID = r.Header.Get(RequestIDHeader)
+	}
+	incidentID := newID()
+	logger.Error("panic", logging.Fields{
+		"method":      r.Method,
+		"path":        r.URL.Path,
+		"panic":       fmt.Sprint(recovered),
+		"request_id":  requestID,
+		"incident_id": incidentID,
+		"stack":       string(debug.Stack()),
+	})
+	return e.NewHTTPError(nil, e.CodeInternalError, nil).With("incident_id", incidentID)
+}
+
+// resetHeader deletes the headers the handler set for the response it did
+// not send, e.g. ETag or Content-Encoding, keeping the request ID and the
+// CORS headers, which apply to the problem as well.
+func resetHeader(header http.Header) {
+	for name := range header {
+		if name == http.CanonicalHeaderKey(RequestIDHeader) || name == "Vary" || strings.HasPrefix(name, "Access-Control-") {
+			continue
+		}
+		delete(header, name)
+	}
+}
+
+// headerRecorder records whether the header was sent.
+type headerRecorder struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (hr *headerRecorder) WriteHeader(status int) {
+	hr.wroteHeader = true
+	hr.ResponseWriter.WriteHeader(status)
+}
+
+func (hr *headerRecorder) Write(p []byte) (int, error) {
+	hr.wroteHeader = true
+	return hr.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers flush through the recorder.
+func (hr *headerRecorder) Flush() {
+	hr.wroteHeader = true
+	if flusher, ok := hr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (hr *headerRecorder) Unwrap() http.ResponseWriter {
+	return hr.ResponseWriter
+}
+
+// newID returns a random 128-bit identifier in hex.
+func newID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,134 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	e "takehome/errors"
+	logging "takehome/logging"
+	m "takehome/matrix"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	logger := logging.New(&logs, logging.Debug, logging.Text)
+
+	// Inverts the matrix without checking its shape, which panics on an
+	// empty one.
+	invert := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matrix := r.Context().Value(RequestFileMatrixKey).(*m.Matrix)
+		w.Write([]byte(matrix.Invert()))
+	})
+
+	handlerToTestRecoveryMiddleware := NewRecoveryMiddleware(NewFileToMatrixMiddleware(invert, Limits{}), logger)
+
+	t.Run("empty file test", func(t *testing.T) {
+		logs.Reset()
+		r := newUploadRequest(t, "/invert", "")
+		r.Header.Set(RequestIDHeader, "request-42")
+		w := httptest.NewRecorder()
+
+		handlerToTestRecoveryMiddleware.ServeHTTP(w, r)
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("got %v want %v", w.Code, http.StatusInternalServerError)
+		}
+		if got := w.Header().Get("Content-Type"); got != e.ProblemContentType {
+			t.Errorf("got %v want %v", got, e.ProblemContentType)
+		}
+
+		var body struct {
+			Code       e.Code `json:"code"`
+			IncidentID string `json:"incident_id"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Code != e.CodeInternalError {
+			t.Errorf("got %v want %v", body.Code, e.CodeInternalError)
+		}
+		if len(body.IncidentID) != 32 {
+			t.Errorf("got incident ID %q want 32 hex characters", body.IncidentID)
+		}
+
+		for _, want := range []string{"request_id=request-42", "incident_id=" + body.IncidentID, "index out of range", "goroutine"} {
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("log %q does not contain %q", logs.String(), want)
+			}
+		}
+	})
+
+	t.Run("correct file test", func(t *testing.T) {
+		logs.Reset()
+		r := newUploadRequest(t, "/invert", "1,2\n3,4")
+		w := httptest.NewRecorder()
+
+		handlerToTestRecoveryMiddleware.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("got %v want %v", w.Code, http.StatusOK)
+		}
+		if logs.Len() != 0 {
+			t.Errorf("got log %q want none", logs.String())
+		}
+	})
+
+	t.Run("header test", func(t *testing.T) {
+		cached := NewRecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set(RequestIDHeader, "request-42")
+			header.Set("Access-Control-Allow-Origin", "*")
+			header.Set("ETag", `"abc"`)
+			header.Set("Content-Encoding", "gzip")
+			panic("cache miss")
+		}), logger)
+		w := httptest.NewRecorder()
+
+		cached.ServeHTTP(w, httptest.NewRequest("GET", "/sum", nil))
+
+		header := w.Header()
+		if header.Get("ETag") != "" || header.Get("Content-Encoding") != "" {
+			t.Errorf("got %v want the headers of the handler deleted", header)
+		}
+		if header.Get(RequestIDHeader) != "request-42" || header.Get("Access-Control-Allow-Origin") != "*" ||
+			header.Get("Content-Type") != e.ProblemContentType {
+			t.Errorf("got %v want the request ID, CORS and problem headers", header)
+		}
+	})
+
+	t.Run("partial response test", func(t *testing.T) {
+		logs.Reset()
+		partial := NewRecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("1,2\n"))
+			panic("row missing")
+		}), logger)
+		w := httptest.NewRecorder()
+
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Errorf("got %v want %v", recovered, http.ErrAbortHandler)
+			}
+			if w.Body.String() != "1,2\n" || !strings.Contains(logs.String(), "panic=\"row missing\"") {
+				t.Errorf("got %q and log %q want the partial response and the panic logged", w.Body.String(), logs.String())
+			}
+		}()
+		partial.ServeHTTP(w, httptest.NewRequest("GET", "/flatten", nil))
+	})
+
+	t.Run("aborted handler test", func(t *testing.T) {
+		abort := NewRecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}), logger)
+
+		defer func() {
+			if recovered := recover(); recovered != http.ErrAbortHandler {
+				t.Errorf("got %v want %v", recovered, http.ErrAbortHandler)
+			}
+		}()
+		abort.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/invert", nil))
+	})
+}
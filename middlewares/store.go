@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+
+	auth "takehome/auth"
+	e "takehome/errors"
+	logging "takehome/logging"
+	m "takehome/matrix"
+	router "takehome/router"
+	store "takehome/store"
+	tracing "takehome/tracing"
+)
+
+// StoredMatrixMiddleware loads the stored matrix named by the id parameter
+// of the route, e.g. /matrices/{id}/sum, in place of an upload, so the
+// handlers and middlewares of uploads serve stored matrices as is. Matrices
+// of another client get the same 404 problem as unknown IDs.
+type StoredMatrixMiddleware struct {
+	handler http.Handler
+	store   store.Store
+}
+
+func (smm *StoredMatrixMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := router.Param(r, "id")
+	_, span := tracing.Start(r.Context(), "matrix load")
+	stored, err := smm.store.Get(id)
+	span.SetError(err)
+	span.End()
+	logging.Annotate(r.Context(), "matrix_id", id)
+	if err == store.ErrNotFound || (err == nil && !owns(r, stored)) {
+		e.WriteResponse(w, r, MatrixNotFoundError(id))
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("matrix store error", logging.Fields{
+			"request_id": logging.RequestID(r.Context()),
+			"matrix_id":  id,
+			"error":      err,
+		})
+		e.WriteResponse(w, r, err)
+		return
+	}
+	logging.Annotate(r.Context(), "shape", stored.Shape.String())
+
+	ctx := context.WithValue(r.Context(), StoredMatrixKey, stored)
+	ctx = context.WithValue(ctx, RequestFileMatrixKey, &m.Matrix{Data: stored.Data})
+	smm.handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func NewStoredMatrixMiddleware(handlerToWrap http.Handler, store store.Store) *StoredMatrixMiddleware {
+	return &StoredMatrixMiddleware{handlerToWrap, store}
+}
+
+// owns reports whether the client of r may use the stored matrix. Matrices
+// stored anonymously, without authentication, are shared by anonymous
+// clients only, authenticated ones always having a principal.
+func owns(r *http.Request, stored *store.Matrix) bool {
+	return stored.Owner == auth.Principal(r.Context())
+}
+
+// MatrixNotFoundError reports that no matrix id is stored for the client.
+func MatrixNotFoundError(id string) error {
+	return e.NewHTTPError(nil, e.CodeMatrixNotFound, e.Params{"id": id}).With("id", id)
+}
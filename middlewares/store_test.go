@@ -0,0 +1,81 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	auth "takehome/auth"
+	m "takehome/matrix"
+	router "takehome/router"
+	store "takehome/store"
+)
+
+// failingStore fails every operation.
+type failingStore struct{}
+
+var errDiskFull = errors.New("disk full")
+
+func (failingStore) Save(*store.Matrix) error                      { return errDiskFull }
+func (failingStore) Get(string) (*store.Matrix, error)             { return nil, errDiskFull }
+func (failingStore) GetVersion(string, int) (*store.Matrix, error) { return nil, errDiskFull }
+func (failingStore) Versions(string) ([]*store.Matrix, error)      { return nil, errDiskFull }
+func (failingStore) Delete(string) error                           { return errDiskFull }
+
+func TestStoredMatrixMiddleware(t *testing.T) {
+	matrices := store.NewMemoryStore()
+	owned := &store.Matrix{ID: "0123456789abcdef0123456789abcdef", Version: 1, Owner: "key:ci", Data: [][]string{{"1"}}}
+	shared := &store.Matrix{ID: "fedcba9876543210fedcba9876543210", Version: 1, Data: [][]string{{"2"}}}
+	matrices.Save(owned)
+	matrices.Save(shared)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		matrix := r.Context().Value(RequestFileMatrixKey).(*m.Matrix)
+		stored := r.Context().Value(StoredMatrixKey).(*store.Matrix)
+		w.Write([]byte(stored.ID[:4] + " " + matrix.Data[0][0]))
+	})
+	serve := func(s store.Store, id, keyID string) *httptest.ResponseRecorder {
+		rt := router.New()
+		rt.Handle("POST", "/matrices/{id}/sum", NewStoredMatrixMiddleware(next, s))
+		r := httptest.NewRequest("POST", "/matrices/"+id+"/sum", nil)
+		if keyID != "" {
+			r = r.WithContext(auth.WithKey(r.Context(), &auth.Key{ID: keyID}))
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		store  store.Store
+		id     string
+		keyID  string
+		status int
+		body   string
+	}{
+		{"owner", matrices, owned.ID, "ci", http.StatusOK, "0123 1"},
+		{"other client", matrices, owned.ID, "dashboard", http.StatusNotFound, ""},
+		{"anonymous", matrices, owned.ID, "", http.StatusNotFound, ""},
+		{"shared", matrices, shared.ID, "", http.StatusOK, "fedc 2"},
+		{"shared with authentication", matrices, shared.ID, "dashboard", http.StatusNotFound, ""},
+		{"unknown", matrices, "unknown", "ci", http.StatusNotFound, ""},
+		{"store error", failingStore{}, owned.ID, "ci", http.StatusInternalServerError, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serve(test.store, test.id, test.keyID)
+			if w.Code != test.status {
+				t.Errorf("got %v want %v", w.Code, test.status)
+			}
+			if test.body != "" && w.Body.String() != test.body {
+				t.Errorf("got %v want %v", w.Body.String(), test.body)
+			}
+			if test.status == http.StatusNotFound && !strings.Contains(w.Body.String(), `"code":"MATRIX_NOT_FOUND"`) {
+				t.Errorf("got %v want a MATRIX_NOT_FOUND problem", w.Body.String())
+			}
+		})
+	}
+}
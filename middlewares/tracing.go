@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+
+	logging "takehome/logging"
+	tracing "takehome/tracing"
+)
+
+// TracingMiddleware starts the server span of each request, continuing the
+// trace propagated by the traceparent and tracestate headers. Handlers start
+// child spans with tracing.Start.
+type TracingMiddleware struct {
+	handler http.Handler
+	tracer  *tracing.Tracer
+}
+
+func (tm *TracingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remote, _ := tracing.Extract(r.Header)
+	ctx, span := tm.tracer.StartServer(r.Context(), r.Method, remote)
+	defer span.End()
+
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.Path)
+	if id := logging.RequestID(ctx); id != "" {
+		span.SetAttribute("request_id", id)
+	}
+	logging.Annotate(ctx, "trace_id", span.Context().TraceID.String())
+
+	recorder := NewStatusRecorder(w)
+	tm.handler.ServeHTTP(recorder, r.WithContext(ctx))
+
+	span.SetAttribute("http.status_code", recorder.Status())
+	if recorder.Status() >= http.StatusInternalServerError {
+		span.SetError(fmt.Errorf("%d %s", recorder.Status(), http.StatusText(recorder.Status())))
+	}
+}
+
+func NewTracingMiddleware(handlerToWrap http.Handler, tracer *tracing.Tracer) *TracingMiddleware {
+	return &TracingMiddleware{handlerToWrap, tracer}
+}
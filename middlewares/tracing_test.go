@@ -0,0 +1,92 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	tracing "takehome/tracing"
+)
+
+type recordingExporter struct {
+	mu     sync.Mutex
+	traces [][]tracing.SpanData
+}
+
+func (re *recordingExporter) Export(ctx context.Context, spans []tracing.SpanData) error {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.traces = append(re.traces, spans)
+	return nil
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := tracing.NewTracer(exporter, nil)
+
+	status := http.StatusOK
+	operation := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "/sum")
+		defer span.End()
+		w.WriteHeader(status)
+	})
+	handlerToTestTracingMiddleware := NewTracingMiddleware(NewFileToMatrixMiddleware(operation, Limits{}), tracer)
+
+	r := newUploadRequest(t, "/sum", "1,2\n3,4")
+	r.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handlerToTestTracingMiddleware.ServeHTTP(httptest.NewRecorder(), r)
+
+	// Uppercase hex is invalid, starting a new trace.
+	status = http.StatusInternalServerError
+	r = newUploadRequest(t, "/sum", "1")
+	r.Header.Set(tracing.TraceparentHeader, "00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01")
+	handlerToTestTracingMiddleware.ServeHTTP(httptest.NewRecorder(), r)
+
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(exporter.traces) != 2 {
+		t.Fatalf("got %d traces want 2", len(exporter.traces))
+	}
+
+	t.Run("continued trace", func(t *testing.T) {
+		spans := exporter.traces[0]
+		names := map[string]tracing.SpanData{}
+		for _, span := range spans {
+			names[span.Name] = span
+		}
+		server := spans[len(spans)-1]
+		if server.Kind != tracing.KindServer || server.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID.String() != "00f067aa0ba902b7" {
+			t.Errorf("got %+v want the server span continuing the remote trace", server)
+		}
+		if server.Attributes["http.status_code"] != http.StatusOK || server.Error != "" {
+			t.Errorf("got %+v want a successful server span", server)
+		}
+		for _, name := range []string{"multipart parse", "csv decode", "/sum"} {
+			span, ok := names[name]
+			if !ok {
+				t.Errorf("got %v want a %q span", spans, name)
+				continue
+			}
+			if span.TraceID != server.TraceID || span.ParentSpanID != server.SpanID {
+				t.Errorf("got %+v want a child of the server span", span)
+			}
+		}
+		if got := names["csv decode"].Attributes["input_bytes"]; got != int64(7) {
+			t.Errorf("got %v want %v", got, 7)
+		}
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		spans := exporter.traces[1]
+		server := spans[len(spans)-1]
+		if server.ParentSpanID.IsValid() || server.TraceID.String() == "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("got trace %v, parent %v want a new trace", server.TraceID, server.ParentSpanID)
+		}
+		if server.Error == "" {
+			t.Errorf("got %+v want a failed server span", server)
+		}
+	})
+}
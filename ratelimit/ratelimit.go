@@ -0,0 +1,310 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	reload "takehome/reload"
+)
+
+// Limits bounds the requests of a client, a zero field disabling its limit.
+type Limits struct {
+	// Rate is the number of tokens added to the bucket of the client every
+	// second, up to Burst. Requests spend their cost in tokens. A zero Burst
+	// holds a second of tokens, at least one.
+	Rate  float64
+	Burst float64
+	// Concurrency bounds the requests of the client being served at once.
+	Concurrency int
+}
+
+// Limit names the limit rejecting a request.
+type Limit string
+
+const (
+	LimitRate        Limit = "rate"
+	LimitConcurrency Limit = "concurrency"
+)
+
+// Decision is the outcome of Acquire, with the state of the bucket of the
+// client to report in response headers.
+type Decision struct {
+	Allowed bool
+	// Limit is the limit rejecting the request, "" when it is allowed.
+	Limit Limit
+	// Limits are the limits of the client.
+	Limits Limits
+	// Remaining is the number of tokens left once the request is served.
+	Remaining float64
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time to wait before the request may be allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter applies Limits to clients, identified by a string, e.g. an API key
+// ID. It is safe for concurrent use.
+type Limiter struct {
+	defaults  Limits
+	overrides *Overrides
+	now       func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+type client struct {
+	tokens   float64
+	updated  time.Time
+	inFlight int
+}
+
+// sweepInterval is how often idle clients are forgotten.
+const sweepInterval = time.Minute
+
+// NewLimiter returns a limiter applying defaults, or the limits of overrides
+// for the clients it lists. overrides may be nil.
+func NewLimiter(defaults Limits, overrides *Overrides) *Limiter {
+	return &Limiter{
+		defaults:  defaults,
+		overrides: overrides,
+		now:       time.Now,
+		clients:   map[string]*client{},
+	}
+}
+
+// LimitsFor returns the limits of the client.
+func (l *Limiter) LimitsFor(id string) Limits {
+	limits := l.overrides.apply(id, l.defaults)
+	if limits.Rate > 0 && limits.Burst == 0 {
+		limits.Burst = math.Max(limits.Rate, 1)
+	}
+	return limits
+}
+
+// Acquire spends cost tokens of the client and counts the request in flight
+// when the limits allow it. Allowed requests must call release once served.
+// Costs above the burst are capped to it, so a request too expensive for the
+// bucket is served once it is full.
+func (l *Limiter) Acquire(id string, cost float64) (decision Decision, release func()) {
+	limits := l.LimitsFor(id)
+	decision.Limits = limits
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	c, ok := l.clients[id]
+	if !ok {
+		c = &client{tokens: limits.Burst, updated: now}
+		l.clients[id] = c
+	}
+	c.refill(limits, now)
+
+	rateLimited := limits.Rate > 0
+	if rateLimited {
+		cost = math.Min(cost, limits.Burst)
+	}
+	switch {
+	case limits.Concurrency > 0 && c.inFlight >= limits.Concurrency:
+		decision.Limit = LimitConcurrency
+		// In-flight requests give no estimate of their end.
+		decision.RetryAfter = time.Second
+	case rateLimited && c.tokens < cost:
+		decision.Limit = LimitRate
+		decision.RetryAfter = seconds((cost - c.tokens) / limits.Rate)
+	default:
+		decision.Allowed = true
+		if rateLimited {
+			c.tokens -= cost
+		}
+		c.inFlight++
+	}
+	if rateLimited {
+		decision.Remaining = c.tokens
+		decision.Reset = seconds((limits.Burst - c.tokens) / limits.Rate)
+	}
+	if !decision.Allowed {
+		return decision, func() {}
+	}
+
+	var once sync.Once
+	return decision, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			c.inFlight--
+		})
+	}
+}
+
+// Charge spends cost more tokens of the client for a request Acquire
+// allowed, once its full cost is known, e.g. once its upload is parsed. The
+// request being served anyway, the bucket may go below zero, delaying the
+// next requests of the client until it paid for it.
+func (l *Limiter) Charge(id string, cost float64) (decision Decision) {
+	limits := l.LimitsFor(id)
+	decision.Limits = limits
+	decision.Allowed = true
+	if limits.Rate <= 0 {
+		return decision
+	}
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[id]
+	if !ok {
+		c = &client{tokens: limits.Burst, updated: now}
+		l.clients[id] = c
+	}
+	c.refill(limits, now)
+	c.tokens -= cost
+	decision.Remaining = c.tokens
+	decision.Reset = seconds((limits.Burst - c.tokens) / limits.Rate)
+	return decision
+}
+
+// refill adds the tokens earned since the last request.
+func (c *client) refill(limits Limits, now time.Time) {
+	if limits.Rate > 0 {
+		c.tokens += now.Sub(c.updated).Seconds() * limits.Rate
+	}
+	c.tokens = math.Min(c.tokens, limits.Burst)
+	c.updated = now
+}
+
+// sweep forgets the clients with a full bucket and no request in flight,
+// as they are not limited.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for id, c := range l.clients {
+		if c.inFlight > 0 {
+			continue
+		}
+		limits := l.LimitsFor(id)
+		c.refill(limits, now)
+		if c.tokens >= limits.Burst {
+			delete(l.clients, id)
+		}
+	}
+}
+
+// seconds converts a number of seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Cost returns the cost of a request of weight on a matrix of cells, the
+// weight being spent for every started group of cellsPerToken cells. A zero
+// cellsPerToken ignores the cells.
+func Cost(weight float64, cells, cellsPerToken int) float64 {
+	groups := 1
+	if cellsPerToken > 0 && cells > cellsPerToken {
+		groups = (cells + cellsPerToken - 1) / cellsPerToken
+	}
+	return weight * float64(groups)
+}
+
+// override is an entry of the overrides file, omitted fields keeping their
+// default.
+type override struct {
+	Rate        *float64 `json:"rate"`
+	Burst       *float64 `json:"burst"`
+	Concurrency *int     `json:"concurrency"`
+}
+
+// overridesFile is the format of the overrides file.
+type overridesFile struct {
+	Clients map[string]override `json:"clients"`
+}
+
+// Overrides holds the limits of specific clients, read from a file which
+// Watch reloads when it changes. It is safe for concurrent use.
+type Overrides struct {
+	file *reload.File
+
+	mu       sync.RWMutex
+	byClient map[string]override
+}
+
+// LoadOverrides loads the overrides of the JSON file at path, by client,
+// e.g.
+//
+//	{"clients": {"key:batch": {"rate": 100, "burst": 1000, "concurrency": 8}}}
+func LoadOverrides(path string) (*Overrides, error) {
+	o := &Overrides{file: reload.NewFile(path)}
+	if _, err := o.Reload(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Reload reads the overrides file again if it changed since it was last
+// read, and reports whether it did. On error the previous overrides are
+// kept.
+func (o *Overrides) Reload() (bool, error) {
+	return o.file.Reload(func(data []byte) error {
+		byClient, err := parseOverrides(data)
+		if err != nil {
+			return err
+		}
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.byClient = byClient
+		return nil
+	})
+}
+
+func parseOverrides(data []byte) (map[string]override, error) {
+	var file overridesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	byClient := map[string]override{}
+	for id, o := range file.Clients {
+		if (o.Rate != nil && *o.Rate < 0) || (o.Burst != nil && *o.Burst < 0) || (o.Concurrency != nil && *o.Concurrency < 0) {
+			return nil, fmt.Errorf("Limits of client '%s' must not be negative.", id)
+		}
+		byClient[id] = o
+	}
+	return byClient, nil
+}
+
+// apply returns the limits of the client, defaults overridden by its entry.
+func (o *Overrides) apply(id string, defaults Limits) Limits {
+	if o == nil {
+		return defaults
+	}
+	o.mu.RLock()
+	entry, ok := o.byClient[id]
+	o.mu.RUnlock()
+	if !ok {
+		return defaults
+	}
+	limits := defaults
+	if entry.Rate != nil {
+		limits.Rate = *entry.Rate
+	}
+	if entry.Burst != nil {
+		limits.Burst = *entry.Burst
+	}
+	if entry.Concurrency != nil {
+		limits.Concurrency = *entry.Concurrency
+	}
+	return limits
+}
+
+// Watch reloads the overrides file every interval until ctx is done,
+// reporting reloads and errors to onReload.
+func (o *Overrides) Watch(ctx context.Context, interval time.Duration, onReload func(err error)) {
+	reload.Watch(ctx, interval, o.Reload, onReload)
+}
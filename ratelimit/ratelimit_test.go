@@ -0,0 +1,179 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	newLimiter := func(limits Limits) *Limiter {
+		l := NewLimiter(limits, nil)
+		l.now = func() time.Time { return now }
+		return l
+	}
+
+	t.Run("rate", func(t *testing.T) {
+		l := newLimiter(Limits{Rate: 2, Burst: 4})
+		for i := 0; i < 2; i++ {
+			decision, release := l.Acquire("a", 2)
+			release()
+			if !decision.Allowed || decision.Remaining != float64(2-2*i) {
+				t.Errorf("request %d: got %+v want allowed", i, decision)
+			}
+		}
+		decision, _ := l.Acquire("a", 2)
+		if decision.Allowed || decision.Limit != LimitRate || decision.RetryAfter != time.Second || decision.Reset != 2*time.Second {
+			t.Errorf("got %+v want rate limited for a second", decision)
+		}
+		if decision, _ := l.Acquire("b", 2); !decision.Allowed {
+			t.Errorf("got %+v want other clients allowed", decision)
+		}
+
+		now = now.Add(time.Second)
+		if decision, _ := l.Acquire("a", 2); !decision.Allowed {
+			t.Errorf("got %+v want allowed once refilled", decision)
+		}
+	})
+
+	t.Run("cost above burst", func(t *testing.T) {
+		l := newLimiter(Limits{Rate: 1, Burst: 5})
+		if decision, _ := l.Acquire("a", 50); !decision.Allowed || decision.Remaining != 0 {
+			t.Errorf("got %+v want allowed with a full bucket", decision)
+		}
+		if decision, _ := l.Acquire("a", 50); decision.Allowed || decision.RetryAfter != 5*time.Second {
+			t.Errorf("got %+v want retry once full", decision)
+		}
+	})
+
+	t.Run("charge", func(t *testing.T) {
+		l := newLimiter(Limits{Rate: 1, Burst: 4})
+		_, release := l.Acquire("a", 2)
+		release()
+		if decision := l.Charge("a", 4); decision.Remaining != -2 || decision.Reset != 6*time.Second {
+			t.Errorf("got %+v want the bucket below zero", decision)
+		}
+		if decision, _ := l.Acquire("a", 1); decision.Allowed || decision.RetryAfter != 3*time.Second {
+			t.Errorf("got %+v want retry once paid", decision)
+		}
+		if decision := newLimiter(Limits{Concurrency: 1}).Charge("a", 4); !decision.Allowed || decision.Remaining != 0 {
+			t.Errorf("got %+v want nothing charged without a rate", decision)
+		}
+	})
+
+	t.Run("default burst", func(t *testing.T) {
+		if got := newLimiter(Limits{Rate: 0.5}).LimitsFor("a").Burst; got != 1 {
+			t.Errorf("got %v want 1", got)
+		}
+		if got := newLimiter(Limits{Rate: 10}).LimitsFor("a").Burst; got != 10 {
+			t.Errorf("got %v want 10", got)
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		l := newLimiter(Limits{Concurrency: 1})
+		decision, release := l.Acquire("a", 1)
+		if !decision.Allowed {
+			t.Fatalf("got %+v want allowed", decision)
+		}
+		if decision, _ := l.Acquire("a", 1); decision.Allowed || decision.Limit != LimitConcurrency {
+			t.Errorf("got %+v want concurrency limited", decision)
+		}
+		release()
+		release()
+		if decision, _ := l.Acquire("a", 1); !decision.Allowed {
+			t.Errorf("got %+v want allowed once released", decision)
+		}
+		if decision, _ := l.Acquire("a", 1); decision.Allowed {
+			t.Errorf("got %+v want a second release to do nothing", decision)
+		}
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		l := newLimiter(Limits{Rate: 1, Burst: 1})
+		l.Acquire("a", 1)
+		_, release := l.Acquire("b", 1)
+		release()
+		now = now.Add(time.Hour)
+		l.Acquire("c", 1)
+		// a has a request in flight, b is idle with a full bucket.
+		if _, ok := l.clients["a"]; !ok || len(l.clients) != 2 {
+			t.Errorf("got %v clients want a and c", len(l.clients))
+		}
+	})
+}
+
+func TestCost(t *testing.T) {
+	tests := []struct {
+		weight        float64
+		cells         int
+		cellsPerToken int
+		want          float64
+	}{
+		{1, 0, 100, 1},
+		{1, 100, 100, 1},
+		{1, 101, 100, 2},
+		{2, 1000, 100, 20},
+		{2, 1000, 0, 2},
+	}
+	for _, test := range tests {
+		if got := Cost(test.weight, test.cells, test.cellsPerToken); got != test.want {
+			t.Errorf("Cost(%v, %v, %v): got %v want %v", test.weight, test.cells, test.cellsPerToken, got, test.want)
+		}
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	write(`{"clients": {"key:batch": {"rate": 100, "concurrency": 0}}}`, start)
+
+	overrides, err := LoadOverrides(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLimiter(Limits{Rate: 1, Burst: 10, Concurrency: 2}, overrides)
+
+	if got, want := l.LimitsFor("key:batch"), (Limits{Rate: 100, Burst: 10}); got != want {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	if got, want := l.LimitsFor("key:other"), (Limits{Rate: 1, Burst: 10, Concurrency: 2}); got != want {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		if reloaded, err := overrides.Reload(); reloaded || err != nil {
+			t.Errorf("got %v, %v want an unchanged file", reloaded, err)
+		}
+
+		write(`{"clients": {"key:batch": {"rate": -1}}}`, start.Add(time.Minute))
+		if reloaded, err := overrides.Reload(); reloaded || err == nil {
+			t.Errorf("got %v, %v want an invalid file", reloaded, err)
+		}
+		if got := l.LimitsFor("key:batch").Rate; got != 100 {
+			t.Errorf("got %v want the previous overrides", got)
+		}
+
+		write(`{"clients": {"sub:alice": {"burst": 50}}}`, start.Add(2*time.Minute))
+		if reloaded, err := overrides.Reload(); !reloaded || err != nil {
+			t.Errorf("got %v, %v want reloaded", reloaded, err)
+		}
+		if got := l.LimitsFor("key:batch").Rate; got != 1 {
+			t.Errorf("got %v want the default", got)
+		}
+		if got := l.LimitsFor("sub:alice").Burst; got != 50 {
+			t.Errorf("got %v want 50", got)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+// Package reload reads configuration files again when they change.
+package reload
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// File is a file whose content is loaded again once its modification time
+// or size changes. It is safe for concurrent use.
+type File struct {
+	path string
+
+	mu      sync.Mutex
+	loaded  bool
+	modTime time.Time
+	size    int64
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Reload passes the content of the file to load if the file changed since
+// it was last loaded, and reports whether it did. When load fails, the
+// file is loaded again on the next call.
+func (f *File) Reload(load func(data []byte) error) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if f.loaded && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return false, err
+	}
+	if err := load(data); err != nil {
+		return false, fmt.Errorf("%s: %v", f.path, err)
+	}
+	f.loaded = true
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return true, nil
+}
+
+// Watch calls reload every interval until ctx is done, reporting reloads
+// and errors to onReload.
+func Watch(ctx context.Context, interval time.Duration, reload func() (bool, error), onReload func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if reloaded, err := reload(); reloaded || err != nil {
+				onReload(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	modTime := time.Now().Add(-time.Hour)
+	write := func(data string, modTime time.Time) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var loaded string
+	load := func(data []byte) error {
+		if string(data) == "invalid" {
+			return errors.New("Invalid file.")
+		}
+		loaded = string(data)
+		return nil
+	}
+
+	write("a", modTime)
+	file := NewFile(path)
+	if reloaded, err := file.Reload(load); !reloaded || err != nil || loaded != "a" {
+		t.Fatalf("got %v, %v, %q want the file loaded", reloaded, err, loaded)
+	}
+	if reloaded, err := file.Reload(load); reloaded || err != nil {
+		t.Errorf("got %v, %v want an unchanged file not loaded", reloaded, err)
+	}
+
+	write("invalid", modTime.Add(time.Minute))
+	if reloaded, err := file.Reload(load); reloaded || err == nil || err.Error() != path+": Invalid file." {
+		t.Errorf("got %v, %v want the load error", reloaded, err)
+	}
+	// A file failing to load is loaded again on the next call.
+	if _, err := file.Reload(load); err == nil || loaded != "a" {
+		t.Errorf("got %v, %q want the load error again and the previous content kept", err, loaded)
+	}
+	write("b", modTime.Add(2*time.Minute))
+	if reloaded, err := file.Reload(load); !reloaded || err != nil || loaded != "b" {
+		t.Errorf("got %v, %v, %q want the changed file loaded", reloaded, err, loaded)
+	}
+
+	os.Remove(path)
+	if _, err := file.Reload(load); err == nil {
+		t.Errorf("got no error want a missing file")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := []error{nil, errors.New("Invalid file.")}
+	reloads := make(chan error, len(results))
+	go Watch(ctx, time.Millisecond, func() (bool, error) {
+		if len(results) == 0 {
+			return false, nil
+		}
+		err := results[0]
+		results = results[1:]
+		return true, err
+	}, func(err error) {
+		reloads <- err
+	})
+
+	for i, want := range []string{"<nil>", "Invalid file."} {
+		select {
+		case err := <-reloads:
+			if got := errorString(err); got != want {
+				t.Errorf("%d: got %v want %v", i, got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d: got no reload", i)
+		}
+	}
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
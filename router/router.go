@@ -0,0 +1,198 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	e "takehome/errors"
+	logging "takehome/logging"
+	tracing "takehome/tracing"
+)
+
+// Middleware wraps a handler, e.g. to decode its input or check access.
+type Middleware func(http.Handler) http.Handler
+
+type contextKey int
+
+const (
+	paramsKey contextKey = iota
+	patternKey
+)
+
+// Router dispatches requests to routes declared with their method and their
+// own middleware stack, on top of an http.ServeMux. Unknown paths get a 404
+// problem and known paths called with another method a 405 problem listing
+// the allowed methods, both without running any route middleware.
+//
+// Patterns are paths whose segments may be parameters, e.g.
+// "/matrices/{id}/sum", read by handlers with Param.
+type Router struct {
+	mux   *http.ServeMux
+	nodes map[string]*node
+}
+
+// node serves every route registered under the same ServeMux pattern.
+type node struct {
+	routes []*route
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  http.Handler
+}
+
+func New() *Router {
+	// The root node answers 404 to every path no other node serves.
+	root := &node{}
+	mux := http.NewServeMux()
+	mux.Handle("/", root)
+	return &Router{
+		mux:   mux,
+		nodes: map[string]*node{"/": root},
+	}
+}
+
+// Handle registers handler for method and pattern, wrapped in middlewares,
+// the first one being the outermost.
+func (rt *Router) Handle(method, pattern string, handler http.Handler, middlewares ...Middleware) {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	// Patterns with parameters are served by the subtree of their static
+	// prefix, e.g. "/matrices/" for "/matrices/{id}/sum".
+	muxPattern := pattern
+	if i := strings.Index(pattern, "{"); i >= 0 {
+		muxPattern = pattern[:strings.LastIndex(pattern[:i], "/")+1]
+	}
+	n, ok := rt.nodes[muxPattern]
+	if !ok {
+		n = &node{}
+		rt.nodes[muxPattern] = n
+		rt.mux.Handle(muxPattern, n)
+	}
+	n.routes = append(n.routes, &route{method, pattern, split(pattern), handler})
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// Route returns the pattern of the route which serves r, or which answers
+// 405 to its method, or "" when its path matches no route. Middlewares
+// wrapping the router use it, as Pattern is only set within routes.
+func (rt *Router) Route(r *http.Request) string {
+	handler, _ := rt.mux.Handler(r)
+	n, ok := handler.(*node)
+	if !ok {
+		return ""
+	}
+	segments := split(r.URL.Path)
+	pattern := ""
+	for _, route := range n.routes {
+		if _, ok := route.match(segments); !ok {
+			continue
+		}
+		if route.method == r.Method || (route.method == http.MethodGet && r.Method == http.MethodHead) {
+			return route.pattern
+		}
+		if pattern == "" {
+			pattern = route.pattern
+		}
+	}
+	return pattern
+}
+
+func (n *node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := split(r.URL.Path)
+	var allowed []string
+	for _, route := range n.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if route.method == r.Method || (route.method == http.MethodGet && r.Method == http.MethodHead) {
+			logging.Annotate(r.Context(), "operation", route.pattern)
+			span := tracing.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + route.pattern)
+			span.SetAttribute("http.route", route.pattern)
+			ctx := context.WithValue(r.Context(), patternKey, route.pattern)
+			if len(params) > 0 {
+				ctx = context.WithValue(ctx, paramsKey, params)
+			}
+			r = r.WithContext(ctx)
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+		allowed = append(allowed, route.method)
+		if route.method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+
+	if len(allowed) == 0 {
+		notFound(w, r)
+		return
+	}
+	sort.Strings(allowed)
+	unique := allowed[:1]
+	for _, method := range allowed[1:] {
+		if method != unique[len(unique)-1] {
+			unique = append(unique, method)
+		}
+	}
+	allow := strings.Join(unique, ", ")
+	w.Header().Set("Allow", allow)
+	e.WriteResponse(w, r, e.NewHTTPError(nil, e.CodeMethodNotAllowed, e.Params{"method": r.Method, "allowed": allow}))
+}
+
+// match reports whether the route matches the path segments and returns the
+// values of its parameters.
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, segment := range rt.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[segment[1:len(segment)-1]] = segments[i]
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func split(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	e.WriteResponse(w, r, e.NewHTTPError(nil, e.CodeNotFound, e.Params{"path": r.URL.Path}))
+}
+
+// Param returns the value of the named pattern parameter of the route
+// serving r, or "" when there is none.
+func Param(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey).(map[string]string)
+	return params[name]
+}
+
+// Pattern returns the pattern of the route serving r, e.g. "/matrices/{id}",
+// or "" when there is none.
+func Pattern(r *http.Request) string {
+	pattern, _ := r.Context().Value(patternKey).(string)
+	return pattern
+}
@@ -0,0 +1,126 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	e "takehome/errors"
+)
+
+func TestRouter(t *testing.T) {
+	var calls []string
+	// trace records the middlewares run, in order.
+	trace := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	echoParam := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(Param(r, name)))
+		})
+	}
+	echoPattern := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Pattern(r)))
+	})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	rt := New()
+	rt.Handle(http.MethodPost, "/sum", ok, trace("outer"), trace("inner"))
+	rt.Handle(http.MethodGet, "/healthz", ok)
+	rt.Handle(http.MethodGet, "/matrices/{id}", echoParam("id"))
+	rt.Handle(http.MethodDelete, "/matrices/{id}", ok)
+	rt.Handle(http.MethodPost, "/matrices/{id}/sum", echoParam("id"), trace("decode"))
+	rt.Handle(http.MethodPost, "/matrices/{id}", echoPattern)
+
+	testRoute := func(t *testing.T, method, target string, wantStatus int, wantBody string) *httptest.ResponseRecorder {
+		t.Helper()
+		calls = nil
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+
+		if w.Code != wantStatus {
+			t.Errorf("got %v want %v", w.Code, wantStatus)
+		}
+		if wantBody != "" && w.Body.String() != wantBody {
+			t.Errorf("got %v want %v", w.Body.String(), wantBody)
+		}
+		return w
+	}
+
+	t.Run("middlewares run outermost first", func(t *testing.T) {
+		testRoute(t, "POST", "/sum", http.StatusOK, "ok")
+		if len(calls) != 2 || calls[0] != "outer" || calls[1] != "inner" {
+			t.Errorf("got %v want [outer inner]", calls)
+		}
+	})
+
+	t.Run("HEAD is served by GET routes", func(t *testing.T) {
+		testRoute(t, "HEAD", "/healthz", http.StatusOK, "")
+	})
+
+	t.Run("pattern parameters", func(t *testing.T) {
+		testRoute(t, "GET", "/matrices/42", http.StatusOK, "42")
+		testRoute(t, "POST", "/matrices/7/sum", http.StatusOK, "7")
+	})
+
+	t.Run("route pattern", func(t *testing.T) {
+		testRoute(t, "POST", "/matrices/42", http.StatusOK, "/matrices/{id}")
+	})
+
+	t.Run("unknown path", func(t *testing.T) {
+		for _, target := range []string{"/", "/unknown", "/sum/extra", "/matrices/42/unknown"} {
+			w := testRoute(t, "POST", target, http.StatusNotFound, "")
+			if got := w.Header().Get("Content-Type"); got != e.ProblemContentType {
+				t.Errorf("got %v want %v", got, e.ProblemContentType)
+			}
+		}
+	})
+
+	t.Run("method mismatch", func(t *testing.T) {
+		w := testRoute(t, "GET", "/sum", http.StatusMethodNotAllowed, "")
+		if got := w.Header().Get("Allow"); got != "POST" {
+			t.Errorf("got %v want %v", got, "POST")
+		}
+		if len(calls) != 0 {
+			t.Errorf("got middlewares %v run want none", calls)
+		}
+
+		w = testRoute(t, "PUT", "/matrices/42", http.StatusMethodNotAllowed, "")
+		if got := w.Header().Get("Allow"); got != "DELETE, GET, HEAD, POST" {
+			t.Errorf("got %v want %v", got, "DELETE, GET, HEAD, POST")
+		}
+
+		testRoute(t, "GET", "/matrices/42/sum", http.StatusMethodNotAllowed, "")
+		if len(calls) != 0 {
+			t.Errorf("got middlewares %v run want none", calls)
+		}
+	})
+
+	t.Run("missing parameter", func(t *testing.T) {
+		testRoute(t, "GET", "/matrices/", http.StatusNotFound, "")
+	})
+
+	t.Run("route of a request", func(t *testing.T) {
+		tests := []struct{ method, target, want string }{
+			{"POST", "/sum", "/sum"},
+			{"HEAD", "/healthz", "/healthz"},
+			{"POST", "/matrices/42", "/matrices/{id}"},
+			{"POST", "/matrices/42/sum", "/matrices/{id}/sum"},
+			{"GET", "/sum", "/sum"},
+			{"POST", "/unknown", ""},
+			{"GET", "/matrices/", ""},
+		}
+		for _, test := range tests {
+			if got := rt.Route(httptest.NewRequest(test.method, test.target, nil)); got != test.want {
+				t.Errorf("%v %v: got %q want %q", test.method, test.target, got, test.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	handlers "takehome/handlers"
+	logging "takehome/logging"
+)
+
+// shutdownPolicy configures how serve stops the server.
+type shutdownPolicy struct {
+	// Delay keeps the server running once shutdown begins, with Readiness
+	// failing, so load balancers stop routing new requests to it.
+	Delay time.Duration
+	// Grace bounds the time given to in-flight requests to complete, then
+	// to Flush.
+	Grace time.Duration
+	// Flush, if set, releases the other resources once the server stopped,
+	// e.g. drains background jobs, until ctx is done.
+	Flush func(ctx context.Context)
+	// Readiness is marked ready while serving and not ready on shutdown.
+	Readiness *handlers.Readiness
+}
+
+// serve runs server on listener until ctx is done, then stops accepting
+// connections and waits for in-flight requests as configured by policy.
+// Flush then gets the rest of the grace period, so the whole shutdown takes
+// at most Delay plus Grace. serve returns the error which stopped the server,
+// or the shutdown error when requests could not be drained in time.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, policy shutdownPolicy, logger *logging.Logger) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+	policy.Readiness.SetReady(true)
+
+	select {
+	case err := <-errs:
+		policy.Readiness.SetReady(false)
+		return err
+	case <-ctx.Done():
+	}
+
+	deadline := time.Now().Add(policy.Delay + policy.Grace)
+	policy.Readiness.SetReady(false)
+	logger.Info("shutting down", logging.Fields{"delay": policy.Delay.String(), "grace": policy.Grace.String()})
+	time.Sleep(policy.Delay)
+
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		// Drop the connections still open after the grace period.
+		server.Close()
+	}
+	if policy.Flush != nil {
+		policy.Flush(shutdownCtx)
+	}
+	return err
+}
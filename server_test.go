@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	handlers "takehome/handlers"
+	logging "takehome/logging"
+)
+
+func TestServe(t *testing.T) {
+	logger := logging.Discard
+
+	// startServer serves a handler which blocks until release is closed and
+	// returns the URL of the server.
+	startServer := func(t *testing.T, ctx context.Context, policy shutdownPolicy, started chan<- struct{}, release <-chan struct{}) (string, <-chan error) {
+		t.Helper()
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			w.Write([]byte("done"))
+		})}
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- serve(ctx, server, listener, policy, logger)
+		}()
+		return "http://" + listener.Addr().String(), errs
+	}
+
+	t.Run("drains in-flight requests", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		readiness := &handlers.Readiness{}
+		policy := shutdownPolicy{Grace: time.Second, Readiness: readiness}
+		started, release := make(chan struct{}), make(chan struct{})
+		url, errs := startServer(t, ctx, policy, started, release)
+
+		responses := make(chan []byte, 1)
+		go func() {
+			resp, err := http.Post(url, "text/plain", nil)
+			if err != nil {
+				t.Error(err)
+				responses <- nil
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			responses <- body
+		}()
+
+		<-started
+		if !readiness.Ready() {
+			t.Error("got not ready while serving want ready")
+		}
+		cancel()
+		// Shutdown waits for the request, which completes once released.
+		time.Sleep(50 * time.Millisecond)
+		if readiness.Ready() {
+			t.Error("got ready while shutting down want not ready")
+		}
+		close(release)
+
+		if err := <-errs; err != nil {
+			t.Errorf("got %v want nil", err)
+		}
+		if body := <-responses; !bytes.Equal(body, []byte("done")) {
+			t.Errorf("got %q want %q", body, "done")
+		}
+	})
+
+	t.Run("serves during shutdown delay", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		readiness := &handlers.Readiness{}
+		policy := shutdownPolicy{Delay: 200 * time.Millisecond, Grace: time.Second, Readiness: readiness}
+		started, release := make(chan struct{}), make(chan struct{})
+		close(release)
+		url, errs := startServer(t, ctx, policy, started, release)
+
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+		if readiness.Ready() {
+			t.Error("got ready while shutting down want not ready")
+		}
+		resp, err := http.Post(url, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("got %v while delaying shutdown want a response", err)
+		}
+		resp.Body.Close()
+
+		if err := <-errs; err != nil {
+			t.Errorf("got %v want nil", err)
+		}
+	})
+
+	t.Run("grace period exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		policy := shutdownPolicy{Grace: 50 * time.Millisecond, Readiness: &handlers.Readiness{}}
+		started, release := make(chan struct{}), make(chan struct{})
+		defer close(release)
+		url, errs := startServer(t, ctx, policy, started, release)
+
+		go http.Post(url, "text/plain", nil)
+
+		<-started
+		cancel()
+
+		if err := <-errs; err != context.DeadlineExceeded {
+			t.Errorf("got %v want %v", err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("flush within the grace period", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var deadline time.Time
+		policy := shutdownPolicy{
+			Delay:     50 * time.Millisecond,
+			Grace:     time.Second,
+			Readiness: &handlers.Readiness{},
+			Flush:     func(ctx context.Context) { deadline, _ = ctx.Deadline() },
+		}
+		started, release := make(chan struct{}), make(chan struct{})
+		url, errs := startServer(t, ctx, policy, started, release)
+
+		go http.Post(url, "text/plain", nil)
+
+		<-started
+		stopping := time.Now()
+		cancel()
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+
+		// Flush shares the deadline of the requests instead of getting
+		// a grace period of its own.
+		if err := <-errs; err != nil {
+			t.Errorf("got %v want nil", err)
+		}
+		if max := stopping.Add(policy.Delay + policy.Grace + 50*time.Millisecond); deadline.IsZero() || deadline.After(max) {
+			t.Errorf("got deadline %v want before %v", deadline, max)
+		}
+	})
+
+	t.Run("listener error", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener.Close()
+
+		policy := shutdownPolicy{Grace: time.Second, Readiness: &handlers.Readiness{}}
+		err = serve(context.Background(), &http.Server{}, listener, policy, logger)
+		if err == nil {
+			t.Error("got nil want an error")
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileStore keeps each matrix as a directory of JSON files, one per version,
+// so matrices survive restarts and may be shared by instances mounting the
+// directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store of the matrices of dir, creating it if
+// needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// Remove the files of saves and deletes interrupted by a crash.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if strings.HasSuffix(info.Name(), ".tmp") {
+			os.RemoveAll(path)
+			continue
+		}
+		if info.IsDir() {
+			removeTemporary(path)
+		}
+	}
+	return &FileStore{dir}, nil
+}
+
+func removeTemporary(dir string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, info.Name()))
+		}
+	}
+}
+
+func (fs *FileStore) path(id string, version int) string {
+	return filepath.Join(fs.dir, id, strconv.Itoa(version)+".json")
+}
+
+func (fs *FileStore) Save(matrix *Matrix) error {
+	if !ValidID(matrix.ID) || matrix.Version < 1 {
+		return ErrConflict
+	}
+	if matrix.Version > 1 {
+		if _, err := os.Stat(fs.path(matrix.ID, matrix.Version-1)); os.IsNotExist(err) {
+			return ErrConflict
+		}
+	}
+	data, err := json.Marshal(matrix)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(fs.dir, matrix.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// Write then link, so readers never see a partial version and a version
+	// saved concurrently is never overwritten.
+	tmp, err := ioutil.TempFile(dir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Link(tmp.Name(), fs.path(matrix.ID, matrix.Version))
+	if os.IsExist(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+// versions returns the version numbers of id, in order.
+func (fs *FileStore) versions(id string) ([]int, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(fs.dir, id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []int
+	for _, info := range infos {
+		name := info.Name()
+		if version, err := strconv.Atoi(strings.TrimSuffix(name, ".json")); err == nil && strings.HasSuffix(name, ".json") {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (fs *FileStore) Get(id string) (*Matrix, error) {
+	versions, err := fs.versions(id)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetVersion(id, versions[len(versions)-1])
+}
+
+func (fs *FileStore) GetVersion(id string, version int) (*Matrix, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(fs.path(id, version))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var matrix Matrix
+	if err := json.Unmarshal(data, &matrix); err != nil {
+		return nil, err
+	}
+	return &matrix, nil
+}
+
+func (fs *FileStore) Versions(id string) ([]*Matrix, error) {
+	versions, err := fs.versions(id)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make([]*Matrix, len(versions))
+	for i, version := range versions {
+		matrix, err := fs.GetVersion(id, version)
+		if err != nil {
+			return nil, err
+		}
+		matrix.Data = nil
+		metadata[i] = matrix
+	}
+	return metadata, nil
+}
+
+func (fs *FileStore) Delete(id string) error {
+	if _, err := fs.versions(id); err != nil {
+		return err
+	}
+	// Rename then remove, so readers never see a partial matrix.
+	dir := filepath.Join(fs.dir, id)
+	err := os.Rename(dir, dir+".tmp")
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir + ".tmp")
+}
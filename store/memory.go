@@ -0,0 +1,70 @@
+package store
+
+import "sync"
+
+// MemoryStore keeps matrices in memory, until the process exits.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	matrices map[string][]*Matrix
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{matrices: map[string][]*Matrix{}}
+}
+
+func (ms *MemoryStore) Save(matrix *Matrix) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	versions := ms.matrices[matrix.ID]
+	if matrix.Version != len(versions)+1 {
+		return ErrConflict
+	}
+	ms.matrices[matrix.ID] = append(versions, clone(matrix))
+	return nil
+}
+
+func (ms *MemoryStore) Get(id string) (*Matrix, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	versions, ok := ms.matrices[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(versions[len(versions)-1]), nil
+}
+
+func (ms *MemoryStore) GetVersion(id string, version int) (*Matrix, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	versions := ms.matrices[id]
+	if version < 1 || version > len(versions) {
+		return nil, ErrNotFound
+	}
+	return clone(versions[version-1]), nil
+}
+
+func (ms *MemoryStore) Versions(id string) ([]*Matrix, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	versions, ok := ms.matrices[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	metadata := make([]*Matrix, len(versions))
+	for i, version := range versions {
+		copied := *version
+		copied.Data = nil
+		metadata[i] = &copied
+	}
+	return metadata, nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.matrices[id]; !ok {
+		return ErrNotFound
+	}
+	delete(ms.matrices, id)
+	return nil
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	m "takehome/matrix"
+)
+
+var (
+	// ErrNotFound is returned for IDs or versions of no stored matrix.
+	ErrNotFound = errors.New("Matrix not found.")
+	// ErrConflict is returned when saving a version which is not the one
+	// following the latest, e.g. as another update saved it first.
+	ErrConflict = errors.New("Matrix version conflict.")
+)
+
+// Matrix is a version of a stored matrix with its metadata. Versions are
+// numbered from 1, each one keeping the whole matrix.
+type Matrix struct {
+	ID        string    `json:"id"`
+	Version   int       `json:"version"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt and UpdatedBy record when and by whom the version was
+	// saved.
+	UpdatedAt time.Time  `json:"updated_at"`
+	UpdatedBy string     `json:"updated_by,omitempty"`
+	Shape     m.Shape    `json:"shape"`
+	Data      [][]string `json:"data,omitempty"`
+}
+
+// Store keeps the versions of matrices by ID. Implementations are safe for
+// concurrent use.
+type Store interface {
+	// Save stores matrix as version matrix.Version of its ID, which must be
+	// 1 for a new ID or follow the latest version, or returns ErrConflict.
+	// Versions are never overwritten.
+	Save(matrix *Matrix) error
+	// Get returns the latest version of id, or ErrNotFound.
+	Get(id string) (*Matrix, error)
+	// GetVersion returns the given version of id, or ErrNotFound.
+	GetVersion(id string, version int) (*Matrix, error)
+	// Versions returns the versions of id without their data, oldest
+	// first, or ErrNotFound.
+	Versions(id string) ([]*Matrix, error)
+	// Delete removes every version of id, or returns ErrNotFound.
+	Delete(id string) error
+}
+
+// NewID returns a random matrix ID of 32 hex digits.
+func NewID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// ValidID reports whether id has the format of NewID, so IDs given by
+// clients are checked before they name files.
+func ValidID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// clone returns a deep copy of matrix, so stored matrices are not modified
+// through the values given to or returned by a store.
+func clone(matrix *Matrix) *Matrix {
+	copied := *matrix
+	copied.Data = make([][]string, len(matrix.Data))
+	for i, row := range matrix.Data {
+		copied.Data[i] = append([]string(nil), row...)
+	}
+	return &copied
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	m "takehome/matrix"
+)
+
+func TestStores(t *testing.T) {
+	dir := t.TempDir()
+	// Saves and deletes interrupted by a crash leave temporary files.
+	os.MkdirAll(filepath.Join(dir, "0123456789abcdef0123456789abcdef"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "0123456789abcdef0123456789abcdef", "1.tmp"), []byte("{"), 0600)
+	os.MkdirAll(filepath.Join(dir, "fedcba9876543210fedcba9876543210.tmp"), 0700)
+	fileStore, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, _ := ioutil.ReadDir(dir)
+	leftover, _ := ioutil.ReadDir(filepath.Join(dir, "0123456789abcdef0123456789abcdef"))
+	if len(infos) != 1 || len(leftover) != 0 {
+		t.Errorf("got %v, %v files want the temporary files removed", len(infos), len(leftover))
+	}
+
+	stores := map[string]Store{"memory": NewMemoryStore(), "file": fileStore}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			id, err := NewID()
+			if err != nil || !ValidID(id) {
+				t.Fatalf("got %v, %v want a valid ID", id, err)
+			}
+			created := time.Unix(1700000000, 0).UTC()
+			matrix := &Matrix{
+				ID:        id,
+				Version:   1,
+				Owner:     "key:ci",
+				CreatedAt: created,
+				UpdatedAt: created,
+				UpdatedBy: "key:ci",
+				Shape:     m.Shape{Rows: 1, Cols: 2},
+				Data:      [][]string{{"1", "2"}},
+			}
+			if err := s.Save(matrix); err != nil {
+				t.Fatal(err)
+			}
+			matrix.Data[0][0] = "9"
+			got, err := s.Get(id)
+			if err != nil || got.Data[0][0] != "1" {
+				t.Fatalf("got %v, %v want the saved matrix", got, err)
+			}
+			got.Data[0][0] = "9"
+			if !reflect.DeepEqual(got, matrix) {
+				t.Errorf("got %+v want %+v", got, matrix)
+			}
+			if got, _ := s.Get(id); got.Data[0][0] != "1" {
+				t.Errorf("got %v want the stored matrix unchanged", got.Data)
+			}
+
+			// Versions are never overwritten, and must follow the latest.
+			for _, version := range []int{1, 3, 0} {
+				matrix.Version = version
+				if err := s.Save(matrix); err != ErrConflict {
+					t.Errorf("version %v: got %v want %v", version, err, ErrConflict)
+				}
+			}
+			matrix.Version = 2
+			matrix.UpdatedAt = created.Add(time.Hour)
+			if err := s.Save(matrix); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := s.Get(id); got.Version != 2 || got.Data[0][0] != "9" {
+				t.Errorf("got %v %v want version 2", got.Version, got.Data)
+			}
+			if got, err := s.GetVersion(id, 1); err != nil || got.Data[0][0] != "1" {
+				t.Errorf("got %v, %v want version 1", got, err)
+			}
+			versions, err := s.Versions(id)
+			if err != nil || len(versions) != 2 {
+				t.Fatalf("got %v, %v want 2 versions", versions, err)
+			}
+			for i, version := range versions {
+				if version.Version != i+1 || version.Data != nil || version.CreatedAt != created {
+					t.Errorf("got %+v want the metadata of version %v", version, i+1)
+				}
+			}
+
+			if err := s.Delete(id); err != nil {
+				t.Fatal(err)
+			}
+			for _, missing := range []string{id, "0123456789abcdef0123456789abcdef", "../store"} {
+				if _, err := s.Get(missing); err != ErrNotFound {
+					t.Errorf("%v: got %v want %v", missing, err, ErrNotFound)
+				}
+				if _, err := s.GetVersion(missing, 1); err != ErrNotFound {
+					t.Errorf("%v: got %v want %v", missing, err, ErrNotFound)
+				}
+				if _, err := s.Versions(missing); err != ErrNotFound {
+					t.Errorf("%v: got %v want %v", missing, err, ErrNotFound)
+				}
+				if err := s.Delete(missing); err != ErrNotFound {
+					t.Errorf("%v: got %v want %v", missing, err, ErrNotFound)
+				}
+			}
+		})
+
+		t.Run(name+" concurrent saves", func(t *testing.T) {
+			id, _ := NewID()
+			s.Save(&Matrix{ID: id, Version: 1, Data: [][]string{{"1"}}})
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			saved := 0
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := s.Save(&Matrix{ID: id, Version: 2, Data: [][]string{{"2"}}}); err == nil {
+						mu.Lock()
+						saved++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if saved != 1 {
+				t.Errorf("got %v saves of version 2 want 1", saved)
+			}
+		})
+	}
+}
+
+func TestValidID(t *testing.T) {
+	tests := map[string]bool{
+		"0123456789abcdef0123456789abcdef":   true,
+		"0123456789abcdef0123456789abcde":    false,
+		"0123456789abcdef0123456789abcdeg":   false,
+		"../../../../../../../../etc/passwd": false,
+		"":                                   false,
+	}
+	for id, want := range tests {
+		if got := ValidID(id); got != want {
+			t.Errorf("%q: got %v want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JSONExporter writes each span as a JSON line, e.g. to stdout.
+type JSONExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewJSONExporter(out io.Writer) *JSONExporter {
+	return &JSONExporter{out: out}
+}
+
+type jsonSpan struct {
+	Name         string                 `json:"name"`
+	Kind         string                 `json:"kind"`
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	DurationMs   float64                `json:"duration_ms"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+func (je *JSONExporter) Export(ctx context.Context, spans []SpanData) error {
+	var lines bytes.Buffer
+	encoder := json.NewEncoder(&lines)
+	for _, span := range spans {
+		line := jsonSpan{
+			Name:       span.Name,
+			Kind:       "internal",
+			TraceID:    span.TraceID.String(),
+			SpanID:     span.SpanID.String(),
+			Start:      span.Start.UTC(),
+			End:        span.End.UTC(),
+			DurationMs: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Attributes: span.Attributes,
+			Error:      span.Error,
+		}
+		if span.Kind == KindServer {
+			line.Kind = "server"
+		}
+		if span.ParentSpanID.IsValid() {
+			line.ParentSpanID = span.ParentSpanID.String()
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	je.mu.Lock()
+	defer je.mu.Unlock()
+	_, err := je.out.Write(lines.Bytes())
+	return err
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector with the OTLP/HTTP
+// JSON encoding, e.g. to "http://localhost:4318/v1/traces".
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+// NewOTLPExporter returns an exporter posting to endpoint, the spans being
+// attributed to serviceName. A nil client uses http.DefaultClient.
+func NewOTLPExporter(endpoint, serviceName string, client *http.Client) *OTLPExporter {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &OTLPExporter{endpoint, serviceName, client}
+}
+
+// OTLP/JSON messages, see opentelemetry-proto. IDs are hex encoded and 64-bit
+// integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// OTLP span kinds, status codes and names.
+const (
+	otlpKindInternal   = 1
+	otlpKindServer     = 2
+	otlpStatusError    = 2
+	otlpScopeName      = "takehome/tracing"
+	otlpServiceNameKey = "service.name"
+)
+
+func (oe *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	request := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{otlpServiceNameKey, otlpAttributeValue(oe.serviceName)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: otlpScopeName}}},
+	}}}
+	scope := &request.ResourceSpans[0].ScopeSpans[0]
+	for _, span := range spans {
+		otlp := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+		}
+		if span.Kind == KindServer {
+			otlp.Kind = otlpKindServer
+		}
+		if span.ParentSpanID.IsValid() {
+			otlp.ParentSpanID = span.ParentSpanID.String()
+		}
+		if span.Error != "" {
+			otlp.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+		scope.Spans = append(scope.Spans, otlp)
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oe.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := oe.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP export to %s failed with status %d.", oe.endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// otlpAttributes converts attributes in key order.
+func otlpAttributes(attributes map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	keyValues := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		keyValues = append(keyValues, otlpKeyValue{key, otlpAttributeValue(attributes[key])})
+	}
+	return keyValues
+}
+
+func otlpAttributeValue(value interface{}) otlpValue {
+	var integer int64
+	switch v := value.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	case float32:
+		f := float64(v)
+		return otlpValue{DoubleValue: &f}
+	case int:
+		integer = int64(v)
+	case int64:
+		integer = v
+	case int32:
+		integer = int64(v)
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+	s := strconv.FormatInt(integer, 10)
+	return otlpValue{IntValue: &s}
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var exportedSpans = []SpanData{
+	{
+		Name:         "csv decode",
+		Kind:         KindInternal,
+		TraceID:      TraceID{0x4b, 0xf9, 15: 0x36},
+		SpanID:       SpanID{0x01, 7: 0x02},
+		ParentSpanID: SpanID{0x03, 7: 0x04},
+		Start:        time.Unix(1700000000, 0),
+		End:          time.Unix(1700000000, 1500000),
+		Attributes:   map[string]interface{}{"input_bytes": int64(7), "shape": "2x2", "cached": false},
+		Error:        "Invalid CSV.",
+	},
+	{
+		Name:    "POST /sum",
+		Kind:    KindServer,
+		TraceID: TraceID{0x4b, 0xf9, 15: 0x36},
+		SpanID:  SpanID{0x03, 7: 0x04},
+		Start:   time.Unix(1700000000, 0),
+		End:     time.Unix(1700000000, 2000000),
+	},
+}
+
+func TestJSONExporter(t *testing.T) {
+	var out bytes.Buffer
+	if err := NewJSONExporter(&out).Export(context.Background(), exportedSpans); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %q want two lines", out.String())
+	}
+	want := `{"name":"csv decode","kind":"internal","trace_id":"4bf90000000000000000000000000036","span_id":"0100000000000002","parent_span_id":"0300000000000004","start":"2023-11-14T22:13:20Z","end":"2023-11-14T22:13:20.0015Z","duration_ms":1.5,"attributes":{"cached":false,"input_bytes":7,"shape":"2x2"},"error":"Invalid CSV."}`
+	if string(lines[0]) != want {
+		t.Errorf("got %v want %v", string(lines[0]), want)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	// The collector stand-in records the requests it receives.
+	var received []map[string]interface{}
+	status := http.StatusOK
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %v %v %v want a JSON POST to /v1/traces", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var request map[string]interface{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Error(err)
+		}
+		received = append(received, request)
+		w.WriteHeader(status)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/v1/traces", "takehome", collector.Client())
+
+	t.Run("export", func(t *testing.T) {
+		if err := exporter.Export(context.Background(), exportedSpans); err != nil {
+			t.Fatal(err)
+		}
+		if len(received) != 1 {
+			t.Fatalf("got %d requests want 1", len(received))
+		}
+		got, _ := json.Marshal(received[0])
+		want := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"takehome"}}]},"scopeSpans":[{"scope":{"name":"takehome/tracing"},"spans":[` +
+			`{"attributes":[{"key":"cached","value":{"boolValue":false}},{"key":"input_bytes","value":{"intValue":"7"}},{"key":"shape","value":{"stringValue":"2x2"}}],"endTimeUnixNano":"1700000000001500000","kind":1,"name":"csv decode","parentSpanId":"0300000000000004","spanId":"0100000000000002","startTimeUnixNano":"1700000000000000000","status":{"code":2,"message":"Invalid CSV."},"traceId":"4bf90000000000000000000000000036"},` +
+			`{"endTimeUnixNano":"1700000000002000000","kind":2,"name":"POST /sum","spanId":"0300000000000004","startTimeUnixNano":"1700000000000000000","status":{},"traceId":"4bf90000000000000000000000000036"}]}]}]}`
+		if string(got) != want {
+			t.Errorf("got %v want %v", string(got), want)
+		}
+	})
+
+	t.Run("collector error", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		if err := exporter.Export(context.Background(), exportedSpans); err == nil {
+			t.Errorf("got no error want the collector status")
+		}
+	})
+}
@@ -0,0 +1,410 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// W3C Trace Context headers.
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// maxTracestateLength bounds the propagated tracestate header.
+const maxTracestateLength = 512
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// SpanID identifies a span in its trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// FlagSampled is the trace flag asking for the trace to be recorded.
+const FlagSampled byte = 0x01
+
+// SpanContext is the part of a span propagated across services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	// State is the vendor specific tracestate header, propagated as is.
+	State string
+}
+
+// IsValid reports whether both IDs are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Sampled reports whether the trace is recorded.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats the span context as a version 00 traceparent header.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ErrInvalidTraceparent is returned for malformed traceparent headers.
+var ErrInvalidTraceparent = errors.New("Invalid traceparent header.")
+
+// ParseTraceparent parses a traceparent header. Versions above 00 are parsed
+// as version 00, ignoring the fields they add.
+func ParseTraceparent(header string) (SpanContext, error) {
+	var sc SpanContext
+	header = strings.TrimSpace(header)
+	if len(header) < 55 || header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	version, err := decodeHex(header[:2], 1)
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(header) != 55) || (len(header) > 55 && header[55] != '-') {
+		return sc, ErrInvalidTraceparent
+	}
+	traceID, err := decodeHex(header[3:35], 16)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	spanID, err := decodeHex(header[36:52], 8)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	flags, err := decodeHex(header[53:55], 1)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		return sc, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex decodes n bytes of lowercase hex, as required by the header.
+func decodeHex(s string, n int) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, ErrInvalidTraceparent
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil || len(decoded) != n {
+		return nil, ErrInvalidTraceparent
+	}
+	return decoded, nil
+}
+
+// Extract returns the span context propagated by the headers, and whether
+// there is a valid one.
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	if state := strings.Join(header.Values(TracestateHeader), ","); len(state) <= maxTracestateLength {
+		sc.State = state
+	}
+	return sc, true
+}
+
+// Inject sets the headers propagating the span context.
+func Inject(header http.Header, sc SpanContext) {
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.State != "" {
+		header.Set(TracestateHeader, sc.State)
+	} else {
+		header.Del(TracestateHeader)
+	}
+}
+
+// Kind is the role of a span in its trace.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindServer
+)
+
+// SpanData is a completed span, as given to exporters.
+type SpanData struct {
+	Name         string
+	Kind         Kind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	// Error describes why the span failed, "" when it did not.
+	Error string
+}
+
+// Span times an operation of a trace. A nil *Span is valid and records
+// nothing, so instrumented code needs no tracer.
+type Span struct {
+	tracer *Tracer
+	trace  *trace
+	local  bool
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+	state string
+	flags byte
+}
+
+// trace collects the spans of a request, exported together once its root
+// span ends.
+type trace struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// Context returns the span context to propagate.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Flags: s.flags, State: s.state}
+}
+
+// SetName renames the span, e.g. once the route serving a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Name = name
+}
+
+// SetAttribute sets an attribute of the span, a string, bool, integer or
+// float.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed with err.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End completes the span. Ending the first span of the request exports
+// every span of its trace. Calls after the first one do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.flags&FlagSampled == 0 {
+		return
+	}
+	s.trace.mu.Lock()
+	s.trace.spans = append(s.trace.spans, data)
+	var spans []SpanData
+	if s.local {
+		// Copy, as spans ending after the request are still appended.
+		spans = append(spans, s.trace.spans...)
+	}
+	s.trace.mu.Unlock()
+	if s.local {
+		s.tracer.enqueue(spans)
+	}
+}
+
+// Exporter sends completed spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// ErrorHandler is called with the errors of exporters and dropped traces.
+type ErrorHandler func(err error)
+
+// Tracer starts the spans of requests and exports them in the background,
+// so exporting never delays responses. When the export queue is full,
+// traces are dropped.
+type Tracer struct {
+	exporter Exporter
+	onError  ErrorHandler
+	now      func() time.Time
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan []SpanData
+	done   chan struct{}
+}
+
+// queueSize bounds the traces waiting for export.
+const queueSize = 1024
+
+// exportTimeout bounds a single export.
+const exportTimeout = 10 * time.Second
+
+// NewTracer returns a tracer exporting to exporter, reporting export errors
+// to onError. A nil exporter records spans for propagation only.
+func NewTracer(exporter Exporter, onError ErrorHandler) *Tracer {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	t := &Tracer{
+		exporter: exporter,
+		onError:  onError,
+		now:      time.Now,
+		queue:    make(chan []SpanData, queueSize),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	for spans := range t.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		if err := t.exporter.Export(ctx, spans); err != nil {
+			t.onError(err)
+		}
+		cancel()
+	}
+}
+
+func (t *Tracer) enqueue(spans []SpanData) {
+	if t.exporter == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		t.onError(fmt.Errorf("Tracer shut down, dropped %d spans.", len(spans)))
+		return
+	}
+	select {
+	case t.queue <- spans:
+	default:
+		t.onError(fmt.Errorf("Trace export queue full, dropped %d spans.", len(spans)))
+	}
+}
+
+// Shutdown stops accepting spans and waits until the queued ones are
+// exported, or ctx is done.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StartServer starts the root span of a request, continuing the trace of
+// remote when it is valid. Traces started here are sampled.
+func (t *Tracer) StartServer(ctx context.Context, name string, remote SpanContext) (context.Context, *Span) {
+	s := &Span{tracer: t, trace: &trace{}, local: true, flags: FlagSampled}
+	s.data = SpanData{Name: name, Kind: KindServer, SpanID: newSpanID(), Start: t.now()}
+	if remote.IsValid() {
+		s.data.TraceID = remote.TraceID
+		s.data.ParentSpanID = remote.SpanID
+		s.flags = remote.Flags
+		s.state = remote.State
+	} else {
+		s.data.TraceID = newTraceID()
+	}
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+type contextKey int
+
+const spanKey contextKey = 0
+
+// SpanFromContext returns the span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey).(*Span)
+	return s
+}
+
+// Start starts a child span of the span of ctx. Without one, it returns ctx
+// and a nil span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: parent.tracer, trace: parent.trace, flags: parent.flags, state: parent.state}
+	s.data = SpanData{
+		Name:         name,
+		Kind:         KindInternal,
+		TraceID:      parent.data.TraceID,
+		SpanID:       newSpanID(),
+		ParentSpanID: parent.data.SpanID,
+		Start:        parent.tracer.now(),
+	}
+	return context.WithValue(ctx, spanKey, s), s
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		randomBytes(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		randomBytes(id[:])
+	}
+	return id
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
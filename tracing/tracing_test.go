@@ -0,0 +1,177 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingExporter records the exported spans.
+type recordingExporter struct {
+	mu     sync.Mutex
+	traces [][]SpanData
+}
+
+func (re *recordingExporter) Export(ctx context.Context, spans []SpanData) error {
+	re.mu.Lock()
+	defer re.mu.Unlock()
+	re.traces = append(re.traces, spans)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sc, err := ParseTraceparent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled() {
+		t.Errorf("got %+v want the header fields", sc)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Errorf("got %v want %v", got, valid)
+	}
+
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future"); err != nil {
+		t.Errorf("got %v want future versions parsed", err)
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00F067AA0BA902B7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0A",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+	}
+	for _, header := range invalid {
+		if _, err := ParseTraceparent(header); err != ErrInvalidTraceparent {
+			t.Errorf("%q: got %v want %v", header, err, ErrInvalidTraceparent)
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Add(TracestateHeader, "vendor=a")
+	header.Add(TracestateHeader, "other=b")
+
+	sc, ok := Extract(header)
+	if !ok || sc.State != "vendor=a,other=b" {
+		t.Errorf("got %+v, %v want the propagated state", sc, ok)
+	}
+
+	out := http.Header{}
+	Inject(out, sc)
+	if out.Get(TraceparentHeader) != header.Get(TraceparentHeader) || out.Get(TracestateHeader) != "vendor=a,other=b" {
+		t.Errorf("got %v want the propagated headers", out)
+	}
+
+	if _, ok := Extract(http.Header{}); ok {
+		t.Errorf("got a span context from no headers")
+	}
+}
+
+func TestTracer(t *testing.T) {
+	t.Run("spans of a request exported together", func(t *testing.T) {
+		exporter := &recordingExporter{}
+		tracer := NewTracer(exporter, nil)
+		remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		ctx, root := tracer.StartServer(context.Background(), "POST", remote)
+		root.SetName("POST /sum")
+		_, child := Start(ctx, "csv decode")
+		child.SetAttribute("input_bytes", 7)
+		child.SetError(errors.New("Invalid CSV."))
+		child.End()
+		root.End()
+		root.End()
+
+		if err := tracer.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(exporter.traces) != 1 || len(exporter.traces[0]) != 2 {
+			t.Fatalf("got %v want one trace of two spans", exporter.traces)
+		}
+		decode, server := exporter.traces[0][0], exporter.traces[0][1]
+		if server.Name != "POST /sum" || server.Kind != KindServer || server.TraceID != remote.TraceID || server.ParentSpanID != remote.SpanID {
+			t.Errorf("got %+v want the server span continuing the remote trace", server)
+		}
+		if decode.Name != "csv decode" || decode.TraceID != remote.TraceID || decode.ParentSpanID != server.SpanID {
+			t.Errorf("got %+v want a child of the server span", decode)
+		}
+		if decode.Attributes["input_bytes"] != 7 || decode.Error != "Invalid CSV." {
+			t.Errorf("got %+v want the attribute and error", decode)
+		}
+		if decode.End.Before(decode.Start) {
+			t.Errorf("got end %v before start %v", decode.End, decode.Start)
+		}
+	})
+
+	t.Run("new trace without remote", func(t *testing.T) {
+		tracer := NewTracer(nil, nil)
+		_, span := tracer.StartServer(context.Background(), "GET", SpanContext{})
+		if !span.Context().IsValid() || !span.Context().Sampled() {
+			t.Errorf("got %+v want a new sampled trace", span.Context())
+		}
+	})
+
+	t.Run("unsampled traces not exported", func(t *testing.T) {
+		exporter := &recordingExporter{}
+		tracer := NewTracer(exporter, nil)
+		remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+		_, span := tracer.StartServer(context.Background(), "GET", remote)
+		span.End()
+		tracer.Shutdown(context.Background())
+		if len(exporter.traces) != 0 {
+			t.Errorf("got %v want no trace", exporter.traces)
+		}
+	})
+
+	t.Run("no span without tracer", func(t *testing.T) {
+		ctx, span := Start(context.Background(), "validate")
+		if span != nil || SpanFromContext(ctx) != nil {
+			t.Errorf("got span %v want none", span)
+		}
+		span.SetAttribute("ignored", true)
+		span.End()
+	})
+
+	t.Run("shutdown timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		var dropped []error
+		tracer := NewTracer(blockingExporter(release), func(err error) { dropped = append(dropped, err) })
+		_, span := tracer.StartServer(context.Background(), "GET", SpanContext{})
+		span.End()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != context.DeadlineExceeded {
+			t.Errorf("got %v want %v", err, context.DeadlineExceeded)
+		}
+
+		_, span = tracer.StartServer(context.Background(), "GET", SpanContext{})
+		span.End()
+		if len(dropped) != 1 {
+			t.Errorf("got %v want the trace ended after shutdown dropped", dropped)
+		}
+	})
+}
+
+type blockingExporter chan struct{}
+
+func (be blockingExporter) Export(ctx context.Context, spans []SpanData) error {
+	<-be
+	return nil
+}
@@ -0,0 +1,106 @@
+package webhooks
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress fails the connections to addresses callbacks may not
+// reach.
+var ErrForbiddenAddress = errors.New("Callback address is not allowed.")
+
+// internalNetworks are the networks of loopback, private, shared, link-local
+// and unspecified addresses, which are not reachable from the internet and
+// would let callback URLs probe the network of the service, e.g. the
+// metadata endpoint of a cloud at 169.254.169.254.
+var internalNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks, err := ParseNetworks(cidrs)
+	if err != nil {
+		panic(err.Error())
+	}
+	return networks
+}
+
+// ParseNetworks parses networks in CIDR notation, e.g. 10.1.0.0/16, or
+// single addresses.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: value}
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// NewClient returns a client posting notifications, each attempt timing out
+// after timeout. It refuses to connect to internal addresses, and to
+// multicast ones, unless they belong to an allowed network. Addresses are
+// checked as connections are made, so redirects and names resolving to
+// another address once validated are checked too. Proxies are not used, as
+// they would connect in place of the client.
+func NewClient(timeout time.Duration, allowed []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkAddress(address, allowed)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// checkAddress returns ErrForbiddenAddress unless the host:port address may
+// be reached.
+func checkAddress(address string, allowed []*net.IPNet) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrForbiddenAddress
+	}
+	if contains(allowed, ip) {
+		return nil
+	}
+	if ip.IsMulticast() || contains(internalNetworks, ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
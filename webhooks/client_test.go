@@ -0,0 +1,73 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckAddress(t *testing.T) {
+	allowed, err := ParseNetworks([]string{"10.1.0.0/16", "192.168.1.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		address string
+		want    error
+	}{
+		{"93.184.216.34:443", nil},
+		{"[2606:2800:220:1::]:443", nil},
+		{"10.1.2.3:80", nil},
+		{"192.168.1.10:80", nil},
+		{"127.0.0.1:8080", ErrForbiddenAddress},
+		{"169.254.169.254:80", ErrForbiddenAddress},
+		{"10.2.0.1:80", ErrForbiddenAddress},
+		{"172.16.0.1:80", ErrForbiddenAddress},
+		{"192.168.1.11:80", ErrForbiddenAddress},
+		{"100.64.0.1:80", ErrForbiddenAddress},
+		{"0.0.0.0:80", ErrForbiddenAddress},
+		{"224.0.0.1:80", ErrForbiddenAddress},
+		{"[::1]:80", ErrForbiddenAddress},
+		{"[::ffff:127.0.0.1]:80", ErrForbiddenAddress},
+		{"[fe80::1]:80", ErrForbiddenAddress},
+		{"[fd00::1]:80", ErrForbiddenAddress},
+	}
+	for _, test := range tests {
+		if err := checkAddress(test.address, allowed); err != test.want {
+			t.Errorf("%v: got %v want %v", test.address, err, test.want)
+		}
+	}
+
+	if _, err := ParseNetworks([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("got no error want an invalid network")
+	}
+	if _, err := ParseNetworks([]string{"localhost"}); err == nil {
+		t.Errorf("got no error want an invalid address")
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer internal.Close()
+	// Redirects are checked as the first request.
+	redirect := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	client := NewClient(time.Second, nil)
+	for _, url := range []string{internal.URL, redirect.URL} {
+		if _, err := client.Post(url, "application/json", nil); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("%v: got %v want %v", url, err, ErrForbiddenAddress)
+		}
+	}
+
+	loopback, _ := ParseNetworks([]string{"127.0.0.1"})
+	response, err := NewClient(time.Second, loopback).Post(redirect.URL, "application/json", nil)
+	if err != nil || response.StatusCode != http.StatusNoContent {
+		t.Fatalf("got %v want the allowed receiver", err)
+	}
+	response.Body.Close()
+}
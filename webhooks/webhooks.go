@@ -0,0 +1,217 @@
+// Package webhooks notifies clients of the jobs they submitted with a
+// callback URL, once the jobs finished.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	jobs "takehome/jobs"
+	logging "takehome/logging"
+	metrics "takehome/metrics"
+)
+
+// Headers of the notifications. The signature is the hex HMAC-SHA256 of
+// the timestamp, a dot and the body, keyed by the shared secret and
+// prefixed by "sha256=".
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+)
+
+// EventJobFinished is the type of the notification of a finished job.
+const EventJobFinished = "job.finished"
+
+// Results of deliveries.
+const (
+	ResultDelivered  = "delivered"
+	ResultDeadLetter = "dead_letter"
+)
+
+var deliveries = metrics.Default.NewCounterVec("matrix_webhook_deliveries_total",
+	"Job notifications sent, by result: delivered or dead_letter.", "result")
+
+// errShutdown fails the deliveries pending when the notifier shut down.
+var errShutdown = errors.New("Notifier shut down.")
+
+// Event is the JSON body of a notification, the outcome of the job.
+type Event struct {
+	Type string `json:"type"`
+	*jobs.Outcome
+}
+
+// NewEvent returns the notification of the finished job.
+func NewEvent(job *jobs.Job) *Event {
+	return &Event{EventJobFinished, jobs.NewOutcome(job)}
+}
+
+// Sign returns the signature of body sent at timestamp, in Unix seconds.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body sent at
+// timestamp, as receivers check it.
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body)))
+}
+
+// Notifier posts the notification of finished jobs to their callback URL,
+// signed with a shared secret. Failed deliveries, which did not get a 2xx
+// response, are retried up to a number of attempts, waiting twice as long
+// after each, and logged as dead letters once the last attempt failed.
+type Notifier struct {
+	secret   []byte
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	logger   *logging.Logger
+	now      func() time.Time
+
+	// ctx is canceled once the shutdown deadline passed, failing the
+	// deliveries pending.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup
+}
+
+// NewNotifier returns a notifier signing with secret and posting with
+// client, which makes up to attempts attempts per notification, waiting
+// backoff after the first failed one.
+func NewNotifier(secret []byte, client *http.Client, attempts int, backoff time.Duration, logger *logging.Logger) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Notifier{
+		secret:   secret,
+		client:   client,
+		attempts: attempts,
+		backoff:  backoff,
+		logger:   logger,
+		now:      time.Now,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Notify delivers the notification of the finished job in the background,
+// when it has a callback URL.
+func (n *Notifier) Notify(job *jobs.Job) {
+	if job.CallbackURL == "" {
+		return
+	}
+	body, err := json.Marshal(NewEvent(job))
+	if err != nil {
+		n.deadLetter(job, nil, 0, err)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		n.deadLetter(job, body, 0, errShutdown)
+		return
+	}
+	n.pending.Add(1)
+	go n.deliver(job, body)
+}
+
+// Shutdown stops accepting notifications and waits for the pending ones,
+// failing them once ctx is done.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+
+	delivered := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-delivered
+		return ctx.Err()
+	}
+}
+
+func (n *Notifier) deliver(job *jobs.Job, body []byte) {
+	defer n.pending.Done()
+	wait := n.backoff
+	for attempt := 1; ; attempt++ {
+		err := n.post(job.CallbackURL, body)
+		if err == nil {
+			deliveries.Inc(ResultDelivered)
+			n.logger.Debug("webhook delivered", logging.Fields{"job_id": job.ID, "attempts": attempt})
+			return
+		}
+		if attempt >= n.attempts {
+			n.deadLetter(job, body, attempt, err)
+			return
+		}
+		n.logger.Warn("webhook attempt failed", logging.Fields{
+			"job_id":  job.ID,
+			"attempt": attempt,
+			"retry":   wait.String(),
+			"error":   err,
+		})
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-n.ctx.Done():
+			timer.Stop()
+			n.deadLetter(job, body, attempt, errShutdown)
+			return
+		}
+		wait *= 2
+	}
+}
+
+// post makes a delivery attempt.
+func (n *Notifier) post(url string, body []byte) error {
+	r, err := http.NewRequestWithContext(n.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(n.now().Unix(), 10)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(SignatureHeader, Sign(n.secret, timestamp, body))
+	response, err := n.client.Do(r)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Callback answered %d.", response.StatusCode)
+	}
+	return nil
+}
+
+// deadLetter logs a notification which will not be delivered. The body is
+// left out, the result staying in the job store.
+func (n *Notifier) deadLetter(job *jobs.Job, body []byte, attempts int, err error) {
+	deliveries.Inc(ResultDeadLetter)
+	n.logger.Error("webhook dead letter", logging.Fields{
+		"job_id":       job.ID,
+		"callback_url": job.CallbackURL,
+		"attempts":     attempts,
+		"error":        err,
+		"body_bytes":   len(body),
+	})
+}
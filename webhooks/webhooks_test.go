@@ -0,0 +1,123 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jobs "takehome/jobs"
+	logging "takehome/logging"
+)
+
+// syncBuffer is a buffer written by the deliveries and read by the test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+func TestNewEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *jobs.Result
+		want   string
+	}{
+		{"no result", nil, `{"type":"job.finished","job":{"id":"1"`},
+		{"json", &jobs.Result{Status: 422, Header: http.Header{"Content-Type": {"application/problem+json"}}, Body: []byte(`{"code":"MATRIX_EMPTY"}`)},
+			`"result":{"status":422,"content_type":"application/problem+json","body":{"code":"MATRIX_EMPTY"}}}`},
+		{"text", &jobs.Result{Status: 200, Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("1,2\n")},
+			`"result":{"status":200,"content_type":"text/plain","body":"1,2\n"}}`},
+		{"empty", &jobs.Result{Status: 204, Header: http.Header{}}, `"result":{"status":204}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := json.Marshal(NewEvent(&jobs.Job{ID: "1", Result: test.result}))
+			if err != nil || !strings.Contains(string(body), test.want) {
+				t.Errorf("got %s, %v want %s", body, err, test.want)
+			}
+		})
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	secret := []byte("webhook-secret")
+	var mu sync.Mutex
+	var received [][]byte
+	failures := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if !Verify(secret, r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader)) {
+			t.Errorf("got signature %v want a valid one", r.Header.Get(SignatureHeader))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, body)
+		switch {
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusGone)
+		case r.URL.Path == "/flaky" && failures < 2:
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer receiver.Close()
+
+	logs := &syncBuffer{}
+	logger := logging.New(logs, logging.Debug, logging.JSON)
+	n := NewNotifier(secret, receiver.Client(), 3, time.Millisecond, logger)
+	result := &jobs.Result{Status: http.StatusOK, Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("10")}
+	n.Notify(&jobs.Job{ID: "ignored", Status: jobs.StatusSucceeded})
+	n.Notify(&jobs.Job{ID: "flaky", Status: jobs.StatusSucceeded, CallbackURL: receiver.URL + "/flaky", Result: result})
+	n.Notify(&jobs.Job{ID: "gone", Status: jobs.StatusFailed, CallbackURL: receiver.URL + "/gone"})
+	if err := n.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 6 {
+		t.Fatalf("got %v attempts want 6", len(received))
+	}
+	var event Event
+	for _, body := range received {
+		if strings.Contains(string(body), `"id":"flaky"`) {
+			json.Unmarshal(body, &event)
+		}
+	}
+	if event.Type != EventJobFinished || event.Job.Status != jobs.StatusSucceeded || string(event.Result.Body) != `"10"` {
+		t.Errorf("got %+v want the notification of the job", event)
+	}
+	output := logs.String()
+	if !strings.Contains(output, `"msg":"webhook delivered","attempts":3,"job_id":"flaky"`) {
+		t.Errorf("got %v want a delivery after 3 attempts", output)
+	}
+	if !strings.Contains(output, `"msg":"webhook dead letter"`) || !strings.Contains(output, `"job_id":"gone"`) ||
+		!strings.Contains(output, "Callback answered 410.") || !strings.Contains(output, `"body_bytes":`) ||
+		strings.Contains(output, `"event":`) {
+		t.Errorf("got %v want a dead letter", output)
+	}
+
+	// Once shut down, notifications are dead letters.
+	n.Notify(&jobs.Job{ID: "late", CallbackURL: receiver.URL})
+	if !strings.Contains(logs.String(), `"job_id":"late"`) || len(received) != 6 {
+		t.Errorf("got %v want a dead letter", logs.String())
+	}
+}